@@ -52,18 +52,31 @@ func (srv *Server) initAPI(addr string) {
 	handleHTTPRequest(mux, "/gateway/status", srv.gatewayStatusHandler)
 	handleHTTPRequest(mux, "/gateway/peers/add", srv.gatewayPeersAddHandler)
 	handleHTTPRequest(mux, "/gateway/peers/remove", srv.gatewayPeersRemoveHandler)
+	handleHTTPRequest(mux, "/gateway/peers/ban", srv.gatewayPeersBanHandler)
+	handleHTTPRequest(mux, "/gateway/peers/ban/add", srv.gatewayPeersBanAddHandler)
+	handleHTTPRequest(mux, "/gateway/peers/ban/remove", srv.gatewayPeersBanRemoveHandler)
 
 	// Host API Calls
 	if srv.host != nil {
 		handleHTTPRequest(mux, "/host/announce", srv.hostAnnounceHandler)
 		handleHTTPRequest(mux, "/host/configure", srv.hostConfigureHandler)
+		handleHTTPRequest(mux, "/host/contracts", srv.hostContractsHandler)
 		handleHTTPRequest(mux, "/host/status", srv.hostStatusHandler)
 	}
 
 	// HostDB API Calls
 	if srv.hostdb != nil {
+		handleHTTPRequest(mux, "/hostdb/filter", srv.hostdbFilterHandler)
+		handleHTTPRequest(mux, "/hostdb/filter/add", srv.hostdbFilterAddHandler)
+		handleHTTPRequest(mux, "/hostdb/filter/remove", srv.hostdbFilterRemoveHandler)
+		handleHTTPRequest(mux, "/hostdb/hosts/", srv.hostdbHostHandler)
 		handleHTTPRequest(mux, "/hostdb/hosts/active", srv.hostdbHostsActiveHandler)
 		handleHTTPRequest(mux, "/hostdb/hosts/all", srv.hostdbHostsAllHandler)
+		handleHTTPRequest(mux, "/hostdb/hosts/stats", srv.hostdbHostsStatsHandler)
+		handleHTTPRequest(mux, "/hostdb/scan/configure", srv.hostdbScanConfigureHandler)
+		handleHTTPRequest(mux, "/hostdb/scan/start", srv.hostdbScanStartHandler)
+		handleHTTPRequest(mux, "/hostdb/scan/status", srv.hostdbScanStatusHandler)
+		handleHTTPRequest(mux, "/hostdb/score", srv.hostdbScoreHandler)
 	}
 
 	// Miner API Calls
@@ -138,6 +151,12 @@ func (srv *Server) Serve() error {
 	}
 
 	// safely close each module
+	if srv.host != nil {
+		srv.host.Close()
+	}
+	if srv.hostdb != nil {
+		srv.hostdb.Close()
+	}
 	if srv.cs != nil {
 		srv.cs.Close()
 	}
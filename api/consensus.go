@@ -43,7 +43,7 @@ func (srv *Server) consensusSynchronizeHandler(w http.ResponseWriter, req *http.
 
 	// TODO: How should this be handled? Multiple simultaneous peers? First
 	// peer is a bad method.
-	go srv.cs.Synchronize(peers[0])
+	go srv.cs.Synchronize(peers[0].NetAddress)
 
 	writeSuccess(w)
 }
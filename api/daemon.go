@@ -3,6 +3,7 @@ package api
 import (
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"path/filepath"
 	"runtime"
@@ -13,6 +14,7 @@ import (
 	"github.com/kardianos/osext"
 
 	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
 )
 
 type UpdateInfo struct {
@@ -57,10 +59,20 @@ bwIDAQAB
 // manifest.
 var updateURL = "http://23.239.14.98/releases/" + runtime.GOOS + "_" + runtime.GOARCH
 
+// updateClient is used to contact the update server. Like all other outbound
+// connections, its connections are made through the proxy if one is set.
+var updateClient = &http.Client{
+	Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return modules.Dial(modules.NetAddress(addr), 30*time.Second)
+		},
+	},
+}
+
 // getHTTP is a helper function that returns the full response of an HTTP call
 // to the update server.
 func getHTTP(version, filename string) ([]byte, error) {
-	resp, err := http.Get(updateURL + "/" + version + "/" + filename)
+	resp, err := updateClient.Get(updateURL + "/" + version + "/" + filename)
 	if err != nil {
 		return nil, err
 	}
@@ -2,22 +2,29 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/NebulousLabs/Sia/modules"
 )
 
 type GatewayInfo struct {
 	Address modules.NetAddress
-	Peers   []modules.NetAddress
+	ID      modules.NodeID
+	Peers   []modules.Peer
+	Stats   modules.GatewayStats
+}
+
+type GatewayBans struct {
+	Bans []modules.PeerBan
 }
 
 // gatewayStatusHandler handles the API call asking for the gatway status.
 func (srv *Server) gatewayStatusHandler(w http.ResponseWriter, req *http.Request) {
 	peers := srv.gateway.Peers()
 	if peers == nil {
-		peers = make([]modules.NetAddress, 0)
+		peers = make([]modules.Peer, 0)
 	}
-	writeJSON(w, GatewayInfo{srv.gateway.Address(), peers})
+	writeJSON(w, GatewayInfo{srv.gateway.Address(), srv.gateway.ID(), peers, srv.gateway.Stats()})
 }
 
 // gatewayPeersAddHandler handles the API call to add a peer to the gateway.
@@ -43,3 +50,41 @@ func (srv *Server) gatewayPeersRemoveHandler(w http.ResponseWriter, req *http.Re
 
 	writeSuccess(w)
 }
+
+// gatewayPeersBanHandler handles the API call to list the banned peers.
+func (srv *Server) gatewayPeersBanHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, GatewayBans{srv.gateway.BannedPeers()})
+}
+
+// gatewayPeersBanAddHandler handles the API call to ban a peer.
+func (srv *Server) gatewayPeersBanAddHandler(w http.ResponseWriter, req *http.Request) {
+	addr := modules.NetAddress(req.FormValue("address"))
+	var duration time.Duration
+	if d := req.FormValue("duration"); d != "" {
+		var err error
+		duration, err = time.ParseDuration(d)
+		if err != nil {
+			writeError(w, "Malformed duration", http.StatusBadRequest)
+			return
+		}
+	}
+	err := srv.gateway.BanPeer(addr, duration)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeSuccess(w)
+}
+
+// gatewayPeersBanRemoveHandler handles the API call to unban a peer.
+func (srv *Server) gatewayPeersBanRemoveHandler(w http.ResponseWriter, req *http.Request) {
+	addr := modules.NetAddress(req.FormValue("address"))
+	err := srv.gateway.UnbanPeer(addr)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeSuccess(w)
+}
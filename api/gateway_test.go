@@ -54,7 +54,7 @@ func TestGatewayPeerAdd(t *testing.T) {
 
 	var info GatewayInfo
 	st.getAPI("/gateway/status", &info)
-	if len(info.Peers) != 1 || info.Peers[0] != peer.Address() {
+	if len(info.Peers) != 1 || info.Peers[0].NetAddress != peer.Address() {
 		t.Fatal("/gateway/peers/add did not add peer", peer.Address())
 	}
 }
@@ -69,7 +69,7 @@ func TestGatewayPeerRemove(t *testing.T) {
 
 	var info GatewayInfo
 	st.getAPI("/gateway/status", &info)
-	if len(info.Peers) != 1 || info.Peers[0] != peer.Address() {
+	if len(info.Peers) != 1 || info.Peers[0].NetAddress != peer.Address() {
 		t.Fatal("/gateway/peers/add did not add peer", peer.Address())
 	}
 
@@ -80,6 +80,36 @@ func TestGatewayPeerRemove(t *testing.T) {
 	}
 }
 
+// TestGatewayPeerBan checks that peers can be banned and unbanned through the
+// API.
+func TestGatewayPeerBan(t *testing.T) {
+	st := newServerTester("TestGatewayPeerBan", t)
+	peer, err := gateway.New(":0", build.TempDir("api", "TestGatewayPeerBan", "gateway"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.callAPI("/gateway/peers/add?address=" + string(peer.Address()))
+
+	// Banning the peer should disconnect it.
+	st.callAPI("/gateway/peers/ban/add?duration=1h&address=" + string(peer.Address()))
+	var info GatewayInfo
+	st.getAPI("/gateway/status", &info)
+	if len(info.Peers) != 0 {
+		t.Fatal("/gateway/peers/ban/add did not disconnect peer", peer.Address())
+	}
+	var bans GatewayBans
+	st.getAPI("/gateway/peers/ban", &bans)
+	if len(bans.Bans) != 1 || bans.Bans[0].Address != peer.Address() {
+		t.Fatal("/gateway/peers/ban gave bad ban list:", bans.Bans)
+	}
+
+	st.callAPI("/gateway/peers/ban/remove?address=" + string(peer.Address()))
+	st.getAPI("/gateway/peers/ban", &bans)
+	if len(bans.Bans) != 0 {
+		t.Fatal("/gateway/peers/ban/remove did not unban peer:", bans.Bans)
+	}
+}
+
 // TestTransactionRelay checks that an unconfirmed transaction is relayed to
 // all peers.
 func TestTransactionRelay(t *testing.T) {
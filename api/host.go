@@ -3,8 +3,17 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
 )
 
+// HostContracts is the struct that pads the response to the host module call
+// "Contracts".
+type HostContracts struct {
+	Contracts []modules.HostContract
+}
+
 // hostAnnounceHandler handles the API call to get the host to announce itself
 // to the network.
 func (srv *Server) hostAnnounceHandler(w http.ResponseWriter, req *http.Request) {
@@ -27,6 +36,7 @@ func (srv *Server) hostAnnounceHandler(w http.ResponseWriter, req *http.Request)
 func (srv *Server) hostConfigureHandler(w http.ResponseWriter, req *http.Request) {
 	// load current settings
 	config := srv.host.Info().HostSettings
+	limits := srv.host.NetworkLimits()
 
 	// map each query string to a field in the host announcement object
 	qsVars := map[string]interface{}{
@@ -38,9 +48,17 @@ func (srv *Server) hostConfigureHandler(w http.ResponseWriter, req *http.Request
 		"windowsize":   &config.WindowSize,
 		"price":        &config.Price,
 		"collateral":   &config.Collateral,
+
+		"acceptingcontracts": &config.AcceptingContracts,
+	}
+	limitVars := map[string]interface{}{
+		"maxconns":      &limits.MaxConns,
+		"maxconnsperip": &limits.MaxConnsPerIP,
+		"uploadrate":    &limits.UploadRate,
+		"downloadrate":  &limits.DownloadRate,
 	}
 
-	any := false
+	anySettings, anyLimits := false, false
 	for qs := range qsVars {
 		// only modify supplied values
 		if req.FormValue(qs) != "" {
@@ -49,18 +67,52 @@ func (srv *Server) hostConfigureHandler(w http.ResponseWriter, req *http.Request
 				writeError(w, "Malformed "+qs, http.StatusBadRequest)
 				return
 			}
-			any = true
+			anySettings = true
 		}
 	}
-	if !any {
+	for qs := range limitVars {
+		if req.FormValue(qs) != "" {
+			_, err := fmt.Sscan(req.FormValue(qs), limitVars[qs])
+			if err != nil {
+				writeError(w, "Malformed "+qs, http.StatusBadRequest)
+				return
+			}
+			anyLimits = true
+		}
+	}
+	if req.FormValue("rpctimeout") != "" {
+		timeout, err := time.ParseDuration(req.FormValue("rpctimeout"))
+		if err != nil {
+			writeError(w, "Malformed rpctimeout", http.StatusBadRequest)
+			return
+		}
+		limits.RPCTimeout = timeout
+		anyLimits = true
+	}
+	if !anySettings && !anyLimits {
 		writeError(w, "No valid configuration fields specified", http.StatusBadRequest)
 		return
 	}
 
-	srv.host.SetSettings(config)
+	if anySettings {
+		srv.host.SetSettings(config)
+	}
+	if anyLimits {
+		srv.host.SetNetworkLimits(limits)
+	}
 	writeSuccess(w)
 }
 
+// hostContractsHandler handles the API call that lists the host's contract
+// ledger.
+func (srv *Server) hostContractsHandler(w http.ResponseWriter, req *http.Request) {
+	contracts := srv.host.Contracts()
+	if contracts == nil {
+		contracts = make([]modules.HostContract, 0)
+	}
+	writeJSON(w, HostContracts{contracts})
+}
+
 // hostStatusHandler handles the API call that queries the host status.
 func (srv *Server) hostStatusHandler(w http.ResponseWriter, req *http.Request) {
 	writeJSON(w, srv.host.Info())
@@ -1,9 +1,18 @@
 package api
 
 import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
 )
 
 // ActiveHosts is the struct that pads the response to the hostdb module call
@@ -13,19 +22,308 @@ type ActiveHosts struct {
 	Hosts []modules.HostSettings
 }
 
+// HostDBHost is the struct that pads the response to the hostdb module call
+// "Host", adding the contracts that the renter holds with the host.
+type HostDBHost struct {
+	modules.HostDBEntry
+	Contracts []modules.RenterContract
+}
+
+// HostDBStats is the struct that pads the response to the hostdb module call
+// "HostStats".
+type HostDBStats struct {
+	Hosts []modules.HostStats
+}
+
+// hostSortKeys are the orderings supported by the host list calls. Each
+// function reports whether a sorts before b in ascending order.
+var hostSortKeys = map[string]func(a, b modules.HostDBEntry) bool{
+	"address":    func(a, b modules.HostDBEntry) bool { return a.IPAddress < b.IPAddress },
+	"price":      func(a, b modules.HostDBEntry) bool { return a.Price.Cmp(b.Price) < 0 },
+	"collateral": func(a, b modules.HostDBEntry) bool { return a.Collateral.Cmp(b.Collateral) < 0 },
+	"storage":    func(a, b modules.HostDBEntry) bool { return a.RemainingStorage < b.RemainingStorage },
+	"weight":     func(a, b modules.HostDBEntry) bool { return a.Weight.Cmp(b.Weight) < 0 },
+	"uptime":     func(a, b modules.HostDBEntry) bool { return a.Stats.Uptime < b.Stats.Uptime },
+	"latency":    func(a, b modules.HostDBEntry) bool { return a.Stats.LatencyP50 < b.Stats.LatencyP50 },
+}
+
+// hostSorter implements sort.Interface for a list of hosts.
+type hostSorter struct {
+	entries []modules.HostDBEntry
+	less    func(a, b modules.HostDBEntry) bool
+	desc    bool
+}
+
+func (hs hostSorter) Len() int      { return len(hs.entries) }
+func (hs hostSorter) Swap(i, j int) { hs.entries[i], hs.entries[j] = hs.entries[j], hs.entries[i] }
+func (hs hostSorter) Less(i, j int) bool {
+	if hs.desc {
+		return hs.less(hs.entries[j], hs.entries[i])
+	}
+	return hs.less(hs.entries[i], hs.entries[j])
+}
+
+// queryHosts applies the filtering and sorting parameters of a host list call
+// to a list of hosts. Without any parameters, the hosts are returned as-is.
+func (srv *Server) queryHosts(hosts []modules.HostDBEntry, req *http.Request) ([]modules.HostSettings, error) {
+	var subnet *net.IPNet
+	if req.FormValue("subnet") != "" {
+		_, ipnet, err := net.ParseCIDR(req.FormValue("subnet"))
+		if err != nil {
+			return nil, errors.New("malformed subnet")
+		}
+		subnet = ipnet
+	}
+	var maxPrice types.Currency
+	if req.FormValue("maxprice") != "" {
+		if _, err := fmt.Sscan(req.FormValue("maxprice"), &maxPrice); err != nil {
+			return nil, errors.New("malformed maxprice")
+		}
+	}
+	var minUptime float64
+	if req.FormValue("minuptime") != "" {
+		if _, err := fmt.Sscan(req.FormValue("minuptime"), &minUptime); err != nil {
+			return nil, errors.New("malformed minuptime")
+		}
+	}
+	accepting := req.FormValue("accepting")
+	if accepting != "" && accepting != "true" && accepting != "false" {
+		return nil, errors.New("accepting must be 'true' or 'false'")
+	}
+	sortKey := req.FormValue("sort")
+	less, ok := hostSortKeys[sortKey]
+	if sortKey != "" && !ok {
+		return nil, errors.New("unrecognized sort key")
+	}
+	var limit int
+	if req.FormValue("limit") != "" {
+		if _, err := fmt.Sscan(req.FormValue("limit"), &limit); err != nil || limit < 0 {
+			return nil, errors.New("malformed limit")
+		}
+	}
+
+	var entries []modules.HostDBEntry
+	for _, entry := range hosts {
+		if subnet != nil {
+			ip := net.ParseIP(entry.IPAddress.Host())
+			if ip == nil || !subnet.Contains(ip) {
+				continue
+			}
+		}
+		if req.FormValue("maxprice") != "" && entry.Price.Cmp(maxPrice) > 0 {
+			continue
+		}
+		if entry.Stats.Uptime < minUptime {
+			continue
+		}
+		if accepting != "" && entry.AcceptingContracts != (accepting == "true") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if less != nil {
+		sort.Sort(hostSorter{entries, less, req.FormValue("desc") == "true"})
+	}
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	filtered := make([]modules.HostSettings, 0, len(entries))
+	for _, entry := range entries {
+		filtered = append(filtered, entry.HostSettings)
+	}
+	return filtered, nil
+}
+
+// hostdbHostsList writes a list of hosts, filtered and sorted according to
+// the parameters of the call. Only the active hosts are listed if active is
+// true.
+func (srv *Server) hostdbHostsList(w http.ResponseWriter, req *http.Request, active bool) {
+	hosts, err := srv.queryHosts(srv.hostdb.HostEntries(active), req)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, ActiveHosts{Hosts: hosts})
+}
+
 // hostdbHostsActiveHandler handes the API call asking for the list of active
 // hosts.
 func (srv *Server) hostdbHostsActiveHandler(w http.ResponseWriter, req *http.Request) {
-	ah := ActiveHosts{
-		Hosts: srv.hostdb.ActiveHosts(),
-	}
-	writeJSON(w, ah)
+	srv.hostdbHostsList(w, req, true)
 }
 
 // hostdbHostsAllHandler handes the API call asking for the list of all hosts.
 func (srv *Server) hostdbHostsAllHandler(w http.ResponseWriter, req *http.Request) {
-	ah := ActiveHosts{
-		Hosts: srv.hostdb.AllHosts(),
+	srv.hostdbHostsList(w, req, false)
+}
+
+// hostdbHostHandler handles the API call asking for everything the hostdb
+// knows about a single host. The address of the host is the last element of
+// the path, e.g. /hostdb/hosts/1.2.3.4:9982.
+func (srv *Server) hostdbHostHandler(w http.ResponseWriter, req *http.Request) {
+	addr := modules.NetAddress(strings.TrimPrefix(req.URL.Path, "/hostdb/hosts/"))
+	entry, err := srv.hostdb.Host(addr)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	host := HostDBHost{
+		HostDBEntry: entry,
+		Contracts:   make([]modules.RenterContract, 0),
+	}
+	if srv.renter != nil {
+		for _, c := range srv.renter.Contracts() {
+			if c.HostIP == addr {
+				host.Contracts = append(host.Contracts, c)
+			}
+		}
+	}
+	writeJSON(w, host)
+}
+
+// hostdbHostsStatsHandler handles the API call asking for the scan history of
+// every known host.
+func (srv *Server) hostdbHostsStatsHandler(w http.ResponseWriter, req *http.Request) {
+	stats := srv.hostdb.HostStats()
+	if stats == nil {
+		stats = make([]modules.HostStats, 0)
+	}
+	writeJSON(w, HostDBStats{stats})
+}
+
+// hostdbScoreHandler handles the API call asking for the breakdown of the
+// weight of a host.
+func (srv *Server) hostdbScoreHandler(w http.ResponseWriter, req *http.Request) {
+	sb, err := srv.hostdb.ScoreBreakdown(modules.NetAddress(req.FormValue("address")))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, sb)
+}
+
+// hostdbScanStatusHandler handles the API call asking for the scan settings of
+// the hostdb and the depth of its scan queue.
+func (srv *Server) hostdbScanStatusHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, srv.hostdb.ScanStatus())
+}
+
+// hostdbScanConfigureHandler handles the API call to change how often and how
+// many hosts are scanned at once. Only the supplied values are changed.
+func (srv *Server) hostdbScanConfigureHandler(w http.ResponseWriter, req *http.Request) {
+	settings := srv.hostdb.ScanStatus().HostDBScanSettings
+	sleeps := map[string]*time.Duration{
+		"minscansleep": &settings.MinScanSleep,
+		"maxscansleep": &settings.MaxScanSleep,
+	}
+	for qs, sleep := range sleeps {
+		if req.FormValue(qs) == "" {
+			continue
+		}
+		d, err := time.ParseDuration(req.FormValue(qs))
+		if err != nil {
+			writeError(w, "Malformed "+qs, http.StatusBadRequest)
+			return
+		}
+		*sleep = d
+	}
+	if req.FormValue("scanthreads") != "" {
+		_, err := fmt.Sscan(req.FormValue("scanthreads"), &settings.ScanThreads)
+		if err != nil {
+			writeError(w, "Malformed scanthreads", http.StatusBadRequest)
+			return
+		}
+	}
+	err := srv.hostdb.SetScanSettings(settings)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeSuccess(w)
+}
+
+// hostdbScanStartHandler handles the API call to scan a host, or every host if
+// no address is supplied, without waiting for the next round of scanning.
+func (srv *Server) hostdbScanStartHandler(w http.ResponseWriter, req *http.Request) {
+	if req.FormValue("address") == "" {
+		srv.hostdb.ScanAll()
+		writeSuccess(w)
+		return
+	}
+	err := srv.hostdb.ScanHost(modules.NetAddress(req.FormValue("address")))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	writeJSON(w, ah)
+	writeSuccess(w)
+}
+
+// hostdbFilterHandler handles the API call asking for the allow and deny lists
+// of the hostdb.
+func (srv *Server) hostdbFilterHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, srv.hostdb.Filter())
+}
+
+// filterEntry reads a filter list entry from whichever of the 'address',
+// 'pubkey' and 'subnet' parameters is supplied.
+func filterEntry(req *http.Request) (modules.HostFilterList, error) {
+	var entry modules.HostFilterList
+	switch {
+	case req.FormValue("address") != "":
+		entry.Addresses = []modules.NetAddress{modules.NetAddress(req.FormValue("address"))}
+
+	case req.FormValue("pubkey") != "":
+		var pk crypto.PublicKey
+		b, err := hex.DecodeString(req.FormValue("pubkey"))
+		if err != nil || len(b) != len(pk) {
+			return entry, errors.New("malformed pubkey")
+		}
+		copy(pk[:], b)
+		entry.PublicKeys = []crypto.PublicKey{pk}
+
+	case req.FormValue("subnet") != "":
+		entry.Subnets = []string{req.FormValue("subnet")}
+
+	default:
+		return entry, errors.New("one of address, pubkey, or subnet must be supplied")
+	}
+	return entry, nil
+}
+
+// hostdbFilterEdit adds or removes an entry from the allow or deny list of the
+// hostdb.
+func (srv *Server) hostdbFilterEdit(w http.ResponseWriter, req *http.Request, add bool) {
+	var deny bool
+	switch req.FormValue("list") {
+	case "allow":
+	case "deny":
+		deny = true
+	default:
+		writeError(w, "list must be 'allow' or 'deny'", http.StatusBadRequest)
+		return
+	}
+	entry, err := filterEntry(req)
+	if err == nil && add {
+		err = srv.hostdb.AddFilterEntries(deny, entry)
+	} else if err == nil {
+		err = srv.hostdb.RemoveFilterEntries(deny, entry)
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeSuccess(w)
+}
+
+// hostdbFilterAddHandler handles the API call to add an entry to the allow or
+// deny list of the hostdb.
+func (srv *Server) hostdbFilterAddHandler(w http.ResponseWriter, req *http.Request) {
+	srv.hostdbFilterEdit(w, req, true)
+}
+
+// hostdbFilterRemoveHandler handles the API call to remove an entry from the
+// allow or deny list of the hostdb.
+func (srv *Server) hostdbFilterRemoveHandler(w http.ResponseWriter, req *http.Request) {
+	srv.hostdbFilterEdit(w, req, false)
 }
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestHostDBHosts checks the filtering and sorting parameters of the host list
+// calls, and the call returning a single host.
+func TestHostDBHosts(t *testing.T) {
+	st := newServerTester("TestHostDBHosts", t)
+	rejected := func(call string) bool {
+		resp, err := http.Get("http://localhost" + st.server.apiServer.Addr + call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode != http.StatusOK
+	}
+
+	// The hosts are not reachable, so they are only in the list of all hosts.
+	for i, price := range []uint64{3, 1, 2} {
+		err := st.server.hostdb.InsertHost(modules.HostSettings{
+			IPAddress: modules.NetAddress("10.0.0." + string('1'+byte(i)) + ":9982"),
+			Price:     types.NewCurrency64(price),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ah ActiveHosts
+	st.getAPI("/hostdb/hosts/all?sort=price", &ah)
+	if len(ah.Hosts) != 3 || ah.Hosts[0].IPAddress != "10.0.0.2:9982" || ah.Hosts[2].IPAddress != "10.0.0.1:9982" {
+		t.Fatal("hosts were not sorted by price:", ah.Hosts)
+	}
+	st.getAPI("/hostdb/hosts/all?sort=price&desc=true&limit=1", &ah)
+	if len(ah.Hosts) != 1 || ah.Hosts[0].IPAddress != "10.0.0.1:9982" {
+		t.Fatal("wrong hosts for a descending, limited sort:", ah.Hosts)
+	}
+	st.getAPI("/hostdb/hosts/all?subnet=10.0.0.2/31", &ah)
+	if len(ah.Hosts) != 2 {
+		t.Fatal("wrong hosts for a subnet filter:", ah.Hosts)
+	}
+	st.getAPI("/hostdb/hosts/all?maxprice=1&subnet=10.0.0.2/31", &ah)
+	if len(ah.Hosts) != 1 || ah.Hosts[0].IPAddress != "10.0.0.2:9982" {
+		t.Fatal("wrong hosts for a price and subnet filter:", ah.Hosts)
+	}
+	if !rejected("/hostdb/hosts/all?sort=foo") {
+		t.Error("unrecognized sort key was accepted")
+	}
+
+	var host HostDBHost
+	st.getAPI("/hostdb/hosts/10.0.0.3:9982", &host)
+	if host.IPAddress != "10.0.0.3:9982" || host.Price.Cmp(types.NewCurrency64(2)) != 0 {
+		t.Error("wrong host returned:", host.HostSettings)
+	}
+	if host.Contracts == nil {
+		t.Error("contracts should be an empty list")
+	}
+	if !rejected("/hostdb/hosts/10.0.0.9:9982") {
+		t.Error("unknown host was found")
+	}
+}
@@ -102,11 +102,11 @@ func newServerTester(name string, t *testing.T) *serverTester {
 	if err != nil {
 		t.Fatal("Failed to create miner:", err)
 	}
-	hdb, err := hostdb.New(cs, g)
+	hdb, err := hostdb.New(cs, g, filepath.Join(testdir, modules.HostDBDir))
 	if err != nil {
 		t.Fatal("Failed to create hostdb:", err)
 	}
-	h, err := host.New(cs, hdb, tp, w, ":0", filepath.Join(testdir, modules.HostDir))
+	h, err := host.New(cs, g, hdb, tp, w, ":0", filepath.Join(testdir, modules.HostDir))
 	if err != nil {
 		t.Fatal("Failed to create host:", err)
 	}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
@@ -22,6 +23,19 @@ var (
 	ErrOrphan                 = errors.New("block has no known parent")
 )
 
+// peerError wraps err in a modules.PeerError if err shows that a block
+// received from a peer clearly breaks the consensus rules, so that the gateway
+// can ban the peer. Only the header checks and known invalid blocks qualify;
+// other errors, such as orphans, known blocks, and failures while applying the
+// block, are returned unchanged and do not penalize the peer.
+func peerError(err error) error {
+	switch err {
+	case ErrBadMinerPayouts, ErrDoSBlock, ErrEarlyTimestamp, ErrLargeBlock, ErrMissedTarget:
+		return modules.PeerError{Offense: modules.OffenseInvalidBlock, Err: err}
+	}
+	return err
+}
+
 // validHeader does some early, low computation verification on the block.
 func (cs *State) validHeader(b types.Block) error {
 	// Grab the parent of the block and verify the ID of the child meets the
@@ -157,8 +171,8 @@ func (cs *State) AcceptBlock(b types.Block) error {
 		return err
 	}
 
-	// Broadcast the new block to all peers. This is an expensive operation, and not necessary during synchronize or
-	go cs.gateway.Broadcast("RelayBlock", b)
+	// Relay the new block to all peers that do not have it.
+	go cs.gateway.Relay("RelayBlock", crypto.Hash(b.ID()), b)
 
 	return nil
 }
@@ -181,7 +195,28 @@ func (cs *State) RelayBlock(conn modules.PeerConn) error {
 		go cs.Synchronize(modules.NetAddress(conn.RemoteAddr().String()))
 	}
 	if err != nil {
-		return err
+		return peerError(err)
 	}
 	return nil
 }
+
+// hasBlock reports whether the consensus set has seen the block with the
+// given ID, either as a valid block or as a known invalid block. It is used
+// by the gateway to decide whether to request relayed blocks.
+func (cs *State) hasBlock(id crypto.Hash) bool {
+	lockID := cs.mu.RLock()
+	defer cs.mu.RUnlock(lockID)
+	_, valid := cs.blockMap[types.BlockID(id)]
+	_, invalid := cs.dosBlocks[types.BlockID(id)]
+	return valid || invalid
+}
+
+// relayedBlockID returns the ID of an encoded block. It is used by the gateway
+// to check that a relayed block is the one that was announced.
+func relayedBlockID(enc []byte) (crypto.Hash, error) {
+	var b types.Block
+	if err := encoding.Unmarshal(enc, &b); err != nil {
+		return crypto.Hash{}, err
+	}
+	return crypto.Hash(b.ID()), nil
+}
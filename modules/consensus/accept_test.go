@@ -383,3 +383,19 @@ func TestFutureTimestampHandling(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// TestPeerError checks that only blocks that clearly break the consensus rules
+// are reported as peer offenses.
+func TestPeerError(t *testing.T) {
+	for _, err := range []error{ErrBadMinerPayouts, ErrDoSBlock, ErrEarlyTimestamp, ErrLargeBlock, ErrMissedTarget} {
+		pe, ok := peerError(err).(modules.PeerError)
+		if !ok || pe.Offense != modules.OffenseInvalidBlock || pe.Err != err {
+			t.Error("expected an invalid block offense for", err)
+		}
+	}
+	for _, err := range []error{nil, ErrBlockKnown, ErrOrphan, ErrFutureTimestamp, ErrExtremeFutureTimestamp, modules.ErrNonExtendingBlock, ErrMissingSiacoinOutput, errors.New("disk failure")} {
+		if peerError(err) != err {
+			t.Error("expected no offense for", err)
+		}
+	}
+}
@@ -187,7 +187,9 @@ func New(gateway modules.Gateway, saveDir string) (*State, error) {
 	// Register RPCs
 	gateway.RegisterRPC("SendBlocks", cs.sendBlocks)
 	gateway.RegisterRPC("RelayBlock", cs.RelayBlock)
+	gateway.RegisterRelay("RelayBlock", cs.hasBlock, relayedBlockID)
 	gateway.RegisterConnectCall("SendBlocks", cs.receiveBlocks)
+	gateway.AddService(modules.ServiceFullChain)
 
 	// Spawn resynchronize loop.
 	go cs.threadedResynchronize()
@@ -25,7 +25,7 @@ func (s *State) threadedResynchronize() {
 			// NOTE: error is not checked, because nothing happens whether
 			// there is an error or not, the node continuously synchronizes
 			// to all peers.
-			err := s.Synchronize(peer)
+			err := s.Synchronize(peer.NetAddress)
 			if err != nil {
 				continue
 			}
@@ -69,7 +69,7 @@ func (s *State) receiveBlocks(conn modules.PeerConn) error {
 				acceptErr = nil
 			}
 			if acceptErr != nil {
-				return acceptErr
+				return peerError(acceptErr)
 			}
 		}
 	}
@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/socks"
+)
+
+// proxy is the SOCKS5 proxy used by Dial. If it is nil, connections are made
+// directly.
+var (
+	proxy   *socks.Dialer
+	proxyMu sync.RWMutex
+)
+
+// SetProxy routes all connections made by Dial through the SOCKS5 proxy at
+// proxyAddr, which may be given as "user:pass@host:port" if the proxy
+// requires authentication. An empty proxyAddr disables the proxy.
+func SetProxy(proxyAddr string) error {
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+	if proxyAddr == "" {
+		proxy = nil
+		return nil
+	}
+
+	d := new(socks.Dialer)
+	if i := strings.LastIndex(proxyAddr, "@"); i != -1 {
+		creds := proxyAddr[:i]
+		proxyAddr = proxyAddr[i+1:]
+		if j := strings.Index(creds, ":"); j != -1 {
+			d.Username, d.Password = creds[:j], creds[j+1:]
+		} else {
+			d.Username = creds
+		}
+	}
+	if _, _, err := net.SplitHostPort(proxyAddr); err != nil {
+		return err
+	}
+	d.ProxyAddr = proxyAddr
+	proxy = d
+	return nil
+}
+
+// ProxyEnabled returns true if connections are routed through a proxy.
+func ProxyEnabled() bool {
+	proxyMu.RLock()
+	defer proxyMu.RUnlock()
+	return proxy != nil
+}
+
+// Dial connects to addr, through the proxy if one is set. All outbound
+// connections to other nodes should be made with Dial.
+func Dial(addr NetAddress, timeout time.Duration) (net.Conn, error) {
+	proxyMu.RLock()
+	d := proxy
+	proxyMu.RUnlock()
+	if d == nil {
+		return net.DialTimeout("tcp", string(addr), timeout)
+	}
+	return d.DialTimeout(string(addr), timeout)
+}
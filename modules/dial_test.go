@@ -0,0 +1,31 @@
+package modules
+
+import (
+	"testing"
+)
+
+// TestSetProxy checks that proxy addresses and credentials are parsed.
+func TestSetProxy(t *testing.T) {
+	defer SetProxy("")
+
+	if err := SetProxy("127.0.0.1:9050"); err != nil {
+		t.Fatal(err)
+	}
+	if !ProxyEnabled() || proxy.ProxyAddr != "127.0.0.1:9050" || proxy.Username != "" {
+		t.Fatal("proxy was not set correctly:", proxy)
+	}
+
+	if err := SetProxy("user:p@ss@[::1]:1080"); err != nil {
+		t.Fatal(err)
+	}
+	if proxy.ProxyAddr != "[::1]:1080" || proxy.Username != "user" || proxy.Password != "p@ss" {
+		t.Fatal("proxy credentials were not parsed correctly:", proxy)
+	}
+
+	if err := SetProxy("localhost"); err == nil {
+		t.Fatal("expected error for address without port")
+	}
+	if err := SetProxy(""); err != nil || ProxyEnabled() {
+		t.Fatal("proxy was not disabled")
+	}
+}
@@ -1,31 +1,157 @@
 package modules
 
 import (
-	"io"
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
-	"net/http"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
 )
 
 const (
 	GatewayDir = "gateway"
+
+	// ServiceFullChain is advertised by nodes that store the full blockchain
+	// and can send blocks to their peers.
+	ServiceFullChain = "full chain"
+
+	// ServiceHost is advertised by nodes that run a host.
+	ServiceHost = "host"
 )
 
 // TODO: Move this and it's functionality into the gateway package.
 var (
+	// BootstrapPeers are the peers that are connected to on startup when no
+	// other bootstrap peers are configured. They are on the main network.
 	BootstrapPeers = []NetAddress{
 		"23.239.14.98:9981",
 		"87.98.216.46:9981",
 	}
+
+	// Network is the name of the network that the gateway joins. The main
+	// network has no name. Nodes on a private network, such as a test
+	// cluster, only complete handshakes with nodes on the same network, so
+	// they cannot accidentally connect to the main network. Network must be
+	// set before the gateway is created.
+	Network string
 )
 
 // A PeerConn is the connection type used when communicating with peers during
 // an RPC. For now it is identical to a net.Conn.
 type PeerConn interface {
 	net.Conn
-	// AddStrike() ?
+}
+
+// A NodeID is the public key that a node uses to authenticate its peer
+// connections. It is generated once and persists across restarts, so it
+// identifies a node even if its address changes.
+type NodeID crypto.PublicKey
+
+// String returns the hex representation of the NodeID.
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalJSON is implemented on the NodeID to produce a hex string upon
+// marshalling.
+func (id NodeID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+// UnmarshalJSON is implemented on the NodeID to recover a NodeID that has
+// been encoded to a hex string.
+func (id *NodeID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	idBytes, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	} else if len(idBytes) != len(id) {
+		return errors.New("NodeID has wrong length")
+	}
+	copy(id[:], idBytes)
+	return nil
+}
+
+// A Peer is a node that the Gateway is connected to. Version and Services
+// are advertised by the peer when connecting.
+type Peer struct {
+	NetAddress NetAddress
+	ID         NodeID
+	Inbound    bool
+	Version    string
+	Services   []string
+	Stats      PeerStats
+}
+
+// PeerStats contains the traffic exchanged with a peer since connecting.
+// Bytes are counted at the RPC level, and do not include the overhead of
+// encryption and multiplexing.
+type PeerStats struct {
+	BytesIn  uint64
+	BytesOut uint64
+	RPCs     uint64
+	Errors   uint64
+}
+
+// RPCStats contains counters for a single RPC. Latency is the time taken
+// for the whole RPC, including the time spent waiting on the peer.
+type RPCStats struct {
+	Calls      uint64
+	Errors     uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	AvgLatency time.Duration
+	MaxLatency time.Duration
+}
+
+// GatewayStats contains counters for all of the RPCs that the Gateway has
+// called (Outgoing) and handled (Incoming) since starting, keyed by RPC
+// name.
+type GatewayStats struct {
+	BytesIn  uint64
+	BytesOut uint64
+	Outgoing map[string]RPCStats
+	Incoming map[string]RPCStats
+}
+
+// A PeerOffense is a kind of protocol violation committed by a peer. The
+// Gateway penalizes each offense according to its severity, and bans peers
+// that accumulate too many penalties.
+type PeerOffense int
+
+const (
+	OffenseTimeout PeerOffense = iota
+	OffenseMalformedRPC
+	OffenseInvalidBlock
+)
+
+// A PeerError is returned by an RPCFunc when an RPC failed because the remote
+// peer misbehaved, as opposed to a failure of the local node or the network.
+type PeerError struct {
+	Offense PeerOffense
+	Err     error
+}
+
+// Error implements the error interface.
+func (pe PeerError) Error() string {
+	return pe.Err.Error()
+}
+
+// A PeerBan is an address that the Gateway refuses to connect to. Address is
+// either a bare host or a host and port.
+type PeerBan struct {
+	Address NetAddress
+	Expiry  time.Time
+	Reason  string
 }
 
 // RPCFunc is the type signature of functions that handle RPCs. It is used for
@@ -49,6 +175,30 @@ func (na NetAddress) Port() string {
 	return port
 }
 
+// ReadPeersFile reads a list of peer addresses from a file, one address per
+// line. Blank lines and lines beginning with '#' are ignored.
+func ReadPeersFile(filename string) ([]NetAddress, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []NetAddress
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(line); err != nil {
+			return nil, fmt.Errorf("%v:%v: invalid peer address %q", filename, lineNum, line)
+		}
+		addrs = append(addrs, NetAddress(line))
+	}
+	return addrs, scanner.Err()
+}
+
 // A Gateway facilitates the interactions between the local node and remote
 // nodes (peers). It relays incoming blocks and transactions to local modules,
 // and broadcasts outgoing blocks and transactions to peers. In a broad sense,
@@ -64,8 +214,24 @@ type Gateway interface {
 	// Address returns the Gateway's address.
 	Address() NetAddress
 
-	// Peers returns the addresses that the Gateway is currently connected to.
-	Peers() []NetAddress
+	// ID returns the Gateway's node identity.
+	ID() NodeID
+
+	// Peers returns the peers that the Gateway is currently connected to.
+	Peers() []Peer
+
+	// Stats returns the Gateway's RPC and bandwidth counters.
+	Stats() GatewayStats
+
+	// BanPeer disconnects from a peer and refuses to reconnect to it for the
+	// given duration. A duration of 0 uses the default ban duration.
+	BanPeer(NetAddress, time.Duration) error
+
+	// UnbanPeer lifts a ban on a peer.
+	UnbanPeer(NetAddress) error
+
+	// BannedPeers returns the peers that are currently banned.
+	BannedPeers() []PeerBan
 
 	// RegisterRPC registers a function to handle incoming connections that
 	// supply the given RPC ID.
@@ -75,37 +241,31 @@ type Gateway interface {
 	// upon connecting to a peer.
 	RegisterConnectCall(string, RPCFunc)
 
+	// AddService adds a service to the list of services that the Gateway
+	// advertises to its peers, such as ServiceFullChain.
+	AddService(string)
+
 	// RPC calls an RPC on the given address. RPC cannot be called on an
-	// address that the Gateway is not connected to.
+	// address that the Gateway is not connected to, or that has not
+	// advertised support for the RPC.
 	RPC(NetAddress, string, RPCFunc) error
 
 	// Broadcast transmits obj, prefaced by the RPC name, to all of the
-	// Gateway's connected peers in parallel.
+	// Gateway's connected peers that support the RPC, in parallel.
 	Broadcast(name string, obj interface{})
 
+	// RegisterRelay registers an object type that is relayed with Relay.
+	// Objects received from peers are passed to the RPC handler registered
+	// under the same name. has reports whether the node already has the
+	// object with the given ID, and objectID computes the ID of an encoded
+	// object.
+	RegisterRelay(name string, has func(crypto.Hash) bool, objectID func([]byte) (crypto.Hash, error))
+
+	// Relay announces obj, identified by id, to the peers that are not known
+	// to have it. Peers that lack the object request it from the Gateway.
+	// Unlike Broadcast, obj is only sent to peers that need it.
+	Relay(name string, id crypto.Hash, obj interface{})
+
 	// Close safely stops the Gateway's listener process.
 	Close() error
 }
-
-// ExternalIP is the external IP of the computer running this code. It is
-// defined here to facilitate reuse, instead of requiring each module to make
-// an HTTP call. During testing, the loopback address is returned.
-var ExternalIP = func() string {
-	if build.Release == "testing" {
-		return "::1"
-	}
-
-	// timeout after 3 seconds
-	client := http.Client{Timeout: time.Duration(3 * time.Second)}
-	resp, err := client.Get("http://myexternalip.com/raw")
-	if err != nil {
-		return "::1"
-	}
-	defer resp.Body.Close()
-	buf := make([]byte, 64)
-	n, err := resp.Body.Read(buf)
-	if err != nil && err != io.EOF {
-		return "::1"
-	}
-	return string(buf[:n-1]) // trim newline
-}()
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/nat"
+)
+
+// address.go contains the logic for learning the gateway's external address.
+// The address is learned from the router, which also forwards the gateway's
+// port, or failing that from a public address on a network interface. Peers
+// also report the IP that we connected to them from, and if enough peers
+// agree on an IP it is used when no other source is available.
+
+const (
+	// minIPReports is the number of peers, from different subnets, that must
+	// report the same IP before it is adopted as the gateway's external IP.
+	minIPReports = 2
+
+	// maxIPReports is the number of subnets whose reports are remembered.
+	maxIPReports = 64
+
+	// unknownIP is used as the host of the gateway's address until its
+	// external IP is learned.
+	unknownIP = "::1"
+)
+
+// addressKnown returns true if the gateway has learned its external IP.
+func (g *Gateway) addressKnown() bool {
+	ip := net.ParseIP(g.myAddr.Host())
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified()
+}
+
+// setExternalIP sets the host of the gateway's address, and relays the new
+// address to peers. The address is never set when connecting through a proxy,
+// since advertising it would reveal our IP.
+func (g *Gateway) setExternalIP(ip string) {
+	if modules.ProxyEnabled() {
+		return
+	}
+	addr := modules.NetAddress(net.JoinHostPort(ip, g.myAddr.Port()))
+	if addr == g.myAddr {
+		return
+	}
+	g.myAddr = addr
+	g.log.Println("INFO: our address is", g.myAddr)
+	go g.Broadcast("RelayNode", addr)
+}
+
+// reportIP records the IP that a peer saw us connect from. If no better
+// source is available and enough peers agree, the IP becomes the gateway's
+// external IP. Each subnet gets one vote, so that a single attacker cannot
+// easily choose our address.
+func (g *Gateway) reportIP(peer modules.NetAddress, reported string) {
+	ip := net.ParseIP(reported)
+	if !nat.IsPublic(ip) {
+		return
+	}
+	s := subnet(peer)
+	if _, exists := g.ipReports[s]; !exists && len(g.ipReports) >= maxIPReports {
+		return
+	}
+	g.ipReports[s] = ip.String()
+	if g.addressKnown() {
+		return
+	}
+	votes := 0
+	for _, r := range g.ipReports {
+		if r == ip.String() {
+			votes++
+		}
+	}
+	if votes >= minIPReports {
+		g.setExternalIP(ip.String())
+	}
+}
+
+// threadedForwardPort forwards the gateway's port on the router and learns
+// the gateway's external IP. If there is no router, a public interface
+// address is used instead.
+func (g *Gateway) threadedForwardPort(port string) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		g.log.Println("WARN: could not parse port:", err)
+		return
+	}
+	f, ip, err := nat.Forward(uint16(p), "Sia RPC", g.log)
+	if err != nil {
+		g.log.Println("INFO: could not forward port using UPnP or NAT-PMP:", err)
+		if ip, err = nat.InterfaceIP(); err != nil {
+			g.log.Println("INFO: external IP will be learned from peers")
+			return
+		}
+	} else {
+		g.log.Println("INFO: forwarded port", port)
+	}
+
+	id := g.mu.Lock()
+	select {
+	case <-g.closeChan:
+		// The gateway was closed while the port was being forwarded, so
+		// Close will not remove the mapping.
+		g.mu.Unlock(id)
+		if f != nil {
+			if err := f.Close(); err != nil {
+				g.log.Println("WARN: could not remove port mapping:", err)
+			}
+		}
+		return
+	default:
+	}
+	defer g.mu.Unlock(id)
+	g.forwarder = f
+	if nat.IsPublic(ip) {
+		g.setExternalIP(ip.String())
+	} else {
+		// The router is probably behind another NAT.
+		g.log.Println("WARN: router reported a private external IP:", ip)
+	}
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestReportIP checks that an IP reported by peers is only adopted once
+// peers from enough subnets agree on it.
+func TestReportIP(t *testing.T) {
+	g := newTestingGateway("TestReportIP", t)
+	defer g.Close()
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+	port := g.myAddr.Port()
+
+	// Private IPs are ignored.
+	g.reportIP("1.2.3.4:9981", "192.168.1.2")
+	g.reportIP("5.6.7.8:9981", "192.168.1.2")
+	if g.addressKnown() {
+		t.Fatal("private IP was adopted:", g.myAddr)
+	}
+
+	// Peers in the same subnet only get one vote.
+	g.reportIP("1.2.3.4:9981", "203.0.113.5")
+	g.reportIP("1.2.200.1:9981", "203.0.113.5")
+	if g.addressKnown() {
+		t.Fatal("IP was adopted after reports from one subnet:", g.myAddr)
+	}
+	g.reportIP("5.6.7.8:9981", "203.0.113.5")
+	if g.myAddr != modules.NetAddress(net.JoinHostPort("203.0.113.5", port)) {
+		t.Fatal("reported IP was not adopted:", g.myAddr)
+	}
+
+	// Once the address is known, reports do not change it.
+	g.reportIP("9.9.9.9:9981", "198.51.100.1")
+	g.reportIP("10.10.10.10:9981", "198.51.100.1")
+	if g.myAddr.Host() != "203.0.113.5" {
+		t.Fatal("known address was replaced:", g.myAddr)
+	}
+}
+
+// TestRelayNodeReport checks that the RelayNode RPC reports the IP that the
+// caller connected from, including when the caller does not know its own
+// address.
+func TestRelayNodeReport(t *testing.T) {
+	g1 := newTestingGateway("TestRelayNodeReport1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestRelayNodeReport2", t)
+	defer g2.Close()
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported string
+	err := g1.RPC(g2.Address(), "RelayNode", func(conn modules.PeerConn) error {
+		if err := encoding.WriteObject(conn, modules.NetAddress("")); err != nil {
+			return err
+		}
+		return encoding.ReadObject(conn, &reported, maxAddrLength)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := net.ParseIP(reported); ip == nil || !ip.IsLoopback() {
+		t.Fatal("peer reported wrong IP:", reported)
+	}
+	id := g2.mu.RLock()
+	_, exists := g2.nodes[""]
+	g2.mu.RUnlock(id)
+	if exists {
+		t.Fatal("empty address was added to node list")
+	}
+}
+
+// TestProxyHidesAddress checks that a gateway connecting through a proxy
+// never adopts or advertises its external IP.
+func TestProxyHidesAddress(t *testing.T) {
+	g := newTestingGateway("TestProxyHidesAddress", t)
+	defer g.Close()
+
+	// Pretend that g learned its IP before the proxy was set.
+	id := g.mu.Lock()
+	public := modules.NetAddress(net.JoinHostPort("203.0.113.5", g.myAddr.Port()))
+	g.myAddr = public
+	g.mu.Unlock(id)
+
+	if err := modules.SetProxy("127.0.0.1:9050"); err != nil {
+		t.Fatal(err)
+	}
+	defer modules.SetProxy("")
+
+	// The address is not sent to peers.
+	ourConn, theirConn := net.Pipe()
+	defer theirConn.Close()
+	go g.sendAddress(ourConn)
+	var sent modules.NetAddress
+	if err := encoding.ReadObject(theirConn, &sent, maxAddrLength); err != nil {
+		t.Fatal(err)
+	}
+	if sent != "" {
+		t.Fatal("address was advertised while using a proxy:", sent)
+	}
+
+	// IPs learned from the router or from peers are ignored.
+	id = g.mu.Lock()
+	defer g.mu.Unlock(id)
+	g.myAddr = modules.NetAddress(net.JoinHostPort(unknownIP, public.Port()))
+	g.setExternalIP("198.51.100.1")
+	g.reportIP("1.2.3.4:9981", "198.51.100.1")
+	g.reportIP("5.6.7.8:9981", "198.51.100.1")
+	if g.addressKnown() {
+		t.Fatal("external IP was learned while using a proxy:", g.myAddr)
+	}
+}
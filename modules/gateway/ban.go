@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// banThreshold is the misbehavior score at which a peer is disconnected
+	// and banned.
+	banThreshold = 100
+
+	// scoreDecayInterval is the amount of time it takes for one point of a
+	// peer's misbehavior score to be forgiven. This keeps honest peers with
+	// flaky connections from slowly accumulating a ban.
+	scoreDecayInterval = time.Minute
+
+	// defaultBanDuration is how long a peer is banned for when no duration is
+	// specified.
+	defaultBanDuration = 24 * time.Hour
+)
+
+var (
+	// offenseWeights maps each offense to the score it adds. A single invalid
+	// block is enough to be banned; timeouts are common on honest peers and
+	// are penalized lightly.
+	offenseWeights = map[modules.PeerOffense]int{
+		modules.OffenseTimeout:      10,
+		modules.OffenseMalformedRPC: 25,
+		modules.OffenseInvalidBlock: banThreshold,
+	}
+
+	offenseNames = map[modules.PeerOffense]string{
+		modules.OffenseTimeout:      "timeout",
+		modules.OffenseMalformedRPC: "malformed RPC",
+		modules.OffenseInvalidBlock: "invalid block",
+	}
+
+	errPeerBanned = errors.New("peer is banned")
+	errNotBanned  = errors.New("peer is not banned")
+)
+
+// A ban records when a ban expires and why it was issued.
+type ban struct {
+	Expiry time.Time
+	Reason string
+}
+
+// banKey returns the key under which bans on addr are stored. Bans apply to
+// the whole host, since an inbound peer can reconnect from any port. During
+// testing every peer shares the loopback host, so the port is kept.
+func banKey(addr modules.NetAddress) modules.NetAddress {
+	host := addr.Host()
+	if host == "" || build.Release == "testing" {
+		return addr
+	}
+	return modules.NetAddress(host)
+}
+
+// offense returns the offense a peer committed by causing err, if any. Errors
+// that are not the fault of the peer, such as dropped connections, are not
+// penalized.
+func offense(err error) (modules.PeerOffense, bool) {
+	if pe, ok := err.(modules.PeerError); ok {
+		return pe.Offense, true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return modules.OffenseTimeout, true
+	}
+	return 0, false
+}
+
+// isBanned returns true if addr, or the host of addr, is currently banned.
+func (g *Gateway) isBanned(addr modules.NetAddress) bool {
+	for _, key := range []modules.NetAddress{addr, banKey(addr), modules.NetAddress(addr.Host())} {
+		if b, exists := g.bans[key]; exists && time.Now().Before(b.Expiry) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneBans deletes expired bans, so that the ban list does not grow without
+// bound.
+func (g *Gateway) pruneBans() {
+	for key, b := range g.bans {
+		if !time.Now().Before(b.Expiry) {
+			delete(g.bans, key)
+		}
+	}
+}
+
+// ban bans addr for the given duration and disconnects from any peers that
+// fall under the ban.
+func (g *Gateway) ban(addr modules.NetAddress, d time.Duration, reason string) {
+	key := banKey(addr)
+	g.bans[key] = ban{
+		Expiry: time.Now().Add(d),
+		Reason: reason,
+	}
+	for peerAddr, p := range g.peers {
+		if peerAddr == key || banKey(peerAddr) == key || modules.NetAddress(peerAddr.Host()) == key {
+			p.sess.Close()
+			delete(g.peers, peerAddr)
+			g.log.Printf("INFO: disconnected from banned peer %v", peerAddr)
+		}
+	}
+	g.log.Printf("INFO: banned %v for %v: %v", key, d, reason)
+	_ = g.saveBans() // TODO: Some way to communicate that the save failed.
+}
+
+// penalize adds the weight of an offense to a peer's misbehavior score,
+// banning the peer if the score crosses banThreshold.
+func (g *Gateway) penalize(p *peer, o modules.PeerOffense) {
+	// Forgive part of the score according to the time since the last offense.
+	decay := int(time.Since(p.lastOffense) / scoreDecayInterval)
+	if decay > p.score {
+		decay = p.score
+	}
+	p.score += offenseWeights[o] - decay
+	p.lastOffense = time.Now()
+
+	g.log.Printf("WARN: peer %v committed offense (%v), score is now %v", p.addr, offenseNames[o], p.score)
+	if p.score >= banThreshold {
+		g.ban(p.addr, defaultBanDuration, offenseNames[o])
+	}
+}
+
+// handlePeerError penalizes p if err was caused by the peer misbehaving.
+func (g *Gateway) handlePeerError(p *peer, err error) {
+	o, ok := offense(err)
+	if !ok {
+		return
+	}
+	id := g.mu.Lock()
+	g.penalize(p, o)
+	g.mu.Unlock(id)
+}
+
+// BanPeer disconnects from a peer and refuses to reconnect to it for the
+// given duration. A duration of 0 uses the default ban duration.
+func (g *Gateway) BanPeer(addr modules.NetAddress, d time.Duration) error {
+	if addr == "" {
+		return errors.New("no address specified")
+	} else if d < 0 {
+		return errors.New("ban duration cannot be negative")
+	} else if d == 0 {
+		d = defaultBanDuration
+	}
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+	g.ban(addr, d, "manual")
+	return nil
+}
+
+// UnbanPeer lifts a ban on a peer.
+func (g *Gateway) UnbanPeer(addr modules.NetAddress) error {
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+	key := banKey(addr)
+	if _, exists := g.bans[key]; !exists {
+		return errNotBanned
+	}
+	delete(g.bans, key)
+	g.log.Println("INFO: unbanned", key)
+	return g.saveBans()
+}
+
+// BannedPeers returns the peers that are currently banned.
+func (g *Gateway) BannedPeers() []modules.PeerBan {
+	id := g.mu.RLock()
+	defer g.mu.RUnlock(id)
+	bans := make([]modules.PeerBan, 0, len(g.bans))
+	for addr, b := range g.bans {
+		if time.Now().After(b.Expiry) {
+			continue
+		}
+		bans = append(bans, modules.PeerBan{
+			Address: addr,
+			Expiry:  b.Expiry,
+			Reason:  b.Reason,
+		})
+	}
+	return bans
+}
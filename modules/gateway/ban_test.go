@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestPenalize checks that offenses are weighted, that scores decay over
+// time, and that a peer is banned once its score crosses banThreshold.
+func TestPenalize(t *testing.T) {
+	g := newTestingGateway("TestPenalize", t)
+	defer g.Close()
+
+	p := &peer{addr: dummyNode}
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+
+	g.penalize(p, modules.OffenseTimeout)
+	if p.score != offenseWeights[modules.OffenseTimeout] {
+		t.Fatal("wrong score after timeout:", p.score)
+	}
+
+	// Pretend the timeout happened long ago; it should be forgiven.
+	p.lastOffense = time.Now().Add(-time.Hour)
+	g.penalize(p, modules.OffenseMalformedRPC)
+	if p.score != offenseWeights[modules.OffenseMalformedRPC] {
+		t.Fatal("score did not decay:", p.score)
+	}
+	if g.isBanned(p.addr) {
+		t.Fatal("peer banned below threshold")
+	}
+
+	// An invalid block should push the peer over the threshold.
+	g.penalize(p, modules.OffenseInvalidBlock)
+	if !g.isBanned(p.addr) {
+		t.Fatal("peer not banned after sending an invalid block")
+	}
+}
+
+// TestOffense checks that only errors caused by the peer are penalized.
+func TestOffense(t *testing.T) {
+	if _, ok := offense(errors.New("no such file")); ok {
+		t.Error("generic error was treated as an offense")
+	}
+	o, ok := offense(modules.PeerError{Offense: modules.OffenseInvalidBlock, Err: errors.New("bad block")})
+	if !ok || o != modules.OffenseInvalidBlock {
+		t.Error("PeerError was not treated as an invalid block:", o, ok)
+	}
+	o, ok = offense(timeoutErr{})
+	if !ok || o != modules.OffenseTimeout {
+		t.Error("timeout was not treated as a timeout offense:", o, ok)
+	}
+}
+
+// timeoutErr is a net.Error that reports a timeout.
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+// TestBanPeer checks that banned peers are disconnected, cannot be
+// reconnected to, and that bans survive a restart.
+func TestBanPeer(t *testing.T) {
+	g1 := newTestingGateway("TestBanPeer1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestBanPeer2", t)
+	defer g2.Close()
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g1.BanPeer(g2.Address(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(g1.Peers()) != 0 {
+		t.Fatal("banned peer was not disconnected")
+	}
+	if err := g1.Connect(g2.Address()); err != errPeerBanned {
+		t.Fatal("expected errPeerBanned, got", err)
+	}
+	bans := g1.BannedPeers()
+	if len(bans) != 1 || bans[0].Address != g2.Address() || bans[0].Reason != "manual" {
+		t.Fatal("bad ban list:", bans)
+	}
+
+	// The ban should be loaded after a restart.
+	g1.Close()
+	g1, err := New(":0", g1.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g1.Close()
+	if len(g1.BannedPeers()) != 1 {
+		t.Fatal("ban was not persisted:", g1.BannedPeers())
+	}
+
+	if err := g1.UnbanPeer(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g1.UnbanPeer(g2.Address()); err != errNotBanned {
+		t.Fatal("expected errNotBanned, got", err)
+	}
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal("could not connect after unbanning:", err)
+	}
+}
+
+// TestPruneBans checks that expired bans are deleted when the ban list is
+// saved.
+func TestPruneBans(t *testing.T) {
+	g := newTestingGateway("TestPruneBans", t)
+	defer g.Close()
+
+	id := g.mu.Lock()
+	g.bans["1.2.3.4"] = ban{Expiry: time.Now().Add(-time.Minute), Reason: "expired"}
+	g.mu.Unlock(id)
+	if err := g.BanPeer("5.6.7.8:9981", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	id = g.mu.RLock()
+	_, expired := g.bans["1.2.3.4"]
+	numBans := len(g.bans)
+	g.mu.RUnlock(id)
+	if expired || numBans != 1 {
+		t.Fatal("expired ban was not pruned")
+	}
+}
+
+// TestMalformedRPCBan checks that a peer calling unknown RPCs is eventually
+// banned.
+func TestMalformedRPCBan(t *testing.T) {
+	g1 := newTestingGateway("TestMalformedRPCBan1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestMalformedRPCBan2", t)
+	defer g2.Close()
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	// g1.RPC refuses to call RPCs that g2 does not advertise, so the RPC
+	// identifier is written manually.
+	calls := banThreshold / offenseWeights[modules.OffenseMalformedRPC]
+	for i := 0; i < calls; i++ {
+		conn, err := g1.peers[g2.Address()].open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoding.WriteObject(conn, handlerName("Bogus"))
+		conn.Close()
+	}
+
+	// The RPCs are handled asynchronously.
+	for i := 0; i < 100 && len(g2.BannedPeers()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	bans := g2.BannedPeers()
+	if len(bans) != 1 || bans[0].Reason != "malformed RPC" {
+		t.Fatal("peer was not banned for malformed RPCs:", bans)
+	}
+	if len(g2.Peers()) != 0 {
+		t.Fatal("banned peer was not disconnected")
+	}
+}
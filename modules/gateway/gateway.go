@@ -1,25 +1,25 @@
 package gateway
 
 import (
+	"crypto/rand"
 	"errors"
 	"log"
 	"net"
 	"os"
 
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/nat"
 	"github.com/NebulousLabs/Sia/sync"
 )
 
-const (
-	// maxStrikes is the number of "strikes" that can be incurred by a peer
-	// before it will be removed.
-	// TODO: need a way to whitelist peers (e.g. hosts)
-	maxStrikes = 5
-)
-
 var (
-	errNoPeers     = errors.New("no peers")
-	errUnreachable = errors.New("peer did not respond to ping")
+	errNoPeers       = errors.New("no peers")
+	errUnreachable   = errors.New("peer did not respond to ping")
+	errSelfConnect   = errors.New("can't connect to our own address")
+	errAlreadyPeered = errors.New("already connected to that node")
 )
 
 // Gateway implements the modules.Gateway interface.
@@ -27,6 +27,34 @@ type Gateway struct {
 	listener net.Listener
 	myAddr   modules.NetAddress
 
+	// forwarder keeps the gateway's port forwarded on the router, and
+	// ipReports maps the subnets of peers to the IP that they saw us connect
+	// from. See address.go.
+	forwarder *nat.Forwarder
+	ipReports map[string]string
+
+	// closeChan is closed when the gateway is closed.
+	closeChan chan struct{}
+
+	// nonce is chosen randomly when the Gateway starts, and is used to detect
+	// connections to ourselves.
+	nonce uint64
+
+	// services are the services that the Gateway advertises to its peers.
+	// infoVersion is incremented whenever the services or RPCs change, so
+	// that peers that were sent an older nodeInfo can be updated.
+	services    []string
+	infoVersion uint64
+
+	// id is the public key the Gateway uses to authenticate itself to its
+	// peers, and secretKey is the corresponding secret key.
+	id        modules.NodeID
+	secretKey crypto.SecretKey
+
+	// network is the name of the network the Gateway belongs to. It is empty
+	// on the main network. Peers on other networks fail the handshake.
+	network string
+
 	// handlers are the RPCs that the Gateway can handle.
 	handlers map[rpcID]modules.RPCFunc
 	// initRPCs are the RPCs that the Gateway calls upon connecting to a peer.
@@ -36,9 +64,30 @@ type Gateway struct {
 	peers map[modules.NetAddress]*peer
 
 	// nodes is a list of all known nodes (i.e. potential peers) on the
-	// network.
-	// TODO: map to a timestamp?
-	nodes map[modules.NetAddress]struct{}
+	// network, along with their connection history.
+	nodes map[modules.NetAddress]*node
+
+	// anchors are the outbound peers that were saved during the last
+	// shutdown. They are reconnected to first upon startup.
+	anchors []modules.NetAddress
+
+	// bans are the addresses that the Gateway refuses to connect to, mapped
+	// to when the ban expires.
+	bans map[modules.NetAddress]ban
+
+	// relays are the object types relayed with inventory announcements.
+	// inventory holds the encoded objects that peers can request, and seen
+	// and requested track the items that have been received or are being
+	// downloaded. See relay.go.
+	relays         map[string]relay
+	inventory      map[invItem][]byte
+	inventoryOrder []invItem
+	inventorySize  int
+	seen           knownCache
+	requested      map[invItem]bool
+
+	// stats are the counters for the RPCs the Gateway has called and handled.
+	stats *gatewayStats
 
 	persistDir string
 	log        *log.Logger
@@ -52,14 +101,35 @@ func (g *Gateway) Address() modules.NetAddress {
 	return g.myAddr
 }
 
+// ID returns the NodeID of the Gateway.
+func (g *Gateway) ID() modules.NodeID {
+	return g.id
+}
+
 // Close saves the state of the Gateway and stops the listener process.
 func (g *Gateway) Close() error {
-	id := g.mu.RLock()
-	defer g.mu.RUnlock(id)
+	id := g.mu.Lock()
+	select {
+	case <-g.closeChan:
+	default:
+		close(g.closeChan)
+	}
 	err := g.save()
+	if err == nil {
+		err = g.saveAnchors()
+	}
+	forwarder := g.forwarder
+	g.mu.Unlock(id)
 	if err != nil {
 		return err
 	}
+	// Removing the port mapping contacts the router, so it is done without
+	// holding the lock.
+	if forwarder != nil {
+		if err := forwarder.Close(); err != nil {
+			g.log.Println("WARN: could not remove port mapping:", err)
+		}
+	}
 	return g.listener.Close()
 }
 
@@ -81,29 +151,61 @@ func New(addr string, persistDir string) (g *Gateway, err error) {
 		handlers:   make(map[rpcID]modules.RPCFunc),
 		initRPCs:   make(map[string]modules.RPCFunc),
 		peers:      make(map[modules.NetAddress]*peer),
-		nodes:      make(map[modules.NetAddress]struct{}),
+		nodes:      make(map[modules.NetAddress]*node),
+		bans:       make(map[modules.NetAddress]ban),
+		relays:     make(map[string]relay),
+		inventory:  make(map[invItem][]byte),
+		requested:  make(map[invItem]bool),
+		ipReports:  make(map[string]string),
+		closeChan:  make(chan struct{}),
+		stats:      newGatewayStats(),
+		network:    modules.Network,
 		persistDir: persistDir,
 		mu:         sync.New(modules.SafeMutexDelay, 2),
 		log:        logger,
 	}
 
+	// Choose a nonce for detecting connections to ourselves.
+	var nonce [8]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	g.nonce = encoding.DecUint64(nonce[:])
+
 	// Register RPCs.
 	g.RegisterRPC("ShareNodes", g.shareNodes)
 	g.RegisterRPC("RelayNode", g.relayNode)
+	g.RegisterRPC("UpdateInfo", g.updateInfo)
+	g.RegisterRPC("Inv", g.handleInv)
+	g.RegisterRPC("GetData", g.sendData)
 	g.RegisterConnectCall("ShareNodes", g.requestNodes)
 	g.RegisterConnectCall("RelayNode", g.sendAddress)
 
 	g.log.Println("INFO: gateway created, started logging")
 
+	// Load the node identity, creating one if this is the first run.
+	if err = g.loadIdentity(); err != nil {
+		return nil, err
+	}
+	g.log.Println("INFO: our node ID is", g.id)
+	if g.network != "" {
+		g.log.Println("INFO: joining private network", g.network)
+	}
+
 	// Create listener and set address.
 	g.listener, err = net.Listen("tcp", addr)
 	if err != nil {
 		return
 	}
 	_, port, _ := net.SplitHostPort(g.listener.Addr().String())
-	g.myAddr = modules.NetAddress(net.JoinHostPort(modules.ExternalIP, port))
+	g.myAddr = modules.NetAddress(net.JoinHostPort(unknownIP, port))
 
-	g.log.Println("INFO: our address is", g.myAddr)
+	// Forward our port and learn our external IP. This requires contacting
+	// the router, so it is skipped during testing. When connecting through a
+	// proxy, our IP is never learned, so that it is not revealed to peers.
+	if build.Release != "testing" && !modules.ProxyEnabled() {
+		go g.threadedForwardPort(port)
+	}
 
 	// Spawn the primary listener.
 	go g.listen()
@@ -118,6 +220,12 @@ func New(addr string, persistDir string) (g *Gateway, err error) {
 	// peers to ensure we stay well-connected.
 	go g.makeOutboundConnections()
 
+	// Spawn the pruning loop, which evicts nodes that have stopped responding.
+	go g.threadedPruneNodes()
+
+	// Spawn the rotation loop, which periodically replaces outbound peers.
+	go g.threadedRotatePeers()
+
 	return
 }
 
@@ -20,7 +20,7 @@ func newTestingGateway(name string, t *testing.T) *Gateway {
 	// Manually add myAddr as a node. This is necessary because g.addNode
 	// rejects loopback addresses.
 	id := g.mu.Lock()
-	g.nodes[g.myAddr] = struct{}{}
+	g.nodes[g.myAddr] = &node{NetAddress: g.myAddr}
 	g.mu.Unlock(id)
 	return g
 }
@@ -48,7 +48,7 @@ func TestPeers(t *testing.T) {
 		t.Fatal("failed to connect:", err)
 	}
 	peers := g1.Peers()
-	if len(peers) != 1 || peers[0] != g2.Address() {
+	if len(peers) != 1 || peers[0].NetAddress != g2.Address() {
 		t.Fatal("g1 has bad peer list:", peers)
 	}
 	err = g1.Disconnect(g2.Address())
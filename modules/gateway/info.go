@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// minPeerVersion is the lowest version that the gateway will connect to.
+	// NOTE: this version must be bumped whenever the gateway or consensus
+	// breaks compatibility.
+	minPeerVersion = "0.3.3"
+
+	// maxNodeInfoLength is the maximum encoded size of a nodeInfo.
+	maxNodeInfoLength = 4096
+
+	// acceptResponse is sent by the listening side of a connection to accept
+	// the connection, and in response to UpdateInfo. Any other response is
+	// the reason for a rejection.
+	acceptResponse = "accept"
+
+	// updateInfoTimeout is how long a peer has to store our nodeInfo when it
+	// changes.
+	updateInfoTimeout = 10 * time.Second
+)
+
+var (
+	errUnsupportedRPC = errors.New("peer does not support that RPC")
+)
+
+// A nodeInfo is exchanged by peers after the encrypted connection has been
+// established. It tells the peer which protocol version the node speaks and
+// what the node can do. Nonce is chosen randomly each time the gateway starts,
+// and is used to detect connections to ourselves.
+type nodeInfo struct {
+	Version  string
+	RPCs     []string
+	Services []string
+	Nonce    uint64
+}
+
+// name returns the name of the RPC with the given identifier.
+func (id rpcID) name() string {
+	return strings.TrimRight(string(id[:]), "\x00")
+}
+
+// supports returns true if the peer advertised support for the named RPC.
+func (p *peer) supports(name string) bool {
+	id := handlerName(name)
+	for _, rpc := range p.info.RPCs {
+		if handlerName(rpc) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeInfo returns the nodeInfo that the gateway advertises to its peers.
+func (g *Gateway) nodeInfo() nodeInfo {
+	rpcs := make([]string, 0, len(g.handlers))
+	for id := range g.handlers {
+		rpcs = append(rpcs, id.name())
+	}
+	sort.Strings(rpcs)
+	return nodeInfo{
+		Version:  build.Version,
+		RPCs:     rpcs,
+		Services: append([]string(nil), g.services...),
+		Nonce:    g.nonce,
+	}
+}
+
+// checkInfo returns an error if a peer that sent info should not be
+// connected to.
+func (g *Gateway) checkInfo(info nodeInfo) error {
+	if info.Nonce == g.nonce {
+		return errSelfConnect
+	} else if build.VersionCmp(info.Version, minPeerVersion) < 0 {
+		return errors.New("unacceptable version: " + info.Version)
+	}
+	return nil
+}
+
+// requestInfo is the dialing side of the info exchange. It sends our
+// nodeInfo, and receives the peer's response and nodeInfo. It also returns
+// the infoVersion of the nodeInfo that was sent.
+func (g *Gateway) requestInfo(conn modules.PeerConn) (nodeInfo, uint64, error) {
+	id := g.mu.RLock()
+	ours, version := g.nodeInfo(), g.infoVersion
+	g.mu.RUnlock(id)
+	if err := encoding.WriteObject(conn, ours); err != nil {
+		return nodeInfo{}, 0, err
+	}
+	var response string
+	if err := encoding.ReadObject(conn, &response, maxAddrLength); err != nil {
+		return nodeInfo{}, 0, err
+	} else if response == errSelfConnect.Error() {
+		return nodeInfo{}, 0, errSelfConnect
+	} else if response == errAlreadyPeered.Error() {
+		return nodeInfo{}, 0, errAlreadyPeered
+	} else if response != acceptResponse {
+		return nodeInfo{}, 0, errors.New("peer rejected connection: " + response)
+	}
+	var theirs nodeInfo
+	if err := encoding.ReadObject(conn, &theirs, maxNodeInfoLength); err != nil {
+		return nodeInfo{}, 0, err
+	}
+	return theirs, version, g.checkInfo(theirs)
+}
+
+// receiveInfo is the first half of the listening side of the info exchange.
+// It receives the peer's nodeInfo, and if the peer should not be connected
+// to, writes the reason to the peer.
+func (g *Gateway) receiveInfo(conn modules.PeerConn) (nodeInfo, error) {
+	var theirs nodeInfo
+	if err := encoding.ReadObject(conn, &theirs, maxNodeInfoLength); err != nil {
+		return nodeInfo{}, err
+	}
+	if err := g.checkInfo(theirs); err != nil {
+		encoding.WriteObject(conn, err.Error())
+		return nodeInfo{}, err
+	}
+	return theirs, nil
+}
+
+// acceptInfo is the second half of the listening side of the info exchange.
+// It accepts the connection and sends our nodeInfo.
+func acceptInfo(conn modules.PeerConn, ours nodeInfo) error {
+	if err := encoding.WriteObject(conn, acceptResponse); err != nil {
+		return err
+	}
+	return encoding.WriteObject(conn, ours)
+}
+
+// sendInfo sends our current nodeInfo to a peer, waiting until the peer has
+// stored it.
+func (g *Gateway) sendInfo(addr modules.NetAddress) error {
+	return g.RPC(addr, "UpdateInfo", func(conn modules.PeerConn) error {
+		conn.SetDeadline(time.Now().Add(updateInfoTimeout))
+		id := g.mu.RLock()
+		info := g.nodeInfo()
+		g.mu.RUnlock(id)
+		if err := encoding.WriteObject(conn, info); err != nil {
+			return err
+		}
+		var response string
+		if err := encoding.ReadObject(conn, &response, maxAddrLength); err != nil {
+			return err
+		} else if response != acceptResponse {
+			return errors.New("peer rejected info: " + response)
+		}
+		return nil
+	})
+}
+
+// broadcastInfo sends our current nodeInfo to all peers, and waits until they
+// have stored it. It is called when the RPCs or services of the gateway
+// change after peers have connected.
+func (g *Gateway) broadcastInfo() {
+	id := g.mu.RLock()
+	var peers []modules.NetAddress
+	for addr, p := range g.peers {
+		if p.supports("UpdateInfo") {
+			peers = append(peers, addr)
+		}
+	}
+	g.mu.RUnlock(id)
+
+	var wg sync.WaitGroup
+	wg.Add(len(peers))
+	for _, addr := range peers {
+		go func(addr modules.NetAddress) {
+			g.sendInfo(addr)
+			wg.Done()
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// updateInfo is the receiving end of the UpdateInfo RPC. It replaces the
+// stored nodeInfo of the peer that sent it, and then tells the peer that the
+// info was stored.
+func (g *Gateway) updateInfo(conn modules.PeerConn) error {
+	var info nodeInfo
+	if err := encoding.ReadObject(conn, &info, maxNodeInfoLength); err != nil {
+		return err
+	}
+	var err error
+	id := g.mu.Lock()
+	p, exists := g.peers[modules.NetAddress(conn.RemoteAddr().String())]
+	if !exists {
+		err = errors.New("received info from unconnected peer")
+	} else if info.Nonce != p.info.Nonce {
+		err = modules.PeerError{Offense: modules.OffenseMalformedRPC, Err: errors.New("peer changed its nonce")}
+	} else {
+		p.info = info
+	}
+	g.mu.Unlock(id)
+	if err != nil {
+		return err
+	}
+	return encoding.WriteObject(conn, acceptResponse)
+}
+
+// AddService adds a service to the list of services that the gateway
+// advertises to its peers.
+func (g *Gateway) AddService(service string) {
+	id := g.mu.Lock()
+	for _, s := range g.services {
+		if s == service {
+			g.mu.Unlock(id)
+			return
+		}
+	}
+	g.services = append(g.services, service)
+	g.infoVersion++
+	g.mu.Unlock(id)
+	go g.broadcastInfo()
+}
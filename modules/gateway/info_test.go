@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestNodeInfo checks that peers exchange their versions, RPCs, and services
+// when connecting.
+func TestNodeInfo(t *testing.T) {
+	g1 := newTestingGateway("TestNodeInfo1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestNodeInfo2", t)
+	defer g2.Close()
+
+	g2.RegisterRPC("Foo", func(modules.PeerConn) error { return nil })
+	g2.AddService(modules.ServiceHost)
+	g2.AddService(modules.ServiceHost)
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	peers := g1.Peers()
+	if len(peers) != 1 {
+		t.Fatal("g1 has bad peer list:", peers)
+	}
+	if len(peers[0].Services) != 1 || peers[0].Services[0] != modules.ServiceHost {
+		t.Fatal("g1 has wrong services for g2:", peers[0].Services)
+	}
+	id := g1.mu.RLock()
+	p := g1.peers[g2.Address()]
+	fooSupported, barSupported := p.supports("Foo"), p.supports("Bar")
+	g1.mu.RUnlock(id)
+	if !fooSupported || barSupported {
+		t.Fatal("g1 has wrong RPCs for g2:", p.info.RPCs)
+	}
+
+	// Calling an RPC that g2 does not support should fail without
+	// contacting g2.
+	if err := g1.RPC(g2.Address(), "Bar", nil); err != errUnsupportedRPC {
+		t.Fatal("expected errUnsupportedRPC, got", err)
+	}
+}
+
+// TestUpdateInfo checks that RPCs registered after connecting are advertised
+// to existing peers before RegisterRPC returns.
+func TestUpdateInfo(t *testing.T) {
+	g1 := newTestingGateway("TestUpdateInfo1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestUpdateInfo2", t)
+	defer g2.Close()
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	g2.RegisterRPC("Foo", func(conn modules.PeerConn) error {
+		return encoding.WriteObject(conn, "foo")
+	})
+
+	id := g1.mu.RLock()
+	supported := g1.peers[g2.Address()].supports("Foo")
+	g1.mu.RUnlock(id)
+	if !supported {
+		t.Fatal("g1 was not told about g2's new RPC")
+	}
+	var foo string
+	err := g1.RPC(g2.Address(), "Foo", func(conn modules.PeerConn) error {
+		return encoding.ReadObject(conn, &foo, 11)
+	})
+	if err != nil || foo != "foo" {
+		t.Fatal("RPC failed:", foo, err)
+	}
+}
+
+// TestSelfConnectNonce checks that info with our own nonce is rejected.
+func TestSelfConnectNonce(t *testing.T) {
+	g := newTestingGateway("TestSelfConnectNonce", t)
+	defer g.Close()
+	id := g.mu.RLock()
+	info := g.nodeInfo()
+	g.mu.RUnlock(id)
+	if err := g.checkInfo(info); err != errSelfConnect {
+		t.Fatal("expected errSelfConnect, got", err)
+	}
+	info.Nonce++
+	if err := g.checkInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	info.Version = "0.1"
+	if err := g.checkInfo(info); err == nil {
+		t.Fatal("old version was accepted")
+	}
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// A meteredConn is a PeerConn that counts the bytes read from and written to
+// it. A meteredConn wraps a single RPC stream, so the counters do not need to
+// be updated atomically.
+type meteredConn struct {
+	modules.PeerConn
+	bytesIn  uint64
+	bytesOut uint64
+}
+
+// Read implements the io.Reader interface.
+func (mc *meteredConn) Read(p []byte) (int, error) {
+	n, err := mc.PeerConn.Read(p)
+	mc.bytesIn += uint64(n)
+	return n, err
+}
+
+// Write implements the io.Writer interface.
+func (mc *meteredConn) Write(p []byte) (int, error) {
+	n, err := mc.PeerConn.Write(p)
+	mc.bytesOut += uint64(n)
+	return n, err
+}
+
+// rpcStats contains the counters for a single RPC.
+type rpcStats struct {
+	calls        uint64
+	errors       uint64
+	bytesIn      uint64
+	bytesOut     uint64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+}
+
+// gatewayStats contains the counters for every RPC that the gateway has
+// called or handled. It has its own lock so that recording a call does not
+// contend with the Gateway's lock.
+type gatewayStats struct {
+	outgoing map[rpcID]*rpcStats
+	incoming map[rpcID]*rpcStats
+	mu       sync.Mutex
+}
+
+// newGatewayStats returns an empty set of counters.
+func newGatewayStats() *gatewayStats {
+	return &gatewayStats{
+		outgoing: make(map[rpcID]*rpcStats),
+		incoming: make(map[rpcID]*rpcStats),
+	}
+}
+
+// record adds a completed RPC to the gateway's counters and to the counters
+// of the peer it was exchanged with.
+func (gs *gatewayStats) record(p *peer, incoming bool, id rpcID, mc *meteredConn, latency time.Duration, err error) {
+	atomic.AddUint64(&p.bytesIn, mc.bytesIn)
+	atomic.AddUint64(&p.bytesOut, mc.bytesOut)
+	atomic.AddUint64(&p.rpcs, 1)
+	if err != nil {
+		atomic.AddUint64(&p.errors, 1)
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	stats := gs.outgoing
+	if incoming {
+		stats = gs.incoming
+	}
+	s, exists := stats[id]
+	if !exists {
+		s = new(rpcStats)
+		stats[id] = s
+	}
+	s.calls++
+	if err != nil {
+		s.errors++
+	}
+	s.bytesIn += mc.bytesIn
+	s.bytesOut += mc.bytesOut
+	s.totalLatency += latency
+	if latency > s.maxLatency {
+		s.maxLatency = latency
+	}
+}
+
+// stats returns the peer's counters.
+func (p *peer) stats() modules.PeerStats {
+	return modules.PeerStats{
+		BytesIn:  atomic.LoadUint64(&p.bytesIn),
+		BytesOut: atomic.LoadUint64(&p.bytesOut),
+		RPCs:     atomic.LoadUint64(&p.rpcs),
+		Errors:   atomic.LoadUint64(&p.errors),
+	}
+}
+
+// Stats returns the Gateway's RPC and bandwidth counters.
+func (g *Gateway) Stats() modules.GatewayStats {
+	g.stats.mu.Lock()
+	defer g.stats.mu.Unlock()
+	gs := modules.GatewayStats{
+		Outgoing: make(map[string]modules.RPCStats),
+		Incoming: make(map[string]modules.RPCStats),
+	}
+	for _, dir := range []struct {
+		stats map[rpcID]*rpcStats
+		out   map[string]modules.RPCStats
+	}{
+		{g.stats.outgoing, gs.Outgoing},
+		{g.stats.incoming, gs.Incoming},
+	} {
+		for id, s := range dir.stats {
+			dir.out[id.name()] = modules.RPCStats{
+				Calls:      s.calls,
+				Errors:     s.errors,
+				BytesIn:    s.bytesIn,
+				BytesOut:   s.bytesOut,
+				AvgLatency: s.totalLatency / time.Duration(s.calls),
+				MaxLatency: s.maxLatency,
+			}
+			gs.BytesIn += s.bytesIn
+			gs.BytesOut += s.bytesOut
+		}
+	}
+	return gs
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestStats checks that calls, errors, and bytes are counted on both sides of
+// an RPC, for both the RPC and the peer.
+func TestStats(t *testing.T) {
+	g1 := newTestingGateway("TestStats1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestStats2", t)
+	defer g2.Close()
+
+	g2.RegisterRPC("Foo", func(conn modules.PeerConn) error {
+		var i uint64
+		if err := encoding.ReadObject(conn, &i, 8); err != nil {
+			return err
+		}
+		return encoding.WriteObject(conn, "foo")
+	})
+	g2.RegisterRPC("Fail", func(modules.PeerConn) error {
+		return errors.New("failed")
+	})
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := g1.RPC(g2.Address(), "Foo", func(conn modules.PeerConn) error {
+			if err := encoding.WriteObject(conn, uint64(i)); err != nil {
+				return err
+			}
+			var foo string
+			return encoding.ReadObject(conn, &foo, 11)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	g1.RPC(g2.Address(), "Fail", func(modules.PeerConn) error { return nil })
+
+	// g2 records incoming RPCs after the handler returns, which may be after
+	// g1's call has returned.
+	var in modules.RPCStats
+	for i := 0; i < 50; i++ {
+		in = g2.Stats().Incoming["Foo"]
+		if in.Calls == 3 && g2.Stats().Incoming["Fail"].Calls == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Each call writes the identifier and a uint64, and reads a string.
+	out := g1.Stats().Outgoing["Foo"]
+	callOut := uint64(len(encoding.Marshal(handlerName("Foo"))) + 8 + len(encoding.Marshal(uint64(0))) + 8)
+	callIn := uint64(len(encoding.Marshal("foo")) + 8)
+	if out.Calls != 3 || out.Errors != 0 || out.BytesOut != 3*callOut || out.BytesIn != 3*callIn {
+		t.Fatalf("g1 has wrong outgoing stats: %+v", out)
+	}
+	if in.Calls != 3 || in.Errors != 0 || in.BytesIn != 3*callOut || in.BytesOut != 3*callIn {
+		t.Fatalf("g2 has wrong incoming stats: %+v", in)
+	}
+	if out.MaxLatency < out.AvgLatency || out.AvgLatency <= 0 {
+		t.Fatalf("g1 has bad latencies: %+v", out)
+	}
+	if fail := g2.Stats().Incoming["Fail"]; fail.Calls != 1 || fail.Errors != 1 {
+		t.Fatalf("g2 has wrong stats for failing RPC: %+v", fail)
+	}
+
+	// The peer counters should include every RPC with g2, including any
+	// called when connecting.
+	peers := g1.Peers()
+	if len(peers) != 1 {
+		t.Fatal("g1 has bad peer list:", peers)
+	}
+	var total modules.RPCStats
+	for _, s := range g1.Stats().Outgoing {
+		total.Calls += s.Calls
+		total.BytesIn += s.BytesIn
+		total.BytesOut += s.BytesOut
+	}
+	if ps := peers[0].Stats; ps.RPCs != total.Calls || ps.BytesIn != total.BytesIn || ps.BytesOut != total.BytesOut {
+		t.Fatalf("peer stats %+v do not match RPC stats %+v", ps, total)
+	}
+}
+
+// TestStatsUnknownRPC checks that unknown RPCs are not recorded.
+func TestStatsUnknownRPC(t *testing.T) {
+	g := newTestingGateway("TestStatsUnknownRPC", t)
+	defer g.Close()
+
+	p := &peer{addr: "foo"}
+	g.stats.record(p, true, handlerName("Foo"), &meteredConn{bytesIn: 10}, time.Second, nil)
+	if len(g.Stats().Incoming) != 1 || p.stats().BytesIn != 10 {
+		t.Fatal("RPC was not recorded")
+	}
+
+	g2 := newTestingGateway("TestStatsUnknownRPC2", t)
+	defer g2.Close()
+	if err := g2.Connect(g.Address()); err != nil {
+		t.Fatal(err)
+	}
+	id := g2.mu.RLock()
+	p2 := g2.peers[g.Address()]
+	g2.mu.RUnlock(id)
+	conn, err := p2.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoding.WriteObject(conn, handlerName("Bogus"))
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	if _, exists := g.Stats().Incoming["Bogus"]; exists {
+		t.Fatal("unknown RPC was recorded")
+	}
+}
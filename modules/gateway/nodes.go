@@ -4,7 +4,9 @@ import (
 	"errors"
 	"math/rand"
 	"net"
+	"time"
 
+	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 )
@@ -13,8 +15,78 @@ const (
 	maxSharedNodes = 10
 	maxAddrLength  = 100
 	minPeers       = 3
+
+	// maxNodeFailures is the number of consecutive failed connection attempts
+	// after which a node that has not been seen recently is considered stale
+	// and is evicted from the node list.
+	maxNodeFailures = 3
+
+	// recentNodeAge is how recently a node must have been successfully
+	// contacted to be preferred during outbound selection.
+	recentNodeAge = 3 * 24 * time.Hour
+
+	// maxNodeBackoff is the longest a failing node is skipped before it is
+	// tried again.
+	maxNodeBackoff = time.Hour
+)
+
+var (
+	// nodePruneInterval is how often stale nodes are evicted from the node
+	// list.
+	nodePruneInterval = func() time.Duration {
+		switch build.Release {
+		case "testing":
+			return 100 * time.Millisecond
+		default:
+			return 10 * time.Minute
+		}
+	}()
 )
 
+// A node is an entry in the node list. The connection history of each node
+// is tracked so that outbound connections favor nodes that have been
+// reachable recently, and so that dead nodes are eventually forgotten.
+type node struct {
+	NetAddress modules.NetAddress
+
+	// LastSeen is the last time a connection to the node succeeded, and
+	// LastAttempt is the last time a connection was tried. Failures counts
+	// the consecutive failed attempts since the node was last seen.
+	LastSeen    time.Time
+	LastAttempt time.Time
+	Failures    int
+}
+
+// backoff returns how long the node should be skipped after its last
+// attempt. The backoff doubles with each consecutive failure.
+func (n *node) backoff() time.Duration {
+	if n.Failures == 0 {
+		return 0
+	}
+	b := time.Minute << uint(n.Failures-1)
+	if b > maxNodeBackoff || b <= 0 {
+		b = maxNodeBackoff
+	}
+	return b
+}
+
+// weight returns the relative likelihood that the node is selected for an
+// outbound connection. Nodes that were reachable recently are preferred, and
+// each consecutive failure makes a node less likely to be selected.
+func (n *node) weight() float64 {
+	w := 1 / float64((1+n.Failures)*(1+n.Failures))
+	if !n.LastSeen.IsZero() && time.Since(n.LastSeen) < recentNodeAge {
+		w *= 4
+	}
+	return w
+}
+
+// stale returns true if the node has failed repeatedly and has not been seen
+// recently.
+func (n *node) stale() bool {
+	return n.Failures >= maxNodeFailures && time.Since(n.LastSeen) > recentNodeAge
+}
+
 // addNode adds an address to the set of nodes on the network.
 func (g *Gateway) addNode(addr modules.NetAddress) error {
 	if _, exists := g.nodes[addr]; exists {
@@ -24,7 +96,7 @@ func (g *Gateway) addNode(addr modules.NetAddress) error {
 	} else if net.ParseIP(addr.Host()).IsLoopback() {
 		return errors.New("cannot add loopback address")
 	}
-	g.nodes[addr] = struct{}{}
+	g.nodes[addr] = &node{NetAddress: addr}
 	return nil
 }
 
@@ -37,30 +109,102 @@ func (g *Gateway) removeNode(addr modules.NetAddress) error {
 	return nil
 }
 
+// recordAttempt updates the connection history of a node after an attempt to
+// connect to it. Addresses that are not in the node list are ignored.
+func (g *Gateway) recordAttempt(addr modules.NetAddress, success bool) {
+	n, exists := g.nodes[addr]
+	if !exists {
+		return
+	}
+	n.LastAttempt = time.Now()
+	if success {
+		n.LastSeen = n.LastAttempt
+		n.Failures = 0
+	} else {
+		n.Failures++
+	}
+}
+
+// randomNode returns a random node to connect to, weighted in favor of nodes
+// that have been reachable recently. Nodes that are backing off after a
+// failed attempt are skipped.
 func (g *Gateway) randomNode() (modules.NetAddress, error) {
-	if len(g.nodes) > 0 {
-		r := rand.Intn(len(g.nodes))
-		for node := range g.nodes {
-			if r == 0 {
-				return node, nil
-			}
-			r--
+	var total float64
+	for _, n := range g.nodes {
+		if time.Since(n.LastAttempt) >= n.backoff() {
+			total += n.weight()
+		}
+	}
+	if total == 0 {
+		return "", errNoPeers
+	}
+
+	r := rand.Float64() * total
+	var last modules.NetAddress
+	for addr, n := range g.nodes {
+		if time.Since(n.LastAttempt) < n.backoff() {
+			continue
+		}
+		r -= n.weight()
+		last = addr
+		if r < 0 {
+			break
 		}
 	}
+	// If floating point error left r slightly positive, the last eligible
+	// node is returned.
+	return last, nil
+}
 
-	return "", errNoPeers
+// pruneNodes evicts stale nodes from the node list. Nodes are only pruned
+// while the Gateway has peers; if it has none, the failures are more likely
+// the fault of our own connection than of the nodes.
+func (g *Gateway) pruneNodes() {
+	if len(g.peers) == 0 {
+		return
+	}
+	pruned := false
+	for addr, n := range g.nodes {
+		if n.stale() {
+			delete(g.nodes, addr)
+			g.log.Printf("INFO: evicted stale node %v (%v failures, last seen %v)", addr, n.Failures, n.LastSeen)
+			pruned = true
+		}
+	}
+	if pruned {
+		g.save()
+	}
+}
+
+// threadedPruneNodes periodically evicts stale nodes from the node list until
+// the gateway is closed.
+func (g *Gateway) threadedPruneNodes() {
+	for {
+		select {
+		case <-g.closeChan:
+			return
+		case <-time.After(nodePruneInterval):
+		}
+		id := g.mu.Lock()
+		g.pruneNodes()
+		g.mu.Unlock(id)
+	}
 }
 
 // shareNodes is the receiving end of the ShareNodes RPC. It writes up to 10
-// randomly selected nodes to the caller.
+// randomly selected nodes to the caller. Nodes that have failed repeatedly are
+// not shared.
 func (g *Gateway) shareNodes(conn modules.PeerConn) error {
 	id := g.mu.RLock()
 	var nodes []modules.NetAddress
-	for node := range g.nodes {
+	for addr, n := range g.nodes {
 		if len(nodes) == maxSharedNodes {
 			break
 		}
-		nodes = append(nodes, node)
+		if n.Failures >= maxNodeFailures {
+			continue
+		}
+		nodes = append(nodes, addr)
 	}
 	g.mu.RUnlock(id)
 	return encoding.WriteObject(conn, nodes)
@@ -91,6 +235,15 @@ func (g *Gateway) relayNode(conn modules.PeerConn) error {
 	if err := encoding.ReadObject(conn, &addr, maxAddrLength); err != nil {
 		return err
 	}
+	// Report the IP that the peer connected from, so that it can learn its
+	// external IP. Peers that do not read the report close the connection, so
+	// the error is ignored.
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	encoding.WriteObject(conn, host)
+	// An empty address is sent by peers that only want to learn their IP.
+	if addr == "" {
+		return nil
+	}
 	// add node
 	id := g.mu.Lock()
 	defer g.mu.Unlock(id)
@@ -103,11 +256,30 @@ func (g *Gateway) relayNode(conn modules.PeerConn) error {
 	return nil
 }
 
-// sendAddress is the calling end of the RelayNode RPC.
+// sendAddress is the calling end of the RelayNode RPC. It sends our address,
+// or an empty address if we don't know our external IP yet or are connecting
+// through a proxy, and records the IP that the peer reports we connected
+// from, unless we connected through a proxy.
 func (g *Gateway) sendAddress(conn modules.PeerConn) error {
-	// don't send if we aren't connectible
-	if g.Address().Host() == "::1" {
-		return errors.New("can't send address without knowing external IP")
+	id := g.mu.RLock()
+	addr := g.myAddr
+	if !g.addressKnown() || modules.ProxyEnabled() {
+		addr = ""
 	}
-	return encoding.WriteObject(conn, g.Address())
+	g.mu.RUnlock(id)
+	if err := encoding.WriteObject(conn, addr); err != nil {
+		return err
+	}
+	var reported string
+	if err := encoding.ReadObject(conn, &reported, maxAddrLength); err != nil {
+		// Older peers do not report our IP.
+		return nil
+	} else if modules.ProxyEnabled() {
+		// The peer saw the proxy's IP, not ours.
+		return nil
+	}
+	id = g.mu.Lock()
+	g.reportIP(modules.NetAddress(conn.RemoteAddr().String()), reported)
+	g.mu.Unlock(id)
+	return nil
 }
@@ -95,6 +95,119 @@ func TestRandomNode(t *testing.T) {
 	}
 }
 
+// TestRandomNodePreference checks that randomNode prefers recently seen
+// nodes and skips nodes that are backing off.
+func TestRandomNodePreference(t *testing.T) {
+	g := newTestingGateway("TestRandomNodePreference", t)
+	defer g.Close()
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+
+	g.removeNode(g.myAddr)
+	good := modules.NetAddress("111.111.111.111:1111")
+	unseen := modules.NetAddress("111.111.111.111:2222")
+	failing := modules.NetAddress("111.111.111.111:3333")
+	for _, addr := range []modules.NetAddress{good, unseen, failing} {
+		g.addNode(addr)
+	}
+	g.recordAttempt(good, true)
+	g.recordAttempt(failing, false)
+
+	counts := make(map[modules.NetAddress]int)
+	for i := 0; i < 1000; i++ {
+		addr, err := g.randomNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[addr]++
+	}
+	if counts[failing] != 0 {
+		t.Error("node was selected during its backoff:", counts)
+	}
+	if counts[good] < 2*counts[unseen] {
+		t.Error("recently seen node was not preferred:", counts)
+	}
+
+	// Once the backoff has expired, the failing node is eligible again.
+	g.nodes[failing].LastAttempt = time.Now().Add(-maxNodeBackoff)
+	for i := 0; i < 1000 && counts[failing] == 0; i++ {
+		addr, _ := g.randomNode()
+		counts[addr]++
+	}
+	if counts[failing] == 0 {
+		t.Error("node was never selected after its backoff expired")
+	}
+}
+
+// TestPruneNodes checks that nodes are evicted only after failing repeatedly,
+// and only while the Gateway has peers.
+func TestPruneNodes(t *testing.T) {
+	g := newTestingGateway("TestPruneNodes", t)
+	defer g.Close()
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+
+	g.addNode(dummyNode)
+	for i := 0; i < maxNodeFailures; i++ {
+		g.recordAttempt(dummyNode, false)
+	}
+	g.pruneNodes()
+	if _, exists := g.nodes[dummyNode]; !exists {
+		t.Fatal("node was pruned while the gateway had no peers")
+	}
+
+	g.peers["foo"] = &peer{addr: "foo"}
+	defer delete(g.peers, "foo")
+	g.recordAttempt(g.myAddr, false)
+	g.pruneNodes()
+	if _, exists := g.nodes[dummyNode]; exists {
+		t.Fatal("stale node was not pruned")
+	}
+	if _, exists := g.nodes[g.myAddr]; !exists {
+		t.Fatal("node was pruned after a single failure")
+	}
+}
+
+// TestConnectRecordsAttempt checks that Connect records successes and
+// failures in the node list.
+func TestConnectRecordsAttempt(t *testing.T) {
+	g1 := newTestingGateway("TestConnectRecordsAttempt1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestConnectRecordsAttempt2", t)
+	defer g2.Close()
+
+	id := g1.mu.Lock()
+	g1.nodes[g2.Address()] = &node{NetAddress: g2.Address(), Failures: 2}
+	g1.mu.Unlock(id)
+	// g1's outbound connection loop may have connected to g2 first
+	if err := g1.Connect(g2.Address()); err != nil && err != errAlreadyPeered {
+		t.Fatal(err)
+	}
+	id = g1.mu.RLock()
+	n := *g1.nodes[g2.Address()]
+	g1.mu.RUnlock(id)
+	if n.Failures != 0 || n.LastSeen.IsZero() || n.LastSeen != n.LastAttempt {
+		t.Fatal("successful connection was not recorded:", n)
+	}
+
+	// Connecting to a closed port should fail and be recorded.
+	g2.Close()
+	id = g1.mu.Lock()
+	g1.nodes[g2.Address()] = &node{NetAddress: g2.Address()}
+	delete(g1.peers, g2.Address())
+	g1.mu.Unlock(id)
+	if err := g1.Connect(g2.Address()); err == nil {
+		t.Fatal("connected to closed gateway")
+	}
+	id = g1.mu.RLock()
+	n = *g1.nodes[g2.Address()]
+	g1.mu.RUnlock(id)
+	// g1's outbound connection loop may also have tried g2
+	if n.Failures == 0 || n.LastAttempt.IsZero() {
+		t.Fatal("failed connection was not recorded:", n)
+	}
+}
+
 func TestShareNodes(t *testing.T) {
 	g1 := newTestingGateway("TestShareNodes1", t)
 	defer g1.Close()
@@ -119,10 +232,10 @@ func TestShareNodes(t *testing.T) {
 
 	// remove all nodes from both peers
 	id = g1.mu.Lock()
-	g1.nodes = map[modules.NetAddress]struct{}{}
+	g1.nodes = make(map[modules.NetAddress]*node)
 	g1.mu.Unlock(id)
 	id = g2.mu.Lock()
-	g2.nodes = map[modules.NetAddress]struct{}{}
+	g2.nodes = make(map[modules.NetAddress]*node)
 	g2.mu.Unlock(id)
 
 	// SharePeers should now return no peers
@@ -7,7 +7,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 
@@ -23,10 +22,38 @@ const (
 )
 
 type peer struct {
-	strikes uint32
-	addr    modules.NetAddress
-	sess    muxado.Session
-	inbound bool
+	// Implementation note: the counters are declared first to ensure that
+	// they are 64-bit aligned, which is necessary for atomic operations on ARM
+	// and x86-32.
+	bytesIn  uint64
+	bytesOut uint64
+	rpcs     uint64
+	errors   uint64
+
+	addr        modules.NetAddress
+	id          modules.NodeID
+	sess        muxado.Session
+	inbound     bool
+	connectedAt time.Time
+
+	// info is the nodeInfo advertised by the peer. It is protected by the
+	// Gateway's lock. infoVersion is the infoVersion of the nodeInfo that the
+	// peer was sent when connecting.
+	info        nodeInfo
+	infoVersion uint64
+
+	// ready is closed once the peer has been sent our nodeInfo, after which
+	// RPCs can be called on it. It is nil if the peer was ready when added.
+	ready chan struct{}
+
+	// score is the peer's misbehavior score. It is protected by the Gateway's
+	// lock.
+	score       int
+	lastOffense time.Time
+
+	// known is the set of relayed items that the peer is known to have. It is
+	// protected by the Gateway's lock.
+	known knownCache
 }
 
 func (p *peer) open() (modules.PeerConn, error) {
@@ -46,16 +73,56 @@ func (p *peer) accept() (modules.PeerConn, error) {
 }
 
 // addPeer adds a peer to the Gateway's peer list and spawns a listener thread
-// to handle its requests.
-func (g *Gateway) addPeer(p *peer) {
+// to handle its requests. A node may only be connected once, regardless of
+// which address it connects from. The limits on inbound peers are checked
+// here, under the same lock as the insert, so that concurrent connections
+// cannot exceed them. If the Gateway already has the maximum number of
+// inbound peers, an old inbound peer is kicked out to make room for a new one.
+func (g *Gateway) addPeer(p *peer) error {
+	if p.id == g.id {
+		return errSelfConnect
+	}
+	for _, existing := range g.peers {
+		if existing.id == p.id {
+			return errAlreadyPeered
+		}
+	}
+	if p.inbound {
+		if err := g.checkInbound(p.addr); err != nil {
+			return err
+		}
+
+		// Among other things, evicting an old peer ensures that bootstrap
+		// nodes will always be connectible. Worst case, you'll connect,
+		// receive a node list, and immediately get booted. But once you have
+		// the node list you should be able to connect to less full peers.
+		// Slots reserved for outbound peers are never given to inbound peers.
+		if g.numPeers(true) >= maxInboundPeers {
+			oldPeer := g.randomInboundPeer()
+			if old, exists := g.peers[oldPeer]; exists {
+				old.sess.Close()
+				delete(g.peers, oldPeer)
+				g.log.Printf("INFO: disconnected from %v to make room for %v", oldPeer, p.addr)
+			}
+		}
+	}
+	p.connectedAt = time.Now()
 	g.peers[p.addr] = p
 	go g.listenPeer(p)
+
+	// If our RPCs or services changed after the peer was sent our nodeInfo,
+	// the peer missed the update.
+	if p.infoVersion != g.infoVersion {
+		go g.sendInfo(p.addr)
+	}
+	return nil
 }
 
-// randomInboundPeer returns a random peer that initiated its connection.
+// randomInboundPeer returns a random peer that initiated its connection, or
+// "" if there are no inbound peers.
 func (g *Gateway) randomInboundPeer() modules.NetAddress {
-	if len(g.peers) > 0 {
-		r := rand.Intn(len(g.peers))
+	if n := g.numPeers(true); n > 0 {
+		r := rand.Intn(n)
 		for addr, peer := range g.peers {
 			// only select inbound peers
 			if !peer.inbound {
@@ -89,106 +156,119 @@ func (g *Gateway) acceptConn(conn net.Conn) {
 	addr := modules.NetAddress(conn.RemoteAddr().String())
 	g.log.Printf("INFO: %v wants to connect", addr)
 
-	// don't connect to an IP address more than once
-	if build.Release != "testing" {
-		id := g.mu.RLock()
-		for p := range g.peers {
-			if p.Host() == addr.Host() {
-				g.mu.RUnlock(id)
-				conn.Close()
-				g.log.Printf("INFO: rejected connection from %v: already connected", addr)
-				return
-			}
-		}
-		g.mu.RUnlock(id)
+	// don't accept connections from banned peers
+	id := g.mu.RLock()
+	banned := g.isBanned(addr)
+	g.mu.RUnlock(id)
+	if banned {
+		conn.Close()
+		g.log.Printf("INFO: rejected connection from %v: peer is banned", addr)
+		return
+	}
+
+	// Don't connect to an IP address more than once, and limit the number of
+	// inbound peers from the same subnet. These checks are repeated by
+	// addPeer, but checking here avoids a wasted handshake.
+	id = g.mu.RLock()
+	err := g.checkInbound(addr)
+	g.mu.RUnlock(id)
+	if err != nil {
+		conn.Close()
+		g.log.Printf("INFO: rejected connection from %v: %v", addr, err)
+		return
 	}
 
-	// read version
-	var remoteVersion string
-	if err := encoding.ReadObject(conn, &remoteVersion, maxAddrLength); err != nil {
+	// establish an encrypted connection
+	sc, remoteID, err := g.handshake(conn, false)
+	if err != nil {
 		conn.Close()
-		g.log.Printf("INFO: %v wanted to connect, but we could not read their version: %v", addr, err)
+		g.log.Printf("INFO: %v wanted to connect, but the handshake failed: %v", addr, err)
 		return
 	}
 
-	// decide whether to accept
-	// NOTE: this version must be bumped whenever the gateway or consensus
-	// breaks compatibility.
-	if build.VersionCmp(remoteVersion, "0.3.3") < 0 {
-		encoding.WriteObject(conn, "reject")
+	// receive node info, deciding whether to accept the peer
+	info, err := g.receiveInfo(sc)
+	if err != nil {
 		conn.Close()
-		g.log.Printf("INFO: %v wanted to connect, but their version (%v) was unacceptable", addr, remoteVersion)
+		g.log.Printf("INFO: rejected connection from %v: %v", addr, err)
 		return
 	}
 
-	// respond with our version
-	if err := encoding.WriteObject(conn, "0.3.3"); err != nil {
+	// Add the peer, and build the nodeInfo sent to it under the same lock, so
+	// that any RPC registered later is broadcast to the peer. RPCs called on
+	// the peer wait until the nodeInfo has been sent.
+	p := &peer{addr: addr, id: remoteID, info: info, sess: muxado.Server(sc), inbound: true, ready: make(chan struct{})}
+	id = g.mu.Lock()
+	p.infoVersion = g.infoVersion
+	ours := g.nodeInfo()
+	err = g.addPeer(p)
+	g.mu.Unlock(id)
+	if err != nil {
+		encoding.WriteObject(sc, err.Error())
 		conn.Close()
-		g.log.Printf("INFO: could not write version ack to %v: %v", addr, err)
+		g.log.Printf("INFO: rejected connection from %v: %v", addr, err)
 		return
 	}
-	// TODO: connecting peer may disconnect at this point if they reject our
-	// version. This could be handled more gracefully.
-
-	// If we are already fully connected, kick out an old inbound peer to make
-	// room for the new one. Among other things, this ensures that bootstrap
-	// nodes will always be connectible. Worst case, you'll connect, receive a
-	// node list, and immediately get booted. But once you have the node list
-	// you should be able to connect to less full peers.
-	id := g.mu.Lock()
-	if len(g.peers) >= fullyConnectedThreshold {
-		oldPeer := g.randomInboundPeer()
-		g.peers[oldPeer].sess.Close()
-		delete(g.peers, oldPeer)
-		g.log.Printf("INFO: disconnected from %v to make room for %v", oldPeer, addr)
+	err = acceptInfo(sc, ours)
+	close(p.ready)
+	if err != nil {
+		g.Disconnect(addr)
+		g.log.Printf("INFO: could not send node info to %v: %v", addr, err)
+		return
 	}
-	// add the peer
-	g.addPeer(&peer{addr: addr, sess: muxado.Server(conn), inbound: true})
-	g.mu.Unlock(id)
 
-	g.log.Printf("INFO: accepted connection from new peer %v (v%v)", addr, remoteVersion)
+	g.log.Printf("INFO: accepted connection from new peer %v (v%v, ID %v)", addr, info.Version, remoteID)
 }
 
 // Connect establishes a persistent connection to a peer, and adds it to the
-// Gateway's peer list.
-func (g *Gateway) Connect(addr modules.NetAddress) error {
+// Gateway's peer list. The outcome is recorded in the node list.
+func (g *Gateway) Connect(addr modules.NetAddress) (err error) {
 	if addr == g.Address() {
-		return errors.New("can't connect to our own address")
+		return errSelfConnect
 	}
 
 	id := g.mu.RLock()
 	_, exists := g.peers[addr]
+	banned := g.isBanned(addr)
 	g.mu.RUnlock(id)
 	if exists {
 		return errors.New("peer already added")
+	} else if banned {
+		return errPeerBanned
 	}
 
-	conn, err := net.DialTimeout("tcp", string(addr), dialTimeout)
+	defer func() {
+		id := g.mu.Lock()
+		g.recordAttempt(addr, err == nil || err == errAlreadyPeered)
+		g.mu.Unlock(id)
+	}()
+
+	conn, err := modules.Dial(addr, dialTimeout)
 	if err != nil {
 		return err
 	}
-	// send our version
-	if err := encoding.WriteObject(conn, "0.3.3"); err != nil {
-		return err
-	}
-	// read version ack
-	var remoteVersion string
-	if err := encoding.ReadObject(conn, &remoteVersion, maxAddrLength); err != nil {
+	// establish an encrypted connection
+	sc, remoteID, err := g.handshake(conn, true)
+	if err != nil {
+		conn.Close()
 		return err
-	} else if remoteVersion == "reject" {
-		return errors.New("peer rejected connection")
 	}
-	// decide whether to accept this version
-	if build.VersionCmp(remoteVersion, "0.3.3") < 0 {
+	// exchange node info
+	info, version, err := g.requestInfo(sc)
+	if err != nil {
 		conn.Close()
-		return errors.New("unacceptable version: " + remoteVersion)
+		return err
 	}
 
-	g.log.Println("INFO: connected to new peer", addr)
-
 	id = g.mu.Lock()
-	g.addPeer(&peer{addr: addr, sess: muxado.Client(conn), inbound: false})
+	err = g.addPeer(&peer{addr: addr, id: remoteID, info: info, infoVersion: version, sess: muxado.Client(sc), inbound: false})
 	g.mu.Unlock(id)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	g.log.Printf("INFO: connected to new peer %v (v%v, ID %v)", addr, info.Version, remoteID)
 
 	// call initRPCs
 	id = g.mu.RLock()
@@ -225,38 +305,49 @@ func (g *Gateway) Disconnect(addr modules.NetAddress) error {
 	return nil
 }
 
-// makeOutboundConnections tries to keep the Gateway well-connected. As long
-// as the Gateway is not well-connected, it tries to add random nodes as
-// peers. It sleeps when the Gateway becomes well-connected, or it has tried
-// more than 100 nodes.
+// makeOutboundConnections tries to keep the Gateway well-connected. It first
+// reconnects to the anchors saved during the last shutdown. Then, as long as
+// the Gateway has fewer than wellConnectedThreshold outbound peers, it tries
+// to add random nodes as peers, skipping nodes in subnets that already have
+// an outbound peer. It sleeps when the Gateway becomes well-connected, or it
+// has tried more than 100 nodes.
 func (g *Gateway) makeOutboundConnections() {
+	g.connectAnchors()
 	for {
 		for i := 0; i < 100; i++ {
 			id := g.mu.RLock()
-			numPeers := len(g.peers)
+			numOutbound := g.numPeers(false)
 			addr, err := g.randomNode()
+			_, connected := g.peers[addr]
+			full := g.subnetFull(addr, false)
 			g.mu.RUnlock(id)
-			if err != nil || numPeers >= wellConnectedThreshold {
+			if err != nil || numOutbound >= wellConnectedThreshold {
 				break
+			} else if connected || full {
+				continue
 			}
-			err = g.Connect(addr)
-			// aggressively remove unresponsive nodes
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				id = g.mu.Lock()
-				g.removeNode(addr)
-				g.mu.Unlock(id)
-			}
+			// failures are recorded in the node list by Connect, and
+			// nodes that keep failing are eventually pruned
+			g.Connect(addr)
 		}
 		time.Sleep(5 * time.Second)
 	}
 }
 
-func (g *Gateway) Peers() []modules.NetAddress {
+// Peers returns the peers that the Gateway is currently connected to.
+func (g *Gateway) Peers() []modules.Peer {
 	id := g.mu.RLock()
 	defer g.mu.RUnlock(id)
-	var peers []modules.NetAddress
-	for addr := range g.peers {
-		peers = append(peers, addr)
+	var peers []modules.Peer
+	for addr, p := range g.peers {
+		peers = append(peers, modules.Peer{
+			NetAddress: addr,
+			ID:         p.id,
+			Inbound:    p.inbound,
+			Version:    p.info.Version,
+			Services:   p.info.Services,
+			Stats:      p.stats(),
+		})
 	}
 	return peers
 }
@@ -27,6 +27,9 @@ func TestAddPeer(t *testing.T) {
 func TestListen(t *testing.T) {
 	g := newTestingGateway("TestListen", t)
 	defer g.Close()
+	// client is only used to perform the handshake
+	client := newTestingGateway("TestListenClient", t)
+	defer client.Close()
 
 	// compliant connect with old version
 	conn, err := net.Dial("tcp", string(g.Address()))
@@ -34,20 +37,24 @@ func TestListen(t *testing.T) {
 		t.Fatal("dial failed:", err)
 	}
 	addr := modules.NetAddress(conn.LocalAddr().String())
-	// send version
-	if err := encoding.WriteObject(conn, "0.1"); err != nil {
-		t.Fatal("couldn't write version")
+	sc, _, err := client.handshake(conn, true)
+	if err != nil {
+		t.Fatal("handshake failed:", err)
+	}
+	// send info
+	if err := encoding.WriteObject(sc, nodeInfo{Version: "0.1"}); err != nil {
+		t.Fatal("couldn't write info")
 	}
-	// read ack
+	// read response
 	var ack string
-	if err := encoding.ReadObject(conn, &ack, maxAddrLength); err != nil {
+	if err := encoding.ReadObject(sc, &ack, maxAddrLength); err != nil {
 		t.Fatal(err)
-	} else if ack != "reject" {
+	} else if ack == acceptResponse {
 		t.Fatal("gateway should have rejected old version")
 	}
 
 	// a simple 'conn.Close' would not obey the muxado disconnect protocol
-	muxado.Client(conn).Close()
+	muxado.Client(sc).Close()
 
 	// compliant connect
 	conn, err = net.Dial("tcp", string(g.Address()))
@@ -55,15 +62,19 @@ func TestListen(t *testing.T) {
 		t.Fatal("dial failed:", err)
 	}
 	addr = modules.NetAddress(conn.LocalAddr().String())
-	// send version
-	if err := encoding.WriteObject(conn, build.Version); err != nil {
-		t.Fatal("couldn't write version")
+	sc, _, err = client.handshake(conn, true)
+	if err != nil {
+		t.Fatal("handshake failed:", err)
+	}
+	// send info
+	if err := encoding.WriteObject(sc, nodeInfo{Version: build.Version}); err != nil {
+		t.Fatal("couldn't write info")
 	}
-	// read ack
-	if err := encoding.ReadObject(conn, &ack, maxAddrLength); err != nil {
+	// read response
+	if err := encoding.ReadObject(sc, &ack, maxAddrLength); err != nil {
 		t.Fatal(err)
-	} else if ack == "reject" {
-		t.Fatal("gateway should have given ack")
+	} else if ack != acceptResponse {
+		t.Fatal("gateway should have accepted:", ack)
 	}
 
 	// g should add the peer
@@ -74,7 +85,7 @@ func TestListen(t *testing.T) {
 		g.mu.RUnlock(id)
 	}
 
-	muxado.Client(conn).Close()
+	muxado.Client(sc).Close()
 
 	// g should remove the peer
 	for ok {
@@ -204,7 +215,7 @@ func TestMakeOutboundConnections(t *testing.T) {
 	g2 := newTestingGateway("TestMakeOutboundConnections2", t)
 	defer g2.Close()
 	id = g1.mu.Lock()
-	g1.nodes[g2.Address()] = struct{}{} // manual insertion to bypass addNode
+	g1.nodes[g2.Address()] = &node{NetAddress: g2.Address()} // manual insertion to bypass addNode
 	g1.mu.Unlock(id)
 
 	// when makeOutboundConnections wakes up, it should connect to g2.
@@ -219,3 +230,53 @@ func TestMakeOutboundConnections(t *testing.T) {
 		t.Fatal("gateway did not connect to g2")
 	}
 }
+
+// TestRandomInboundPeer checks that randomInboundPeer only returns inbound
+// peers, even when outbound peers outnumber them.
+func TestRandomInboundPeer(t *testing.T) {
+	g := newTestingGateway("TestRandomInboundPeer", t)
+	defer g.Close()
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+
+	for i := 0; i < 8; i++ {
+		addr := modules.NetAddress("1.2.3." + strconv.Itoa(i) + ":9981")
+		g.peers[addr] = &peer{addr: addr}
+	}
+	if addr := g.randomInboundPeer(); addr != "" {
+		t.Fatal("returned an outbound peer:", addr)
+	}
+
+	inbound := modules.NetAddress("5.6.7.8:9981")
+	g.peers[inbound] = &peer{addr: inbound, inbound: true}
+	for i := 0; i < 100; i++ {
+		if addr := g.randomInboundPeer(); addr != inbound {
+			t.Fatal("did not return the inbound peer:", addr)
+		}
+	}
+}
+
+// TestAddPeerSubnetCap checks that addPeer enforces the cap on inbound peers
+// from the same subnet, since the check made before the handshake can be
+// passed by several connections at once.
+func TestAddPeerSubnetCap(t *testing.T) {
+	g := newTestingGateway("TestAddPeerSubnetCap", t)
+	defer g.Close()
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+
+	for i := 0; i < maxInboundPerSubnet; i++ {
+		addr := modules.NetAddress("1.2.3." + strconv.Itoa(i) + ":9981")
+		p := &peer{addr: addr, id: modules.NodeID{byte(i + 1)}, sess: muxado.Client(nil), inbound: true}
+		if err := g.addPeer(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &peer{addr: "1.2.4.5:9981", id: modules.NodeID{0xFF}, sess: muxado.Client(nil), inbound: true}
+	if err := g.addPeer(p); err != errSubnetFull {
+		t.Fatal("expected errSubnetFull, got", err)
+	}
+	if len(g.peers) != maxInboundPerSubnet {
+		t.Fatal("peer was added above the subnet cap")
+	}
+}
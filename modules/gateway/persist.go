@@ -5,32 +5,140 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/persist"
 )
 
-var persistMetadata = persist.Metadata{
-	Header:  "Sia Node List",
-	Version: "0.3.3",
+var (
+	// persistMetadata is the metadata of node lists that include connection
+	// history. The version is bumped to the next release because the format
+	// changed.
+	persistMetadata = persist.Metadata{
+		Header:  "Sia Node List",
+		Version: "0.4.0",
+	}
+	// oldPersistMetadata is the metadata of node lists that predate
+	// connection history, which stored only the addresses of the nodes.
+	oldPersistMetadata = persist.Metadata{
+		Header:  "Sia Node List",
+		Version: "0.3.3",
+	}
+	banMetadata = persist.Metadata{
+		Header:  "Sia Peer Bans",
+		Version: "0.3.3",
+	}
+	anchorMetadata = persist.Metadata{
+		Header:  "Sia Anchor Peers",
+		Version: "0.3.3",
+	}
+	identityMetadata = persist.Metadata{
+		Header:  "Sia Gateway Identity",
+		Version: "0.3.3",
+	}
+)
+
+// persistIdentity is the key pair that the Gateway uses to authenticate
+// itself to its peers.
+type persistIdentity struct {
+	PublicKey crypto.PublicKey
+	SecretKey crypto.SecretKey
 }
 
 func (g *Gateway) save() error {
-	var nodes []modules.NetAddress
-	for node := range g.nodes {
-		nodes = append(nodes, node)
+	var nodes []node
+	for _, n := range g.nodes {
+		nodes = append(nodes, *n)
 	}
 	return persist.SaveFile(persistMetadata, nodes, filepath.Join(g.persistDir, "nodes.json"))
 }
 
 func (g *Gateway) load() error {
-	var nodes []modules.NetAddress
-	err := persist.LoadFile(persistMetadata, &nodes, filepath.Join(g.persistDir, "nodes.json"))
-	if err != nil {
+	filename := filepath.Join(g.persistDir, "nodes.json")
+	var nodes []node
+	err := persist.LoadFile(persistMetadata, &nodes, filename)
+	if err == persist.ErrBadVersion {
+		// Older node lists contain only addresses; their nodes start with
+		// no connection history.
+		var addrs []modules.NetAddress
+		err = persist.LoadFile(oldPersistMetadata, &addrs, filename)
+		for _, addr := range addrs {
+			nodes = append(nodes, node{NetAddress: addr})
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, n := range nodes {
+		if g.addNode(n.NetAddress) == nil {
+			*g.nodes[n.NetAddress] = n
+		}
+	}
+	if err := g.loadAnchors(); err != nil {
 		return err
 	}
-	for _, node := range nodes {
-		g.addNode(node)
+	return g.loadBans()
+}
+
+// saveAnchors saves the addresses of the current anchor peers. If the
+// gateway has no outbound peers, e.g. because it is offline, the previous
+// anchors are kept.
+func (g *Gateway) saveAnchors() error {
+	var anchors []modules.NetAddress
+	for _, p := range g.anchorPeers() {
+		anchors = append(anchors, p.addr)
+	}
+	if len(anchors) == 0 {
+		return nil
+	}
+	return persist.SaveFile(anchorMetadata, anchors, filepath.Join(g.persistDir, "anchors.json"))
+}
+
+// loadAnchors loads the anchor peers saved during the last shutdown, if any.
+func (g *Gateway) loadAnchors() error {
+	err := persist.LoadFile(anchorMetadata, &g.anchors, filepath.Join(g.persistDir, "anchors.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// saveBans prunes expired bans and saves the ban list. Bans are kept separate
+// from the node list so that older node lists can still be loaded.
+func (g *Gateway) saveBans() error {
+	g.pruneBans()
+	return persist.SaveFile(banMetadata, g.bans, filepath.Join(g.persistDir, "bans.json"))
+}
+
+// loadBans loads the ban list, if one exists.
+func (g *Gateway) loadBans() error {
+	err := persist.LoadFile(banMetadata, &g.bans, filepath.Join(g.persistDir, "bans.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	g.pruneBans()
+	return err
+}
+
+// loadIdentity loads the Gateway's identity key pair. If no identity has been
+// saved, a new one is generated and saved, so that the identity is stable
+// across restarts.
+func (g *Gateway) loadIdentity() error {
+	filename := filepath.Join(g.persistDir, "identity.json")
+	var ident persistIdentity
+	err := persist.LoadFile(identityMetadata, &ident, filename)
+	if os.IsNotExist(err) {
+		ident.SecretKey, ident.PublicKey, err = crypto.GenerateSignatureKeys()
+		if err != nil {
+			return err
+		}
+		err = persist.SaveFile(identityMetadata, ident, filename)
+	}
+	if err != nil {
+		return err
 	}
+	g.id = modules.NodeID(ident.PublicKey)
+	g.secretKey = ident.SecretKey
 	return nil
 }
 
@@ -1,10 +1,12 @@
 package gateway
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
 )
 
 func TestLoad(t *testing.T) {
@@ -24,6 +26,43 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+// TestLoadNodeHistory checks that the connection history of nodes is saved,
+// and that node lists from before connection history can still be loaded.
+func TestLoadNodeHistory(t *testing.T) {
+	g := newTestingGateway("TestLoadNodeHistory", t)
+	id := g.mu.Lock()
+	g.addNode(dummyNode)
+	g.recordAttempt(dummyNode, false)
+	g.save()
+	g.mu.Unlock(id)
+	g.Close()
+
+	g2, err := New(":0", g.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, ok := g2.nodes[dummyNode]
+	if !ok || n.Failures != 1 || n.LastAttempt.IsZero() {
+		t.Fatal("gateway did not load node history:", n)
+	}
+	g2.Close()
+
+	// Overwrite the node list with an old-style list of addresses.
+	err = persist.SaveFile(oldPersistMetadata, []modules.NetAddress{dummyNode}, filepath.Join(g.persistDir, "nodes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g3, err := New(":0", g.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g3.Close()
+	n, ok = g3.nodes[dummyNode]
+	if !ok || n.Failures != 0 {
+		t.Fatal("gateway did not load old node list:", g3.nodes)
+	}
+}
+
 func TestLoadPeer(t *testing.T) {
 	t.Skip("TODO: broken")
 
@@ -44,7 +83,7 @@ func TestLoadPeer(t *testing.T) {
 	}
 	time.Sleep(10 * time.Millisecond)
 	peers := g1.Peers()
-	if len(peers) != 1 || peers[0] != g2.Address() {
+	if len(peers) != 1 || peers[0].NetAddress != g2.Address() {
 		t.Fatalf("gateway did not reconnect to loaded peer: expected %v, got %v", []modules.NetAddress{g2.Address()}, peers)
 	}
 }
@@ -0,0 +1,205 @@
+package gateway
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// policy.go contains the rules that decide which peers the Gateway connects
+// to and keeps. The rules are meant to make eclipse attacks, in which an
+// attacker surrounds a node with peers under its control, more expensive:
+// outbound slots cannot be taken by inbound connections, peers must come from
+// diverse subnets, long-lived peers are remembered across restarts, and the
+// remaining outbound peers are slowly rotated.
+
+var (
+	errSameIP     = errors.New("already connected to that IP")
+	errSubnetFull = errors.New("too many peers from that subnet")
+)
+
+const (
+	// maxInboundPeers is the number of inbound connections the gateway will
+	// accept. The remaining slots are reserved for outbound connections, which
+	// are chosen by the gateway and are harder for an attacker to occupy.
+	maxInboundPeers = fullyConnectedThreshold - wellConnectedThreshold
+
+	// maxOutboundPerSubnet and maxInboundPerSubnet limit the number of peers
+	// that can come from the same subnet. An attacker controlling a single
+	// subnet can therefore occupy only a few slots.
+	maxOutboundPerSubnet = 1
+	maxInboundPerSubnet  = 4
+
+	// ipv4SubnetBits and ipv6SubnetBits are the prefix lengths used to group
+	// peers into subnets.
+	ipv4SubnetBits = 16
+	ipv6SubnetBits = 32
+
+	// maxAnchors is the number of outbound peers that are saved as anchors.
+	// Anchors are reconnected to first upon startup, so that an attacker
+	// cannot take over the node list while the node is offline and capture
+	// all of the outbound slots after a restart.
+	maxAnchors = 2
+)
+
+var (
+	// peerRotationInterval is how often the gateway disconnects from one of
+	// its outbound peers to make room for a new one. Anchors are never
+	// rotated.
+	peerRotationInterval = func() time.Duration {
+		switch build.Release {
+		case "testing":
+			return 30 * time.Second
+		default:
+			return 30 * time.Minute
+		}
+	}()
+)
+
+// peersByAge sorts peers from the longest connected to the most recently
+// connected.
+type peersByAge []*peer
+
+func (pa peersByAge) Len() int           { return len(pa) }
+func (pa peersByAge) Less(i, j int) bool { return pa[i].connectedAt.Before(pa[j].connectedAt) }
+func (pa peersByAge) Swap(i, j int)      { pa[i], pa[j] = pa[j], pa[i] }
+
+// subnet returns the subnet that addr belongs to, for the purpose of
+// enforcing the per-subnet caps. Loopback addresses are not subject to the
+// caps and return "". Hostnames are treated as their own subnet.
+func subnet(addr modules.NetAddress) string {
+	ip := net.ParseIP(addr.Host())
+	if ip == nil {
+		return addr.Host()
+	} else if ip.IsLoopback() {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(ipv4SubnetBits, 32)), Mask: net.CIDRMask(ipv4SubnetBits, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6SubnetBits, 128)), Mask: net.CIDRMask(ipv6SubnetBits, 128)}).String()
+}
+
+// numPeers returns the number of inbound or outbound peers.
+func (g *Gateway) numPeers(inbound bool) int {
+	n := 0
+	for _, p := range g.peers {
+		if p.inbound == inbound {
+			n++
+		}
+	}
+	return n
+}
+
+// subnetFull returns true if the gateway already has the maximum number of
+// inbound or outbound peers from the subnet of addr.
+func (g *Gateway) subnetFull(addr modules.NetAddress, inbound bool) bool {
+	s := subnet(addr)
+	if s == "" {
+		return false
+	}
+	limit := maxOutboundPerSubnet
+	if inbound {
+		limit = maxInboundPerSubnet
+	}
+	n := 0
+	for peerAddr, p := range g.peers {
+		if p.inbound == inbound && subnet(peerAddr) == s {
+			n++
+		}
+	}
+	return n >= limit
+}
+
+// checkInbound returns an error if the gateway should not accept an inbound
+// peer from addr, because it is already connected to the IP of addr or has
+// the maximum number of inbound peers from its subnet.
+func (g *Gateway) checkInbound(addr modules.NetAddress) error {
+	// Peers share an IP during testing.
+	if build.Release != "testing" {
+		for peerAddr := range g.peers {
+			if peerAddr.Host() == addr.Host() {
+				return errSameIP
+			}
+		}
+	}
+	if g.subnetFull(addr, true) {
+		return errSubnetFull
+	}
+	return nil
+}
+
+// anchorPeers returns the outbound peers that have been connected the
+// longest, up to maxAnchors.
+func (g *Gateway) anchorPeers() []*peer {
+	var outbound []*peer
+	for _, p := range g.peers {
+		if !p.inbound {
+			outbound = append(outbound, p)
+		}
+	}
+	sort.Sort(peersByAge(outbound))
+	if len(outbound) > maxAnchors {
+		outbound = outbound[:maxAnchors]
+	}
+	return outbound
+}
+
+// connectAnchors reconnects to the anchors that were saved when the gateway
+// last shut down.
+func (g *Gateway) connectAnchors() {
+	id := g.mu.RLock()
+	anchors := g.anchors
+	g.mu.RUnlock(id)
+	for _, addr := range anchors {
+		if err := g.Connect(addr); err != nil {
+			g.log.Printf("WARN: could not reconnect to anchor %v: %v", addr, err)
+		}
+	}
+}
+
+// rotatePeers disconnects from a random outbound peer that is not an anchor,
+// freeing its slot for a new peer from the node list. Peers are only rotated
+// while the gateway is well-connected, so rotation never leaves the gateway
+// short of outbound peers for long.
+func (g *Gateway) rotatePeers() {
+	if g.numPeers(false) < wellConnectedThreshold {
+		return
+	}
+	anchors := make(map[*peer]bool)
+	for _, p := range g.anchorPeers() {
+		anchors[p] = true
+	}
+	var candidates []*peer
+	for _, p := range g.peers {
+		if !p.inbound && !anchors[p] {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	p := candidates[rand.Intn(len(candidates))]
+	if p.sess != nil {
+		p.sess.Close()
+	}
+	delete(g.peers, p.addr)
+	g.log.Println("INFO: rotated out peer", p.addr)
+}
+
+// threadedRotatePeers periodically rotates the gateway's outbound peers and
+// saves the current anchors.
+func (g *Gateway) threadedRotatePeers() {
+	for {
+		time.Sleep(peerRotationInterval)
+		id := g.mu.Lock()
+		g.rotatePeers()
+		_ = g.saveAnchors() // TODO: Some way to communicate that the save failed.
+		g.mu.Unlock(id)
+	}
+}
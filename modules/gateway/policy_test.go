@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestSubnet checks that addresses are grouped into the correct subnets.
+func TestSubnet(t *testing.T) {
+	tests := []struct {
+		addr   modules.NetAddress
+		subnet string
+	}{
+		{"1.2.3.4:9981", "1.2.0.0/16"},
+		{"1.2.200.1:9981", "1.2.0.0/16"},
+		{"1.3.3.4:9981", "1.3.0.0/16"},
+		{"[2001:db8:1::1]:9981", "2001:db8::/32"},
+		{"[::1]:9981", ""},
+		{"127.0.0.1:9981", ""},
+		{"foo.com:9981", "foo.com"},
+	}
+	for _, test := range tests {
+		if s := subnet(test.addr); s != test.subnet {
+			t.Errorf("subnet(%v): expected %q, got %q", test.addr, test.subnet, s)
+		}
+	}
+}
+
+// TestSubnetFull checks that the per-subnet caps are enforced separately for
+// inbound and outbound peers.
+func TestSubnetFull(t *testing.T) {
+	g := newTestingGateway("TestSubnetFull", t)
+	defer g.Close()
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+
+	g.peers["1.2.3.4:9981"] = &peer{addr: "1.2.3.4:9981"}
+	if !g.subnetFull("1.2.5.6:9981", false) {
+		t.Error("second outbound peer from the same subnet was allowed")
+	}
+	if g.subnetFull("1.3.5.6:9981", false) {
+		t.Error("outbound peer from a different subnet was rejected")
+	}
+	for i := 0; i < maxInboundPerSubnet; i++ {
+		if g.subnetFull("1.2.5.6:9981", true) {
+			t.Fatal("inbound peer rejected below the subnet cap")
+		}
+		addr := modules.NetAddress("1.2.7." + strconv.Itoa(i) + ":9981")
+		g.peers[addr] = &peer{addr: addr, inbound: true}
+	}
+	if !g.subnetFull("1.2.5.6:9981", true) {
+		t.Error("inbound peer allowed above the subnet cap")
+	}
+	if g.subnetFull("[::1]:9981", true) {
+		t.Error("loopback peer was subject to the subnet cap")
+	}
+}
+
+// TestRotatePeers checks that rotation removes a single outbound peer that is
+// not an anchor, and only while the gateway is well-connected.
+func TestRotatePeers(t *testing.T) {
+	g := newTestingGateway("TestRotatePeers", t)
+	defer g.Close()
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+
+	// add outbound peers, connected at increasingly recent times
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < wellConnectedThreshold; i++ {
+		addr := modules.NetAddress("foo" + strconv.Itoa(i))
+		g.peers[addr] = &peer{addr: addr, connectedAt: start.Add(time.Duration(i) * time.Minute)}
+	}
+	// inbound peers are never rotated
+	g.peers["bar"] = &peer{addr: "bar", inbound: true}
+
+	anchors := g.anchorPeers()
+	if len(anchors) != maxAnchors || anchors[0].addr != "foo0" || anchors[1].addr != "foo1" {
+		t.Fatal("wrong anchors:", anchors)
+	}
+
+	g.rotatePeers()
+	if g.numPeers(false) != wellConnectedThreshold-1 || g.numPeers(true) != 1 {
+		t.Fatal("rotation did not remove exactly one outbound peer:", g.peers)
+	}
+	for _, a := range anchors {
+		if _, ok := g.peers[a.addr]; !ok {
+			t.Fatal("anchor was rotated out:", a.addr)
+		}
+	}
+
+	// below the threshold, no peers are rotated
+	g.rotatePeers()
+	if g.numPeers(false) != wellConnectedThreshold-1 {
+		t.Fatal("rotated a peer while not well-connected")
+	}
+}
+
+// TestAnchors checks that outbound peers are saved as anchors and
+// reconnected to upon startup.
+func TestAnchors(t *testing.T) {
+	g1 := newTestingGateway("TestAnchors1", t)
+	g2 := newTestingGateway("TestAnchors2", t)
+	defer g2.Close()
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	// shut down g1, closing its peer connections so that g2 forgets it
+	id := g1.mu.Lock()
+	for _, p := range g1.peers {
+		p.sess.Close()
+	}
+	g1.mu.Unlock(id)
+	g1.Close()
+	for i := 0; i < 100 && len(g2.Peers()) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	g1, err := New(":0", g1.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g1.Close()
+	if len(g1.anchors) != 1 || g1.anchors[0] != g2.Address() {
+		t.Fatal("anchors were not loaded:", g1.anchors)
+	}
+	var peers []modules.Peer
+	for i := 0; i < 100; i++ {
+		if peers = g1.Peers(); len(peers) != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(peers) != 1 || peers[0].ID != g2.ID() {
+		t.Fatal("gateway did not reconnect to its anchor:", peers)
+	}
+}
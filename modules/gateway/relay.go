@@ -0,0 +1,309 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// relay.go implements inventory-based relay. Instead of pushing an object to
+// every peer, the gateway announces the object's ID with the Inv RPC, and
+// peers that lack the object request it with the GetData RPC. Each peer keeps
+// a cache of the items it is known to have, so that an object is never
+// announced to a peer that sent it to us or that we have already told about
+// it. Peers that do not support the Inv RPC are sent the full object, as
+// before.
+
+const (
+	// maxInvItems is the largest number of items in a single announcement.
+	maxInvItems = 1000
+
+	// maxInvItemLength is the largest encoded size of a single invItem.
+	maxInvItemLength = 128
+
+	// maxKnownItems is the number of items remembered per peer.
+	maxKnownItems = 1000
+
+	// maxSeenItems is the number of items that the gateway remembers having
+	// received, so that it does not request them again.
+	maxSeenItems = 5000
+
+	// maxInventorySize is the total encoded size of the objects that the
+	// gateway keeps available for peers to request.
+	maxInventorySize = 32 << 20
+)
+
+var (
+	errUnknownRelay = errors.New("no relay registered with that name")
+	errWrongObject  = errors.New("peer sent an object that does not match the announced ID")
+)
+
+// A relay is an object type that is relayed with inventory announcements. has
+// reports whether the node already has the object with the given ID, and
+// objectID computes the ID of an encoded object.
+type relay struct {
+	has      func(crypto.Hash) bool
+	objectID func([]byte) (crypto.Hash, error)
+}
+
+// An invItem identifies a relayed object. Name is the RPC that handles the
+// object, and ID is the object's identifier within that RPC.
+type invItem struct {
+	Name string
+	ID   crypto.Hash
+}
+
+// A knownCache is a bounded set of invItems. When full, the oldest item is
+// evicted. The zero value is an empty cache.
+type knownCache struct {
+	items map[invItem]struct{}
+	order []invItem
+}
+
+// contains returns true if the item is in the cache.
+func (kc *knownCache) contains(item invItem) bool {
+	_, exists := kc.items[item]
+	return exists
+}
+
+// add adds an item to the cache, evicting the oldest item if the cache holds
+// more than max items.
+func (kc *knownCache) add(item invItem, max int) {
+	if kc.items == nil {
+		kc.items = make(map[invItem]struct{})
+	}
+	if kc.contains(item) {
+		return
+	}
+	kc.items[item] = struct{}{}
+	kc.order = append(kc.order, item)
+	if len(kc.order) > max {
+		delete(kc.items, kc.order[0])
+		kc.order = kc.order[1:]
+	}
+}
+
+// storeInventory makes an encoded object available to peers, evicting the
+// oldest objects if the inventory is full.
+func (g *Gateway) storeInventory(item invItem, enc []byte) {
+	if _, exists := g.inventory[item]; exists {
+		return
+	}
+	g.inventory[item] = enc
+	g.inventoryOrder = append(g.inventoryOrder, item)
+	g.inventorySize += len(enc)
+	for g.inventorySize > maxInventorySize && len(g.inventoryOrder) > 1 {
+		oldest := g.inventoryOrder[0]
+		g.inventorySize -= len(g.inventory[oldest])
+		delete(g.inventory, oldest)
+		g.inventoryOrder = g.inventoryOrder[1:]
+	}
+}
+
+// RegisterRelay registers an object type that is relayed with inventory
+// announcements. Objects requested from peers are passed to the RPC handler
+// registered under the same name. has reports whether the local node already
+// has the object with the given ID, in which case it is not requested.
+// objectID computes the ID of an encoded object, so that objects that do not
+// match their announcement are rejected before they are handled.
+func (g *Gateway) RegisterRelay(name string, has func(crypto.Hash) bool, objectID func([]byte) (crypto.Hash, error)) {
+	id := g.mu.Lock()
+	defer g.mu.Unlock(id)
+	g.relays[name] = relay{has: has, objectID: objectID}
+}
+
+// Relay announces an object to every peer that is not known to have it.
+// Peers that lack the object request it, and peers that do not support
+// announcements are sent the object directly via the named RPC. Like
+// Broadcast, the calls are run in parallel and Relay returns when they have
+// completed.
+func (g *Gateway) Relay(name string, objID crypto.Hash, obj interface{}) {
+	item := invItem{Name: name, ID: objID}
+	enc := encoding.Marshal(obj)
+
+	id := g.mu.Lock()
+	g.storeInventory(item, enc)
+	g.seen.add(item, maxSeenItems)
+	var announce, push []modules.NetAddress
+	for addr, p := range g.peers {
+		if p.known.contains(item) {
+			continue
+		}
+		if p.supports("Inv") {
+			announce = append(announce, addr)
+		} else if p.supports(name) {
+			push = append(push, addr)
+		} else {
+			continue
+		}
+		p.known.add(item, maxKnownItems)
+	}
+	g.mu.Unlock(id)
+
+	invFn := func(conn modules.PeerConn) error {
+		return encoding.WriteObject(conn, []invItem{item})
+	}
+	pushFn := func(conn modules.PeerConn) error {
+		return encoding.WritePrefix(conn, enc)
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(announce) + len(push))
+	for _, addr := range announce {
+		go func(addr modules.NetAddress) {
+			g.RPC(addr, "Inv", invFn)
+			wg.Done()
+		}(addr)
+	}
+	for _, addr := range push {
+		go func(addr modules.NetAddress) {
+			g.RPC(addr, name, pushFn)
+			wg.Done()
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// handleInv is the receiving end of the Inv RPC. It records the announced
+// items as known by the peer, and requests the items that the gateway lacks.
+func (g *Gateway) handleInv(conn modules.PeerConn) error {
+	var items []invItem
+	if err := encoding.ReadObject(conn, &items, maxInvItems*maxInvItemLength); err != nil {
+		return err
+	} else if len(items) > maxInvItems {
+		return modules.PeerError{Offense: modules.OffenseMalformedRPC, Err: errors.New("announcement contains too many items")}
+	}
+	addr := modules.NetAddress(conn.RemoteAddr().String())
+
+	type candidate struct {
+		item invItem
+		has  func(crypto.Hash) bool
+	}
+	var candidates []candidate
+	id := g.mu.Lock()
+	p, exists := g.peers[addr]
+	if !exists {
+		g.mu.Unlock(id)
+		return errors.New("received announcement from unconnected peer")
+	}
+	for _, item := range items {
+		p.known.add(item, maxKnownItems)
+		r, registered := g.relays[item.Name]
+		if registered && !g.seen.contains(item) && !g.requested[item] {
+			candidates = append(candidates, candidate{item, r.has})
+		}
+	}
+	g.mu.Unlock(id)
+
+	// The has functions are called without holding the gateway's lock, since
+	// they may acquire the locks of other modules.
+	for _, c := range candidates {
+		if c.has(c.item.ID) {
+			id := g.mu.Lock()
+			g.seen.add(c.item, maxSeenItems)
+			g.mu.Unlock(id)
+			continue
+		}
+		// Errors are logged and penalized by RPC, and are not the fault of
+		// the announcement.
+		g.requestItem(addr, c.item)
+	}
+	return nil
+}
+
+// requestItem requests an announced item from a peer with the GetData RPC,
+// and passes it to the RPC handler registered under the item's name. Only one
+// request is made at a time for each item, so an item announced by many
+// peers at once is only downloaded once. The item is only marked as seen once
+// the handler has accepted it, so a peer that sends the wrong object cannot
+// stop the item from being requested from other peers.
+func (g *Gateway) requestItem(addr modules.NetAddress, item invItem) error {
+	id := g.mu.Lock()
+	handler, exists := g.handlers[handlerName(item.Name)]
+	r, registered := g.relays[item.Name]
+	exists = exists && registered
+	if !exists || g.requested[item] || g.seen.contains(item) {
+		g.mu.Unlock(id)
+		if !exists {
+			return errUnknownRelay
+		}
+		return nil
+	}
+	g.requested[item] = true
+	g.mu.Unlock(id)
+	defer func() {
+		id := g.mu.Lock()
+		delete(g.requested, item)
+		g.mu.Unlock(id)
+	}()
+
+	return g.RPC(addr, "GetData", func(conn modules.PeerConn) error {
+		if err := encoding.WriteObject(conn, item); err != nil {
+			return err
+		}
+		var found bool
+		if err := encoding.ReadObject(conn, &found, 1); err != nil {
+			return err
+		} else if !found {
+			// The peer may have evicted the item from its inventory.
+			return nil
+		}
+		// An object larger than the inventory could not have been stored
+		// by the peer.
+		enc, err := encoding.ReadPrefix(conn, maxInventorySize)
+		if err != nil {
+			return err
+		}
+		if objID, err := r.objectID(enc); err != nil || objID != item.ID {
+			return modules.PeerError{Offense: modules.OffenseMalformedRPC, Err: errWrongObject}
+		}
+		// The handler reads the object from the connection, so it is
+		// given the object again, followed by the rest of the connection.
+		err = handler(&replayConn{
+			PeerConn: conn,
+			r:        io.MultiReader(bytes.NewReader(append(encoding.EncUint64(uint64(len(enc))), enc...)), conn),
+		})
+		if err != nil {
+			return err
+		}
+		id := g.mu.Lock()
+		g.seen.add(item, maxSeenItems)
+		g.mu.Unlock(id)
+		return nil
+	})
+}
+
+// A replayConn is a PeerConn whose reads are served by r.
+type replayConn struct {
+	modules.PeerConn
+	r io.Reader
+}
+
+// Read implements the io.Reader interface.
+func (rc *replayConn) Read(p []byte) (int, error) {
+	return rc.r.Read(p)
+}
+
+// sendData is the receiving end of the GetData RPC. It writes whether the
+// requested item is in the inventory, followed by the item itself.
+func (g *Gateway) sendData(conn modules.PeerConn) error {
+	var item invItem
+	if err := encoding.ReadObject(conn, &item, maxInvItemLength); err != nil {
+		return err
+	}
+	id := g.mu.Lock()
+	enc, found := g.inventory[item]
+	if p, exists := g.peers[modules.NetAddress(conn.RemoteAddr().String())]; exists {
+		p.known.add(item, maxKnownItems)
+	}
+	g.mu.Unlock(id)
+
+	if err := encoding.WriteObject(conn, found); err != nil || !found {
+		return err
+	}
+	return encoding.WritePrefix(conn, enc)
+}
@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// relayTester is an object relayed by a gateway, in the same way that blocks
+// are relayed by the consensus set.
+type relayTester struct {
+	g        *Gateway
+	received []string
+	has      bool
+	mu       sync.Mutex
+}
+
+// newRelayTester registers a "Foo" relay on g. Objects received by the
+// tester are relayed on to the gateway's other peers.
+func newRelayTester(g *Gateway) *relayTester {
+	rt := &relayTester{g: g}
+	g.RegisterRPC("Foo", func(conn modules.PeerConn) error {
+		var s string
+		if err := encoding.ReadObject(conn, &s, 100); err != nil {
+			return err
+		}
+		rt.mu.Lock()
+		rt.received = append(rt.received, s)
+		rt.mu.Unlock()
+		go g.Relay("Foo", crypto.HashObject(s), s)
+		return nil
+	})
+	g.RegisterRelay("Foo", func(crypto.Hash) bool {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		return rt.has
+	}, func(enc []byte) (crypto.Hash, error) {
+		var s string
+		err := encoding.Unmarshal(enc, &s)
+		return crypto.HashObject(s), err
+	})
+	return rt
+}
+
+// numReceived returns the number of objects that the tester has received.
+func (rt *relayTester) numReceived() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return len(rt.received)
+}
+
+// TestKnownCache checks that a knownCache evicts its oldest items.
+func TestKnownCache(t *testing.T) {
+	var kc knownCache
+	items := []invItem{{"Foo", crypto.Hash{1}}, {"Foo", crypto.Hash{2}}, {"Bar", crypto.Hash{1}}}
+	for _, item := range items {
+		kc.add(item, 2)
+		kc.add(item, 2)
+	}
+	if kc.contains(items[0]) || !kc.contains(items[1]) || !kc.contains(items[2]) {
+		t.Fatal("cache evicted the wrong items")
+	}
+	if len(kc.order) != 2 || len(kc.items) != 2 {
+		t.Fatal("cache has wrong size:", len(kc.order), len(kc.items))
+	}
+}
+
+// TestRelay checks that a relayed object travels along a chain of peers,
+// is received exactly once by each of them, and is not sent back to the
+// peer it came from.
+func TestRelay(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	g1 := newTestingGateway("TestRelay1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestRelay2", t)
+	defer g2.Close()
+	g3 := newTestingGateway("TestRelay3", t)
+	defer g3.Close()
+	rt1, rt2, rt3 := newRelayTester(g1), newRelayTester(g2), newRelayTester(g3)
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g2.Connect(g3.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	g1.Relay("Foo", crypto.HashObject("foo"), "foo")
+	for i := 0; i < 50 && rt3.numReceived() == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	// Give any duplicate transfers time to arrive.
+	time.Sleep(100 * time.Millisecond)
+	if rt2.numReceived() != 1 || rt3.numReceived() != 1 {
+		t.Fatal("object was not received exactly once:", rt2.numReceived(), rt3.numReceived())
+	}
+	if rt1.numReceived() != 0 {
+		t.Fatal("object was sent back to its origin")
+	}
+
+	// Relaying the object again should not send it to anyone.
+	before := g1.Stats().Outgoing["Inv"].Calls
+	g1.Relay("Foo", crypto.HashObject("foo"), "foo")
+	if g1.Stats().Outgoing["Inv"].Calls != before {
+		t.Fatal("object was announced to a peer that has it")
+	}
+}
+
+// TestRelayHas checks that announced objects that the node already has are
+// not requested.
+func TestRelayHas(t *testing.T) {
+	g1 := newTestingGateway("TestRelayHas1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestRelayHas2", t)
+	defer g2.Close()
+	newRelayTester(g1)
+	rt2 := newRelayTester(g2)
+	rt2.has = true
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	g1.Relay("Foo", crypto.HashObject("foo"), "foo")
+	time.Sleep(100 * time.Millisecond)
+	if rt2.numReceived() != 0 || g2.Stats().Outgoing["GetData"].Calls != 0 {
+		t.Fatal("object was requested by a peer that has it")
+	}
+}
+
+// TestRelayPush checks that objects are pushed to peers that do not support
+// announcements.
+func TestRelayPush(t *testing.T) {
+	g1 := newTestingGateway("TestRelayPush1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestRelayPush2", t)
+	defer g2.Close()
+	newRelayTester(g1)
+	rt2 := newRelayTester(g2)
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	// Make g2 look like an older peer.
+	id := g1.mu.Lock()
+	p := g1.peers[g2.Address()]
+	var rpcs []string
+	for _, rpc := range p.info.RPCs {
+		if rpc != "Inv" {
+			rpcs = append(rpcs, rpc)
+		}
+	}
+	p.info.RPCs = rpcs
+	g1.mu.Unlock(id)
+
+	g1.Relay("Foo", crypto.HashObject("foo"), "foo")
+	for i := 0; i < 50 && rt2.numReceived() == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if rt2.numReceived() != 1 {
+		t.Fatal("object was not pushed to peer")
+	}
+	if g1.Stats().Outgoing["Inv"].Calls != 0 {
+		t.Fatal("object was announced to a peer that does not support announcements")
+	}
+}
+
+// TestRelayWrongObject checks that an object that does not match its
+// announcement is rejected, and that the announced object can still be
+// requested from other peers.
+func TestRelayWrongObject(t *testing.T) {
+	g1 := newTestingGateway("TestRelayWrongObject1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestRelayWrongObject2", t)
+	defer g2.Close()
+	g3 := newTestingGateway("TestRelayWrongObject3", t)
+	defer g3.Close()
+	newRelayTester(g1)
+	rt2 := newRelayTester(g2)
+	newRelayTester(g3)
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g3.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	// g1 announces "foo", but serves "bar" in its place.
+	item := invItem{Name: "Foo", ID: crypto.HashObject("foo")}
+	id := g1.mu.Lock()
+	g1.storeInventory(item, encoding.Marshal("bar"))
+	g1.mu.Unlock(id)
+	g1.RPC(g2.Address(), "Inv", func(conn modules.PeerConn) error {
+		return encoding.WriteObject(conn, []invItem{item})
+	})
+	time.Sleep(100 * time.Millisecond)
+	if rt2.numReceived() != 0 {
+		t.Fatal("mismatched object was handled")
+	}
+	id = g2.mu.RLock()
+	seen := g2.seen.contains(item)
+	g2.mu.RUnlock(id)
+	if seen {
+		t.Fatal("mismatched object was marked as seen")
+	}
+
+	// The real object is still requested from an honest peer.
+	g3.Relay("Foo", crypto.HashObject("foo"), "foo")
+	for i := 0; i < 50 && rt2.numReceived() == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	rt2.mu.Lock()
+	received := rt2.received
+	rt2.mu.Unlock()
+	if len(received) != 1 || received[0] != "foo" {
+		t.Fatal("announced object was not received from honest peer:", received)
+	}
+}
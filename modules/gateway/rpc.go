@@ -3,7 +3,6 @@ package gateway
 import (
 	"errors"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/NebulousLabs/Sia/encoding"
@@ -36,38 +35,55 @@ func (g *Gateway) RPC(addr modules.NetAddress, name string, fn modules.RPCFunc)
 	//g.log.Printf("INFO: calling RPC \"%v\" on %v", name, addr)
 	id := g.mu.RLock()
 	peer, ok := g.peers[addr]
+	supported := ok && peer.supports(name)
 	g.mu.RUnlock(id)
 	if !ok {
 		return errors.New("can't call RPC on unconnected peer " + string(addr))
+	} else if !supported {
+		return errUnsupportedRPC
+	}
+	// wait until the peer has been sent our nodeInfo
+	if peer.ready != nil {
+		<-peer.ready
 	}
 
-	conn, err := peer.open()
+	pc, err := peer.open()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer pc.Close()
+	conn := &meteredConn{PeerConn: pc}
+	start := time.Now()
 
 	// write header
-	if err := encoding.WriteObject(conn, handlerName(name)); err != nil {
+	rpc := handlerName(name)
+	if err := encoding.WriteObject(conn, rpc); err != nil {
+		g.stats.record(peer, false, rpc, conn, time.Since(start), err)
 		return err
 	}
 	// call fn
 	err = fn(conn)
+	g.stats.record(peer, false, rpc, conn, time.Since(start), err)
 	if err != nil {
 		g.log.Printf("WARN: calling RPC \"%v\" on peer %v returned error: %v", name, addr, err)
-		// give peer a strike
-		atomic.AddUint32(&peer.strikes, 1)
+		g.handlePeerError(peer, err)
 	}
 	return err
 }
 
 // RegisterRPC registers an RPCFunc as a handler for a given identifier. To
 // call an RPC, use gateway.RPC, supplying the same identifier given to
-// RegisterRPC. Identifiers should always use PascalCase.
+// RegisterRPC. Identifiers should always use PascalCase. Peers that are
+// already connected are told about the new RPC before RegisterRPC returns.
 func (g *Gateway) RegisterRPC(name string, fn modules.RPCFunc) {
 	id := g.mu.Lock()
-	defer g.mu.Unlock(id)
 	g.handlers[handlerName(name)] = fn
+	g.infoVersion++
+	numPeers := len(g.peers)
+	g.mu.Unlock(id)
+	if numPeers > 0 {
+		g.broadcastInfo()
+	}
 }
 
 // RegisterConnectCall registers a name and RPCFunc to be called on a peer
@@ -89,18 +105,21 @@ func (g *Gateway) listenPeer(p *peer) {
 		}
 
 		// it is the handler's responsibility to close the connection
-		go g.threadedHandleConn(conn)
+		go g.threadedHandleConn(p, conn)
 	}
 	g.Disconnect(p.addr)
 }
 
 // threadedHandleConn reads header data from a connection, then routes it to the
 // appropriate handler for further processing.
-func (g *Gateway) threadedHandleConn(conn modules.PeerConn) {
-	defer conn.Close()
+func (g *Gateway) threadedHandleConn(p *peer, pc modules.PeerConn) {
+	defer pc.Close()
+	conn := &meteredConn{PeerConn: pc}
+	start := time.Now()
 	var id rpcID
 	if err := encoding.ReadObject(conn, &id, 8); err != nil {
 		g.log.Printf("WARN: could not read RPC identifier from incoming conn %v: %v", conn.RemoteAddr(), err)
+		g.handlePeerError(p, err)
 		return
 	}
 	// call registered handler for this ID
@@ -110,22 +129,35 @@ func (g *Gateway) threadedHandleConn(conn modules.PeerConn) {
 	if !ok {
 		// TODO: write this error to conn?
 		g.log.Printf("WARN: incoming conn %v requested unknown RPC \"%v\"", conn.RemoteAddr(), id)
+		g.handlePeerError(p, modules.PeerError{Offense: modules.OffenseMalformedRPC, Err: errors.New("unknown RPC")})
 		return
 	}
 
 	// TODO: change to debug log
 	//g.log.Printf("INFO: handling RPC \"%v\" from %v", id, conn.RemoteAddr())
-	if err := fn(conn); err != nil {
+	// Only known RPCs are recorded, so that a peer cannot grow the stats
+	// without bound by sending random identifiers.
+	err := fn(conn)
+	g.stats.record(p, true, id, conn, time.Since(start), err)
+	if err != nil {
 		g.log.Printf("WARN: incoming RPC \"%v\" failed: %v", id, err)
+		g.handlePeerError(p, err)
 	}
 }
 
-// Broadcast calls an RPC on all of the peers in the Gateway's peer list. The
-// calls are run in parallel. Broadcasts are restricted to "one-way" RPCs,
-// which simply write an object and disconnect. This is why Broadcast takes an
-// interface{} instead of an RPCFunc.
+// Broadcast calls an RPC on all of the peers in the Gateway's peer list that
+// support it. The calls are run in parallel. Broadcasts are restricted to
+// "one-way" RPCs, which simply write an object and disconnect. This is why
+// Broadcast takes an interface{} instead of an RPCFunc.
 func (g *Gateway) Broadcast(name string, obj interface{}) {
-	peers := g.Peers()
+	id := g.mu.RLock()
+	var peers []modules.NetAddress
+	for addr, p := range g.peers {
+		if p.supports(name) {
+			peers = append(peers, addr)
+		}
+	}
+	g.mu.RUnlock(id)
 	g.log.Printf("INFO: broadcasting RPC \"%v\" to %v peers", name, len(peers))
 
 	// only encode obj once, instead of using WriteObject
@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// handshakeTimeout is the amount of time a peer has to complete the
+	// handshake before the connection is dropped.
+	handshakeTimeout = 20 * time.Second
+
+	// maxFrameSize is the largest amount of plaintext sent in a single
+	// encrypted frame.
+	maxFrameSize = 1 << 16
+
+	// frameHeaderSize is the size of the length prefix of each frame.
+	frameHeaderSize = 4
+)
+
+var (
+	errBadFrame    = errors.New("peer sent a frame that is too large")
+	errBadIdentity = errors.New("peer could not prove its identity")
+	errBadMAC      = errors.New("peer sent a frame that could not be authenticated")
+	errNetwork     = errors.New("peer is on a different network")
+)
+
+// A handshakeAuth is sent by each side of a handshake, over the encrypted
+// connection, to prove ownership of its node identity. Signature signs the
+// sender's ephemeral key followed by the receiver's ephemeral key.
+type handshakeAuth struct {
+	ID        modules.NodeID
+	Signature crypto.Signature
+}
+
+// A secureConn is a net.Conn that encrypts and authenticates all data sent
+// over it. Data is sent in length-prefixed frames, each sealed with
+// ChaCha20-Poly1305 under a per-direction key. Nonces are frame counters, so
+// dropped, reordered, or replayed frames cause decryption to fail.
+type secureConn struct {
+	net.Conn
+
+	sendAEAD  cipher.AEAD
+	sendNonce uint64
+	writeMu   sync.Mutex
+
+	recvAEAD  cipher.AEAD
+	recvNonce uint64
+	readBuf   []byte // plaintext that has been decrypted but not yet read
+	readMu    sync.Mutex
+}
+
+// frameNonce returns the nonce for the frame with the given counter.
+func frameNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce, counter)
+	return nonce
+}
+
+// readFrame reads and decrypts the next frame into readBuf. The caller must
+// hold readMu.
+func (sc *secureConn) readFrame() error {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(sc.Conn, header); err != nil {
+		return err
+	}
+	frameLen := binary.LittleEndian.Uint32(header)
+	if frameLen > maxFrameSize+uint32(sc.recvAEAD.Overhead()) {
+		return errBadFrame
+	}
+	frame := make([]byte, frameLen)
+	if _, err := io.ReadFull(sc.Conn, frame); err != nil {
+		return err
+	}
+	plaintext, err := sc.recvAEAD.Open(frame[:0], frameNonce(sc.recvNonce), frame, header)
+	if err != nil {
+		return errBadMAC
+	}
+	sc.recvNonce++
+	sc.readBuf = plaintext
+	return nil
+}
+
+// Read implements the io.Reader interface.
+func (sc *secureConn) Read(p []byte) (int, error) {
+	sc.readMu.Lock()
+	defer sc.readMu.Unlock()
+
+	if len(sc.readBuf) == 0 {
+		if err := sc.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, sc.readBuf)
+	sc.readBuf = sc.readBuf[n:]
+	return n, nil
+}
+
+// Write implements the io.Writer interface. Large writes are split across
+// multiple frames.
+func (sc *secureConn) Write(p []byte) (int, error) {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		frame := make([]byte, frameHeaderSize, frameHeaderSize+len(chunk)+sc.sendAEAD.Overhead())
+		binary.LittleEndian.PutUint32(frame, uint32(len(chunk)+sc.sendAEAD.Overhead()))
+		frame = sc.sendAEAD.Seal(frame, frameNonce(sc.sendNonce), chunk, frame[:frameHeaderSize])
+		sc.sendNonce++
+		if _, err := sc.Conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// handshake performs an authenticated key exchange over conn, returning an
+// encrypted connection and the identity of the peer. Each side sends an
+// ephemeral X25519 key, and the resulting shared secret is used to derive a
+// key for each direction. Each side then proves its identity over the
+// encrypted connection by signing both ephemeral keys with its identity key.
+// Signing the ephemeral keys binds the identity to this session, so a
+// man-in-the-middle cannot relay the signature.
+func (g *Gateway) handshake(conn net.Conn, dialer bool) (*secureConn, modules.NodeID, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// Exchange ephemeral keys.
+	ourSecret := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ourSecret); err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	ourEphemeral, err := curve25519.X25519(ourSecret, curve25519.Basepoint)
+	if err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	if _, err := conn.Write(ourEphemeral); err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	theirEphemeral := make([]byte, curve25519.PointSize)
+	if _, err := io.ReadFull(conn, theirEphemeral); err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	// X25519 rejects low-order points, which would yield a predictable
+	// shared secret.
+	shared, err := curve25519.X25519(ourSecret, theirEphemeral)
+	if err != nil {
+		return nil, modules.NodeID{}, err
+	}
+
+	// Derive a key for each direction. The keys commit to both ephemeral keys
+	// in a fixed order, so both sides derive the same pair.
+	dialerEphemeral, listenerEphemeral := ourEphemeral, theirEphemeral
+	if !dialer {
+		dialerEphemeral, listenerEphemeral = theirEphemeral, ourEphemeral
+	}
+	dialerKey := crypto.HashAll("dialer", shared, dialerEphemeral, listenerEphemeral)
+	listenerKey := crypto.HashAll("listener", shared, dialerEphemeral, listenerEphemeral)
+	// Nodes on a private network also commit to the network name, so they
+	// derive different keys than nodes on any other network. The main network
+	// has no name, which keeps its keys compatible with older nodes.
+	if g.network != "" {
+		dialerKey = crypto.HashAll("dialer", g.network, shared, dialerEphemeral, listenerEphemeral)
+		listenerKey = crypto.HashAll("listener", g.network, shared, dialerEphemeral, listenerEphemeral)
+	}
+	sendKey, recvKey := dialerKey, listenerKey
+	if !dialer {
+		sendKey, recvKey = listenerKey, dialerKey
+	}
+	// NOTE: chacha20poly1305.New only returns an error if the key is not 32
+	// bytes.
+	sendAEAD, _ := chacha20poly1305.New(sendKey[:])
+	recvAEAD, _ := chacha20poly1305.New(recvKey[:])
+	sc := &secureConn{
+		Conn:     conn,
+		sendAEAD: sendAEAD,
+		recvAEAD: recvAEAD,
+	}
+
+	// Prove our identity and verify theirs.
+	sig, err := crypto.SignHash(crypto.HashAll(ourEphemeral, theirEphemeral), g.secretKey)
+	if err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	if err := encoding.WriteObject(sc, handshakeAuth{ID: g.id, Signature: sig}); err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	// The first frame from the peer can only be decrypted if both sides
+	// derived the same keys, i.e. they are on the same network.
+	sc.readMu.Lock()
+	err = sc.readFrame()
+	sc.readMu.Unlock()
+	if err == errBadMAC {
+		return nil, modules.NodeID{}, errNetwork
+	} else if err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	var auth handshakeAuth
+	if err := encoding.ReadObject(sc, &auth, uint64(len(encoding.Marshal(auth)))); err != nil {
+		return nil, modules.NodeID{}, err
+	}
+	if crypto.VerifyHash(crypto.HashAll(theirEphemeral, ourEphemeral), crypto.PublicKey(auth.ID), auth.Signature) != nil {
+		return nil, modules.NodeID{}, errBadIdentity
+	}
+	return sc, auth.ID, nil
+}
@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// secureConnPair performs a handshake between g1 and g2 over a loopback TCP
+// connection, returning the dialing and listening ends.
+func secureConnPair(g1, g2 *Gateway, t *testing.T) (*secureConn, *secureConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	type result struct {
+		sc  *secureConn
+		id  modules.NodeID
+		err error
+	}
+	accepted := make(chan result)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+		sc, id, err := g2.handshake(conn, false)
+		accepted <- result{sc, id, err}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc1, id2, err := g1.handshake(conn, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if id2 != g2.id || r.id != g1.id {
+		t.Fatal("handshake reported the wrong identities")
+	}
+	return sc1, r.sc
+}
+
+// TestSecureConn checks that data sent over a secureConn arrives intact, and
+// that tampered frames are rejected.
+func TestSecureConn(t *testing.T) {
+	g1 := newTestingGateway("TestSecureConn1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestSecureConn2", t)
+	defer g2.Close()
+
+	sc1, sc2 := secureConnPair(g1, g2, t)
+	defer sc1.Close()
+	defer sc2.Close()
+
+	// Send a message large enough to span multiple frames.
+	msg := make([]byte, 3*maxFrameSize+17)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	go sc1.Write(msg)
+	received := make([]byte, len(msg))
+	if _, err := io.ReadFull(sc2, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, received) {
+		t.Fatal("message was corrupted in transit")
+	}
+
+	// The plaintext should not appear on the wire.
+	hello := []byte("hello, world")
+	go sc2.Write(hello)
+	frame := make([]byte, frameHeaderSize+len(hello)+sc2.sendAEAD.Overhead())
+	if _, err := io.ReadFull(sc1.Conn, frame); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(frame, hello) {
+		t.Fatal("frame contains plaintext")
+	}
+
+	// A tampered frame should fail to decrypt.
+	go func() {
+		frame[len(frame)-1] ^= 1
+		sc2.Conn.Write(frame)
+	}()
+	if _, err := sc1.Read(make([]byte, len(hello))); err == nil {
+		t.Fatal("tampered frame was accepted")
+	}
+}
+
+// TestIdentity checks that the Gateway's identity persists across restarts,
+// is reported by Peers, and prevents connecting to ourselves.
+func TestIdentity(t *testing.T) {
+	g1 := newTestingGateway("TestIdentity1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestIdentity2", t)
+	defer g2.Close()
+
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+	peers := g1.Peers()
+	if len(peers) != 1 || peers[0].ID != g2.ID() || peers[0].Inbound {
+		t.Fatal("g1 has bad peer list:", peers)
+	}
+	peers = g2.Peers()
+	if len(peers) != 1 || peers[0].ID != g1.ID() || !peers[0].Inbound {
+		t.Fatal("g2 has bad peer list:", peers)
+	}
+
+	// Connecting to ourselves under a different address should be detected.
+	port := modules.NetAddress(g1.listener.Addr().String()).Port()
+	if err := g1.Connect(modules.NetAddress(net.JoinHostPort("127.0.0.1", port))); err != errSelfConnect {
+		t.Fatal("expected errSelfConnect, got", err)
+	}
+
+	// The identity should survive a restart.
+	g2.Close()
+	g3, err := New(":0", g2.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g3.Close()
+	if g3.ID() != g2.ID() {
+		t.Fatal("identity changed after restart")
+	}
+}
+
+// TestNetwork checks that gateways only complete handshakes with gateways on
+// the same network.
+func TestNetwork(t *testing.T) {
+	g1 := newTestingGateway("TestNetwork1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestNetwork2", t)
+	defer g2.Close()
+
+	// A gateway on a private network cannot connect to the main network.
+	g1.network = "testnet"
+	if err := g1.Connect(g2.Address()); err != errNetwork {
+		t.Fatal("expected errNetwork, got", err)
+	}
+	if len(g1.Peers()) != 0 {
+		t.Fatal("gateways on different networks became peers")
+	}
+
+	// Nor can it connect to a different private network.
+	g2.network = "othernet"
+	if err := g1.Connect(g2.Address()); err != errNetwork {
+		t.Fatal("expected errNetwork, got", err)
+	}
+
+	// Gateways on the same private network connect normally.
+	g2.network = "testnet"
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal(err)
+	}
+}
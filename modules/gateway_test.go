@@ -0,0 +1,47 @@
+package modules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// TestReadPeersFile checks that peer files are parsed correctly.
+func TestReadPeersFile(t *testing.T) {
+	dir := build.TempDir("modules", "TestReadPeersFile")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "peers.txt")
+	contents := "# test cluster\n10.0.0.1:9981\n\n  [fd00::2]:9981  \nexample.com:9981\n"
+	if err := ioutil.WriteFile(filename, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := ReadPeersFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NetAddress{"10.0.0.1:9981", "[fd00::2]:9981", "example.com:9981"}
+	if len(addrs) != len(expected) {
+		t.Fatal("wrong peers:", addrs)
+	}
+	for i := range addrs {
+		if addrs[i] != expected[i] {
+			t.Fatal("wrong peers:", addrs)
+		}
+	}
+
+	// Addresses without a port are rejected.
+	if err := ioutil.WriteFile(filename, []byte("10.0.0.1:9981\n10.0.0.2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPeersFile(filename); err == nil {
+		t.Fatal("expected error for address without port")
+	}
+	if _, err := ReadPeersFile(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatal("expected not-exist error, got", err)
+	}
+}
@@ -1,12 +1,22 @@
 package modules
 
 import (
+	"time"
+
 	"github.com/NebulousLabs/Sia/types"
 )
 
 const (
 	AcceptTermsResponse = "accept"
 	HostDir             = "host"
+
+	// ContractStatusActive, ContractStatusProved, and ContractStatusMissed
+	// describe the state of a contract in the host's ledger. A contract is
+	// active until a storage proof for it appears in the blockchain (proved)
+	// or its proof window closes without one (missed).
+	ContractStatusActive = "active"
+	ContractStatusProved = "proved"
+	ContractStatusMissed = "missed"
 )
 
 // ContractTerms are the parameters agreed upon by a client and a host when
@@ -22,6 +32,33 @@ type ContractTerms struct {
 	MissedProofOutputs []types.SiacoinOutput // Where the money goes if the storage proof fails.
 }
 
+// A HostContract is the host's accounting record for a single file contract.
+// Records are kept after the contract has resolved so that the host's earnings
+// can be audited.
+type HostContract struct {
+	ID          types.FileContractID
+	FileSize    uint64
+	StartHeight types.BlockHeight // The height at which the contract was formed.
+	WindowStart types.BlockHeight
+	WindowEnd   types.BlockHeight
+	Status      string
+
+	LockedCollateral types.Currency // Collateral put into the contract by the host.
+	ExpectedRevenue  types.Currency // Paid to the host beyond its collateral if the proof succeeds.
+	RealizedRevenue  types.Currency // Revenue from a storage proof that made it into the blockchain.
+	FeesPaid         types.Currency // Siafund fee taken from the contract payout.
+}
+
+// HostNetworkLimits restrict the connections that the host will accept. They
+// are not advertised to renters. A value of 0 means that there is no limit.
+type HostNetworkLimits struct {
+	MaxConns      int           // Maximum number of concurrent connections.
+	MaxConnsPerIP int           // Maximum number of concurrent connections from a single IP.
+	RPCTimeout    time.Duration // Maximum time an RPC may go without reading or writing.
+	UploadRate    int64         // Bytes per second sent to a single IP.
+	DownloadRate  int64         // Bytes per second received from a single IP.
+}
+
 // HostInfo contains HostSettings and details pertinent to the host's understanding
 // of their offered services
 type HostInfo struct {
@@ -29,8 +66,17 @@ type HostInfo struct {
 
 	StorageRemaining int64
 	NumContracts     int
-	Profit           types.Currency
+	Profit           types.Currency // Only counts contracts with a successful storage proof.
 	PotentialProfit  types.Currency
+	LockedCollateral types.Currency
+	MissedProofs     int
+
+	// FailingObligations lists the contracts whose files no longer match
+	// their Merkle root, and which will fail their storage proof.
+	FailingObligations []types.FileContractID
+
+	NetworkLimits HostNetworkLimits
+	ActiveConns   int
 
 	Competition types.Currency
 }
@@ -57,7 +103,22 @@ type Host interface {
 	// Settings returns the host's settings.
 	Settings() HostSettings
 
+	// Close stops the host from accepting new connections, waits for
+	// in-flight RPCs to finish, and saves the host.
+	Close() error
+
+	// SetNetworkLimits sets the limits on the connections that the host will
+	// accept. Rate limits only apply to new connections.
+	SetNetworkLimits(HostNetworkLimits)
+
+	// NetworkLimits returns the host's connection limits.
+	NetworkLimits() HostNetworkLimits
+
 	// Info returns info about the host, including its hosting parameters, the
 	// amount of storage remaining, and the number of active contracts.
 	Info() HostInfo
+
+	// Contracts returns the host's ledger of file contracts, including
+	// contracts that have already been proved or missed.
+	Contracts() []HostContract
 }
@@ -0,0 +1,133 @@
+package host
+
+// accounting.go keeps a ledger of every file contract the host has formed. The
+// ledger tracks the collateral the host has locked up, the revenue it expects
+// to receive, and whether that revenue was actually realized by a storage
+// proof making it into the blockchain.
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// recordContract adds a newly formed file contract to the ledger.
+func (h *Host) recordContract(fcid types.FileContractID, fc types.FileContract, terms modules.ContractTerms) {
+	sizeCurrency := types.NewCurrency64(terms.FileSize)
+	durationCurrency := types.NewCurrency64(uint64(terms.Duration))
+	collateral := terms.Collateral.Mul(sizeCurrency).Mul(durationCurrency)
+
+	// The host receives its valid proof output, which includes the returned
+	// collateral. Only the remainder counts as revenue.
+	var expected types.Currency
+	if len(fc.ValidProofOutputs) > 0 && fc.ValidProofOutputs[0].Value.Cmp(collateral) > 0 {
+		expected = fc.ValidProofOutputs[0].Value.Sub(collateral)
+	}
+
+	h.contracts[fcid] = modules.HostContract{
+		ID:          fcid,
+		FileSize:    fc.FileSize,
+		StartHeight: h.cs.Height(),
+		WindowStart: fc.WindowStart,
+		WindowEnd:   fc.WindowEnd,
+		Status:      modules.ContractStatusActive,
+
+		LockedCollateral: collateral,
+		ExpectedRevenue:  expected,
+		FeesPaid:         fc.Tax(),
+	}
+}
+
+// setContractStatus moves a contract in the ledger to a new status, adjusting
+// the realized revenue of the contract and the profit of the host. false is
+// returned if the ledger was not changed.
+func (h *Host) setContractStatus(fcid types.FileContractID, status string) bool {
+	hc, exists := h.contracts[fcid]
+	if !exists || hc.Status == status {
+		return false
+	}
+	if hc.Status == modules.ContractStatusProved {
+		h.profit = h.profit.Sub(hc.RealizedRevenue)
+		hc.RealizedRevenue = types.ZeroCurrency
+	}
+	if status == modules.ContractStatusProved {
+		hc.RealizedRevenue = hc.ExpectedRevenue
+		h.profit = h.profit.Add(hc.RealizedRevenue)
+	}
+	hc.Status = status
+	h.contracts[fcid] = hc
+	return true
+}
+
+// updateContracts uses a consensus change to determine which contracts in the
+// ledger have been proved or missed. A contract is proved when a block
+// containing a storage proof for it is applied, and missed when it leaves the
+// consensus set at the end of its window without a proof. Reverted blocks undo
+// both transitions.
+func (h *Host) updateContracts(cc modules.ConsensusChange) {
+	changed := false
+	for _, block := range cc.RevertedBlocks {
+		for _, txn := range block.Transactions {
+			for _, sp := range txn.StorageProofs {
+				changed = h.setContractStatus(sp.ParentID, modules.ContractStatusActive) || changed
+			}
+		}
+	}
+	proved := make(map[types.FileContractID]struct{})
+	for _, block := range cc.AppliedBlocks {
+		for _, txn := range block.Transactions {
+			for _, sp := range txn.StorageProofs {
+				proved[sp.ParentID] = struct{}{}
+				changed = h.setContractStatus(sp.ParentID, modules.ContractStatusProved) || changed
+			}
+		}
+	}
+
+	// The consensus height after the change is applied. consensusHeight
+	// counts the genesis block, so it is one larger than the block height.
+	height := h.consensusHeight - types.BlockHeight(len(cc.RevertedBlocks)) + types.BlockHeight(len(cc.AppliedBlocks)) - 1
+	for _, fcd := range cc.FileContractDiffs {
+		hc, exists := h.contracts[fcd.ID]
+		if !exists {
+			continue
+		}
+		_, hasProof := proved[fcd.ID]
+		switch {
+		case fcd.Direction == modules.DiffRevert && !hasProof && hc.Status == modules.ContractStatusActive && fcd.FileContract.WindowEnd <= height:
+			changed = h.setContractStatus(fcd.ID, modules.ContractStatusMissed) || changed
+		case fcd.Direction == modules.DiffApply && hc.Status == modules.ContractStatusMissed:
+			changed = h.setContractStatus(fcd.ID, modules.ContractStatusActive) || changed
+		}
+	}
+	if changed {
+		_ = h.save() // TODO: Some way to communicate that the save failed.
+	}
+}
+
+// contractsByExpiry sorts contracts by the end of their proof window, breaking
+// ties by ID.
+type contractsByExpiry []modules.HostContract
+
+func (ce contractsByExpiry) Len() int      { return len(ce) }
+func (ce contractsByExpiry) Swap(i, j int) { ce[i], ce[j] = ce[j], ce[i] }
+func (ce contractsByExpiry) Less(i, j int) bool {
+	if ce[i].WindowEnd != ce[j].WindowEnd {
+		return ce[i].WindowEnd < ce[j].WindowEnd
+	}
+	return bytes.Compare(ce[i].ID[:], ce[j].ID[:]) < 0
+}
+
+// Contracts returns the host's ledger of file contracts, ordered by the end of
+// their proof window.
+func (h *Host) Contracts() (contracts []modules.HostContract) {
+	lockID := h.mu.RLock()
+	defer h.mu.RUnlock(lockID)
+
+	for _, hc := range h.contracts {
+		contracts = append(contracts, hc)
+	}
+	sort.Sort(contractsByExpiry(contracts))
+	return
+}
@@ -0,0 +1,112 @@
+package host
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// testContract adds a contract to the ledger of the host, returning its id.
+func (ht *hostTester) testContract(id byte, windowEnd types.BlockHeight) types.FileContractID {
+	fcid := types.FileContractID{id}
+	fc := types.FileContract{
+		FileSize:    4e3,
+		WindowStart: windowEnd - 1,
+		WindowEnd:   windowEnd,
+		Payout:      types.NewCurrency64(10e3),
+		ValidProofOutputs: []types.SiacoinOutput{
+			{Value: types.NewCurrency64(9e3)},
+		},
+	}
+	terms := modules.ContractTerms{
+		FileSize:   4e3,
+		Duration:   1,
+		Collateral: types.NewCurrency64(1),
+	}
+	lockID := ht.host.mu.Lock()
+	ht.host.recordContract(fcid, fc, terms)
+	ht.host.mu.Unlock(lockID)
+	return fcid
+}
+
+// TestContractLedger checks that contracts in the ledger move between states
+// as storage proofs are applied, reverted, and missed.
+func TestContractLedger(t *testing.T) {
+	ht := CreateHostTester("TestContractLedger", t)
+	h := ht.host
+
+	fcid := ht.testContract(1, 1)
+	hc := h.contracts[fcid]
+	if hc.Status != modules.ContractStatusActive {
+		t.Fatal("new contract should be active")
+	}
+	if hc.LockedCollateral.Cmp(types.NewCurrency64(4e3)) != 0 {
+		t.Error("wrong collateral:", hc.LockedCollateral)
+	}
+	if hc.ExpectedRevenue.Cmp(types.NewCurrency64(5e3)) != 0 {
+		t.Error("wrong expected revenue:", hc.ExpectedRevenue)
+	}
+
+	// Apply a block containing a storage proof for the contract.
+	initialProfit := h.profit
+	proofBlock := types.Block{
+		Transactions: []types.Transaction{{
+			StorageProofs: []types.StorageProof{{ParentID: fcid}},
+		}},
+	}
+	h.updateContracts(modules.ConsensusChange{AppliedBlocks: []types.Block{proofBlock}})
+	hc = h.contracts[fcid]
+	if hc.Status != modules.ContractStatusProved {
+		t.Fatal("contract should be proved")
+	}
+	if h.profit.Cmp(initialProfit.Add(hc.ExpectedRevenue)) != 0 {
+		t.Error("profit did not increase by the expected revenue")
+	}
+
+	// Revert the block with the storage proof.
+	h.updateContracts(modules.ConsensusChange{RevertedBlocks: []types.Block{proofBlock}})
+	hc = h.contracts[fcid]
+	if hc.Status != modules.ContractStatusActive {
+		t.Fatal("contract should be active after the proof is reverted")
+	}
+	if h.profit.Cmp(initialProfit) != 0 || !hc.RealizedRevenue.IsZero() {
+		t.Error("revenue was not reverted")
+	}
+
+	// Remove the contract from the consensus set without a proof.
+	h.updateContracts(modules.ConsensusChange{
+		AppliedBlocks: []types.Block{{}},
+		FileContractDiffs: []modules.FileContractDiff{{
+			Direction:    modules.DiffRevert,
+			ID:           fcid,
+			FileContract: types.FileContract{WindowEnd: 1},
+		}},
+	})
+	if h.contracts[fcid].Status != modules.ContractStatusMissed {
+		t.Fatal("contract should be missed")
+	}
+	if h.Info().MissedProofs != 1 {
+		t.Error("missed proof not reported in host info")
+	}
+	if len(h.Contracts()) != 1 {
+		t.Error("wrong number of contracts in the ledger")
+	}
+}
+
+// TestContractsOrder checks that the ledger is ordered by the end of the proof
+// window, and then by ID.
+func TestContractsOrder(t *testing.T) {
+	ht := CreateHostTester("TestContractsOrder", t)
+
+	ht.testContract(3, 5)
+	ht.testContract(2, 9)
+	ht.testContract(1, 5)
+	contracts := ht.host.Contracts()
+	if len(contracts) != 3 {
+		t.Fatal("wrong number of contracts in the ledger:", len(contracts))
+	}
+	if contracts[0].ID[0] != 1 || contracts[1].ID[0] != 3 || contracts[2].ID[0] != 2 {
+		t.Error("contracts are not sorted:", contracts[0].ID, contracts[1].ID, contracts[2].ID)
+	}
+}
@@ -3,10 +3,13 @@ package host
 import (
 	"errors"
 	"net"
+	"strconv"
 	"time"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/nat"
 	"github.com/NebulousLabs/Sia/types"
 )
 
@@ -17,7 +20,7 @@ const (
 // ping establishes a connection to addr and then immediately closes it. It is
 // used to verify that an address is connectible.
 func ping(addr modules.NetAddress) bool {
-	conn, err := net.DialTimeout("tcp", string(addr), pingTimeout)
+	conn, err := modules.Dial(addr, pingTimeout)
 	if err != nil {
 		return false
 	}
@@ -25,6 +28,66 @@ func ping(addr modules.NetAddress) bool {
 	return true
 }
 
+// threadedForwardPort forwards the host's port on the router and learns the
+// host's external IP. If there is no router, a public interface address is
+// used instead.
+func (h *Host) threadedForwardPort(port string) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		h.log.Println("WARN: could not parse port:", err)
+		return
+	}
+	f, ip, err := nat.Forward(uint16(p), "Sia host", h.log)
+	if err != nil {
+		h.log.Println("WARN: could not forward port using UPnP or NAT-PMP:", err)
+		if ip, err = nat.InterfaceIP(); err != nil {
+			h.log.Println("WARN: could not learn external IP; the host will not be able to announce")
+			return
+		}
+	}
+
+	lockID := h.mu.Lock()
+	select {
+	case <-h.closeChan:
+		// The host was closed while the port was being forwarded, so Close
+		// will not remove the mapping.
+		h.mu.Unlock(lockID)
+		if f != nil {
+			if err := f.Close(); err != nil {
+				h.log.Println("WARN: could not remove port mapping:", err)
+			}
+		}
+		return
+	default:
+	}
+	defer h.mu.Unlock(lockID)
+	h.forwarder = f
+	if nat.IsPublic(ip) {
+		h.myAddr = modules.NetAddress(net.JoinHostPort(ip.String(), port))
+		h.log.Println("INFO: our address is", h.myAddr)
+	} else {
+		h.log.Println("WARN: router reported a private external IP:", ip)
+	}
+}
+
+// externalAddr returns the address that the host announces. If the host has
+// not learned its external IP from the router or a network interface, the IP
+// that the gateway learned from its peers is used instead. The host's port
+// must still be reachable, e.g. by forwarding it by hand.
+func (h *Host) externalAddr() modules.NetAddress {
+	lockID := h.mu.RLock()
+	addr := h.myAddr
+	h.mu.RUnlock(lockID)
+	if addr.Host() != "::1" {
+		return addr
+	}
+	ip := net.ParseIP(h.gateway.Address().Host())
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return addr
+	}
+	return modules.NetAddress(net.JoinHostPort(ip.String(), addr.Port()))
+}
+
 // announce creates an announcement transaction and submits it to the network.
 func (h *Host) announce(addr modules.NetAddress) error {
 	// create the transaction that will hold the announcement
@@ -34,11 +97,20 @@ func (h *Host) announce(addr modules.NetAddress) error {
 		return err
 	}
 
-	// create and encode the announcement and add it to the arbitrary data of
-	// the transaction.
-	announcement := encoding.Marshal(modules.HostAnnouncement{
+	// create, sign, and encode the announcement and add it to the arbitrary
+	// data of the transaction.
+	lockID := h.mu.RLock()
+	ha := modules.HostAnnouncement{
 		IPAddress: addr,
-	})
+		PublicKey: h.PublicKey,
+	}
+	sk := h.secretKey
+	h.mu.RUnlock(lockID)
+	ha.Signature, err = crypto.SignHash(ha.SigHash(), sk)
+	if err != nil {
+		return err
+	}
+	announcement := encoding.Marshal(ha)
 	_, _, err = h.wallet.AddArbitraryData(id, modules.PrefixHostAnnouncement+string(announcement))
 	if err != nil {
 		return err
@@ -64,9 +136,7 @@ func (h *Host) announce(addr modules.NetAddress) error {
 // arbitrary data, signing the transaction, and submitting it to the
 // transaction pool.
 func (h *Host) Announce() error {
-	lockID := h.mu.RLock()
-	addr := h.myAddr
-	h.mu.RUnlock(lockID)
+	addr := h.externalAddr()
 	if addr.Host() == "::1" {
 		return errors.New("can't announce without knowing external IP")
 	} else if !ping(addr) {
@@ -78,8 +148,5 @@ func (h *Host) Announce() error {
 // ForceAnnounce skips the check for knowing your external IP and for checking
 // your port.
 func (h *Host) ForceAnnounce() error {
-	lockID := h.mu.RLock()
-	addr := h.myAddr
-	h.mu.RUnlock(lockID)
-	return h.announce(addr)
+	return h.announce(h.externalAddr())
 }
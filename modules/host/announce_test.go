@@ -31,7 +31,41 @@ func TestAnnouncement(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+	if ha.PublicKey != ht.host.Settings().PublicKey {
+		t.Error("announcement does not contain the public key of the host")
+	}
+	if err := ha.Verify(); err != nil {
+		t.Error("announcement is not signed by the host:", err)
+	}
 
 	// TODO: Need to check that the host announcement gets the host into the
 	// hostdb.
 }
+
+// learnedGateway is a gateway that has learned its external IP from its peers.
+type learnedGateway struct {
+	modules.Gateway
+	addr modules.NetAddress
+}
+
+// Address returns the learned address of the gateway.
+func (g learnedGateway) Address() modules.NetAddress {
+	return g.addr
+}
+
+// TestExternalAddr checks that a host that could not learn its external IP
+// announces the IP learned by the gateway.
+func TestExternalAddr(t *testing.T) {
+	ht := CreateHostTester("TestExternalAddr", t)
+	port := ht.host.Address().Port()
+
+	// The gateway has not learned an IP either.
+	if addr := ht.host.externalAddr(); addr != ht.host.Address() {
+		t.Fatal("host should use its own address:", addr)
+	}
+
+	ht.host.gateway = learnedGateway{ht.gateway, "1.2.3.4:9981"}
+	if addr := ht.host.externalAddr(); addr != modules.NetAddress("1.2.3.4:"+port) {
+		t.Fatal("host did not use the IP learned by the gateway:", addr)
+	}
+}
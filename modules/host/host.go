@@ -2,11 +2,16 @@ package host
 
 import (
 	"errors"
+	"log"
 	"net"
 	"os"
+	"time"
 
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/modules/consensus"
+	"github.com/NebulousLabs/Sia/nat"
 	"github.com/NebulousLabs/Sia/sync"
 	"github.com/NebulousLabs/Sia/types"
 )
@@ -17,6 +22,14 @@ const (
 	// of a reorg.
 	StorageProofReorgDepth = 10
 	maxContractLen         = 1 << 16 // The maximum allowed size of a file contract coming in over the wire. This does not include the file.
+
+	// closeTimeout is how long Close waits for in-flight RPCs to finish
+	// before saving the host anyway.
+	closeTimeout = time.Minute
+)
+
+var (
+	errHostClosed = errors.New("host has already been closed")
 )
 
 // A contractObligation tracks a file contract that the host is obligated to
@@ -31,6 +44,7 @@ type contractObligation struct {
 // performing the storage proofs on the received files.
 type Host struct {
 	cs          *consensus.State
+	gateway     modules.Gateway
 	hostdb      modules.HostDB
 	tpool       modules.TransactionPool
 	wallet      modules.Wallet
@@ -45,21 +59,53 @@ type Host struct {
 
 	listener net.Listener
 
+	// forwarder keeps the host's port forwarded on the router.
+	forwarder *nat.Forwarder
+
+	// closeChan is closed when the host is shut down. drained is closed by
+	// the last connection to be released after shutdown has begun.
+	closeChan chan struct{}
+	drained   chan struct{}
+
+	// secretKey is the key that the host uses to sign its announcements and
+	// to prove its identity to renters. It is generated when the host is
+	// first created and never changes.
+	secretKey crypto.SecretKey
+
+	// limits restrict the connections that the listener will accept.
+	// activeConns and connsByIP track the connections that are currently
+	// open.
+	limits      modules.HostNetworkLimits
+	activeConns int
+	connsByIP   map[string]*ipConns
+
 	obligationsByID     map[types.FileContractID]contractObligation
 	obligationsByHeight map[types.BlockHeight][]contractObligation
 
+	// contracts is the host's ledger, containing an accounting record for
+	// every contract the host has formed.
+	contracts map[types.FileContractID]modules.HostContract
+
+	// failingObligations contains the obligations whose files failed
+	// verification during the most recent scrub.
+	failingObligations map[types.FileContractID]struct{}
+
 	modules.HostSettings
 
 	subscriptions []chan struct{}
 
-	mu *sync.RWMutex
+	log *log.Logger
+	mu  *sync.RWMutex
 }
 
 // New returns an initialized Host.
-func New(cs *consensus.State, hdb modules.HostDB, tpool modules.TransactionPool, wallet modules.Wallet, addr string, saveDir string) (*Host, error) {
+func New(cs *consensus.State, g modules.Gateway, hdb modules.HostDB, tpool modules.TransactionPool, wallet modules.Wallet, addr string, saveDir string) (*Host, error) {
 	if cs == nil {
 		return nil, errors.New("host cannot use a nil state")
 	}
+	if g == nil {
+		return nil, errors.New("host cannot use a nil gateway")
+	}
 	if hdb == nil {
 		return nil, errors.New("host cannot use a nil hostdb")
 	}
@@ -75,10 +121,11 @@ func New(cs *consensus.State, hdb modules.HostDB, tpool modules.TransactionPool,
 		return nil, err
 	}
 	h := &Host{
-		cs:     cs,
-		hostdb: hdb,
-		tpool:  tpool,
-		wallet: wallet,
+		cs:      cs,
+		gateway: g,
+		hostdb:  hdb,
+		tpool:   tpool,
+		wallet:  wallet,
 
 		// default host settings
 		HostSettings: modules.HostSettings{
@@ -89,12 +136,20 @@ func New(cs *consensus.State, hdb modules.HostDB, tpool modules.TransactionPool,
 			Price:        types.NewCurrency64(100e12), // 0.1 siacoin / mb / week
 			Collateral:   types.NewCurrency64(0),
 			UnlockHash:   coinAddr,
+
+			AcceptingContracts: true,
 		},
 
-		saveDir: saveDir,
+		saveDir:   saveDir,
+		closeChan: make(chan struct{}),
+
+		limits:    defaultNetworkLimits,
+		connsByIP: make(map[string]*ipConns),
 
 		obligationsByID:     make(map[types.FileContractID]contractObligation),
 		obligationsByHeight: make(map[types.BlockHeight][]contractObligation),
+		contracts:           make(map[types.FileContractID]modules.HostContract),
+		failingObligations:  make(map[types.FileContractID]struct{}),
 
 		mu: sync.New(modules.SafeMutexDelay, 1),
 	}
@@ -106,19 +161,41 @@ func New(cs *consensus.State, hdb modules.HostDB, tpool modules.TransactionPool,
 		return nil, err
 	}
 	_, port, _ := net.SplitHostPort(h.listener.Addr().String())
-	h.myAddr = modules.NetAddress(net.JoinHostPort(modules.ExternalIP, port))
+	h.myAddr = modules.NetAddress(net.JoinHostPort("::1", port))
 
 	err = os.MkdirAll(saveDir, 0700)
 	if err != nil {
 		return nil, err
 	}
+	h.log, err = makeLogger(saveDir)
+	if err != nil {
+		return nil, err
+	}
 	err = h.load()
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
+	if h.PublicKey == (crypto.PublicKey{}) {
+		h.secretKey, h.PublicKey, err = crypto.GenerateSignatureKeys()
+		if err != nil {
+			return nil, err
+		}
+		err = h.save()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// spawn listener
+	// spawn listener and scrubber
 	go h.listen()
+	go h.threadedScrub()
+
+	// Forward our port and learn our external IP. This requires contacting
+	// the router, so it is skipped during testing. Users of a proxy do not
+	// want their IP to be learned.
+	if build.Release != "testing" && !modules.ProxyEnabled() {
+		go h.threadedForwardPort(port)
+	}
 
 	h.cs.ConsensusSetSubscribe(h)
 
@@ -126,10 +203,12 @@ func New(cs *consensus.State, hdb modules.HostDB, tpool modules.TransactionPool,
 }
 
 // SetConfig updates the host's internal HostSettings object. To modify
-// a specific field, use a combination of Info and SetConfig
+// a specific field, use a combination of Info and SetConfig. The public key of
+// the host cannot be changed.
 func (h *Host) SetSettings(settings modules.HostSettings) {
 	lockID := h.mu.Lock()
 	defer h.mu.Unlock(lockID)
+	settings.PublicKey = h.PublicKey
 	h.spaceRemaining += settings.TotalStorage - h.TotalStorage
 	h.HostSettings = settings
 	h.save()
@@ -142,8 +221,52 @@ func (h *Host) Settings() modules.HostSettings {
 	return h.HostSettings
 }
 
+// Close stops the host from accepting new connections and waits for in-flight
+// RPCs to finish before saving the host. RPCs that are still running after
+// closeTimeout are not waited for.
+func (h *Host) Close() error {
+	lockID := h.mu.Lock()
+	select {
+	case <-h.closeChan:
+		h.mu.Unlock(lockID)
+		return errHostClosed
+	default:
+	}
+	close(h.closeChan)
+	drained := make(chan struct{})
+	if h.activeConns == 0 {
+		close(drained)
+	} else {
+		h.drained = drained
+	}
+	h.mu.Unlock(lockID)
+
+	err := h.listener.Close()
+	lockID = h.mu.RLock()
+	forwarder := h.forwarder
+	h.mu.RUnlock(lockID)
+	if forwarder != nil {
+		if closeErr := forwarder.Close(); closeErr != nil {
+			h.log.Println("WARN: could not remove port mapping:", closeErr)
+		}
+	}
+	select {
+	case <-drained:
+	case <-time.After(closeTimeout):
+		h.log.Println("WARN: closing host with RPCs still in progress")
+	}
+
+	lockID = h.mu.Lock()
+	defer h.mu.Unlock(lockID)
+	if saveErr := h.save(); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
 func (h *Host) Address() modules.NetAddress {
-	// no lock needed; h.myAddr is only set once (in New).
+	lockID := h.mu.RLock()
+	defer h.mu.RUnlock(lockID)
 	return h.myAddr
 }
 
@@ -158,17 +281,27 @@ func (h *Host) Info() modules.HostInfo {
 		NumContracts:     len(h.obligationsByID),
 		Profit:           h.profit,
 	}
-	// sum up the current obligations to calculate PotentialProfit
-	for _, obligation := range h.obligationsByID {
-		fc := obligation.FileContract
-		info.PotentialProfit = info.PotentialProfit.Add(fc.Payout.Sub(fc.Tax()))
+	// sum up the ledger to calculate PotentialProfit and LockedCollateral
+	for _, hc := range h.contracts {
+		switch hc.Status {
+		case modules.ContractStatusActive:
+			info.PotentialProfit = info.PotentialProfit.Add(hc.ExpectedRevenue)
+			info.LockedCollateral = info.LockedCollateral.Add(hc.LockedCollateral)
+		case modules.ContractStatusMissed:
+			info.MissedProofs++
+		}
+	}
+	for fcid := range h.failingObligations {
+		info.FailingObligations = append(info.FailingObligations, fcid)
 	}
+	info.NetworkLimits = h.limits
+	info.ActiveConns = h.activeConns
 
 	// Calculate estimated competition (reported in per GB per month). Price
 	// calculated by taking the average of 8 ranomly selected weighted hosts.
 	var averagePrice types.Currency
 	// TODO: 8 is the sample size - to be made a constant.
-	hosts := h.hostdb.RandomHosts(8)
+	hosts := h.hostdb.RandomHosts(8, modules.HostSelectionConstraints{})
 	for _, host := range hosts {
 		averagePrice = averagePrice.Add(host.Price)
 	}
@@ -1,8 +1,11 @@
 package host
 
 import (
+	"net"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/modules"
@@ -75,11 +78,11 @@ func CreateHostTester(name string, t *testing.T) *hostTester {
 	if err != nil {
 		t.Fatal(err)
 	}
-	hdb, err := hostdb.New(cs, g)
+	hdb, err := hostdb.New(cs, g, filepath.Join(testdir, modules.HostDBDir))
 	if err != nil {
 		t.Fatal(err)
 	}
-	h, err := New(cs, hdb, tp, w, ":0", filepath.Join(testdir, modules.HostDir))
+	h, err := New(cs, g, hdb, tp, w, ":0", filepath.Join(testdir, modules.HostDir))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -115,3 +118,40 @@ func CreateHostTester(name string, t *testing.T) *hostTester {
 	}
 	return ht
 }
+
+// TestClose checks that closing the host waits for in-flight connections, saves
+// the host, and refuses new connections.
+func TestClose(t *testing.T) {
+	ht := CreateHostTester("TestClose", t)
+	h := ht.host
+
+	// Simulate an RPC that is in progress.
+	c1, _ := net.Pipe()
+	if _, ok := h.acceptConn(c1); !ok {
+		t.Fatal("connection was rejected")
+	}
+	closed := make(chan error)
+	go func() {
+		closed <- h.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("host closed with an RPC in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	h.releaseConn(c1)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	c2, _ := net.Pipe()
+	if _, ok := h.acceptConn(c2); ok {
+		t.Error("connection was accepted after the host was closed")
+	}
+	if err := h.Close(); err != errHostClosed {
+		t.Error("expected errHostClosed, got", err)
+	}
+	if _, err := os.Stat(filepath.Join(h.saveDir, "settings.json")); err != nil {
+		t.Error("host was not saved:", err)
+	}
+}
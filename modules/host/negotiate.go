@@ -16,6 +16,7 @@ import (
 
 var (
 	HostCapacityErr = errors.New("host is at capacity and can not take more files")
+	errNotAccepting = errors.New("host is not accepting new contracts")
 )
 
 // allocate allocates space for a file and creates it on disk.
@@ -42,6 +43,9 @@ func (h *Host) deallocate(filesize uint64, path string) {
 // within acceptable bounds, as defined by the host.
 func (h *Host) considerTerms(terms modules.ContractTerms) error {
 	switch {
+	case !h.AcceptingContracts:
+		return errNotAccepting
+
 	case terms.FileSize < h.MinFilesize:
 		return errors.New("file is too small")
 
@@ -280,6 +284,7 @@ func (h *Host) rpcContract(conn net.Conn) (err error) {
 	lockID = h.mu.Lock()
 	h.obligationsByHeight[proofHeight] = append(h.obligationsByHeight[proofHeight], co)
 	h.obligationsByID[fcid] = co
+	h.recordContract(fcid, fc, terms)
 	h.save()
 	h.mu.Unlock(lockID)
 
@@ -71,6 +71,15 @@ func (ht *hostTester) testConsiderTerms() {
 	if err != nil {
 		ht.t.Error(err)
 	}
+
+	// The same terms should be refused while the host is not accepting
+	// contracts.
+	ht.host.AcceptingContracts = false
+	err = ht.host.considerTerms(saneTerms)
+	ht.host.AcceptingContracts = true
+	if err != errNotAccepting {
+		ht.t.Error("expected errNotAccepting, got", err)
+	}
 }
 
 // TestAllocation creates a host tester and calls testAllocation.
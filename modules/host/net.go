@@ -2,8 +2,11 @@ package host
 
 import (
 	"net"
+	"sync"
+	"time"
 
 	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
 )
 
 type rpcID [8]byte
@@ -12,8 +15,150 @@ var (
 	idSettings = rpcID{'S', 'e', 't', 't', 'i', 'n', 'g', 's'}
 	idContract = rpcID{'C', 'o', 'n', 't', 'r', 'a', 'c', 't'}
 	idRetrieve = rpcID{'R', 'e', 't', 'r', 'i', 'e', 'v', 'e'}
+
+	// defaultNetworkLimits are the connection limits of a new host.
+	defaultNetworkLimits = modules.HostNetworkLimits{
+		MaxConns:      100,
+		MaxConnsPerIP: 10,
+		RPCTimeout:    2 * time.Minute,
+	}
 )
 
+// A rateLimiter paces transfers so that they do not exceed a number of bytes
+// per second. It may be shared by multiple connections.
+type rateLimiter struct {
+	rate int64 // 0 means unlimited.
+	next time.Time
+	mu   sync.Mutex
+}
+
+// wait blocks until n more bytes may be transferred.
+func (rl *rateLimiter) wait(n int) {
+	if rl.rate <= 0 || n <= 0 {
+		return
+	}
+	rl.mu.Lock()
+	now := time.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	sleep := rl.next.Sub(now)
+	rl.next = rl.next.Add(time.Duration(int64(n) * int64(time.Second) / rl.rate))
+	rl.mu.Unlock()
+	time.Sleep(sleep)
+}
+
+// An ipConns tracks the open connections from a single IP, along with the rate
+// limiters that they share.
+type ipConns struct {
+	count    int
+	upload   *rateLimiter
+	download *rateLimiter
+}
+
+// A limitedConn enforces the RPC timeout and the rate limits of the host on a
+// connection. The deadline is pushed back every time data is transferred, so
+// long transfers are not interrupted as long as they keep making progress.
+type limitedConn struct {
+	net.Conn
+	timeout  time.Duration
+	upload   *rateLimiter
+	download *rateLimiter
+}
+
+// extendDeadline pushes back the deadline of the connection.
+func (lc *limitedConn) extendDeadline() {
+	if lc.timeout > 0 {
+		lc.Conn.SetDeadline(time.Now().Add(lc.timeout))
+	}
+}
+
+// Read implements the io.Reader interface.
+func (lc *limitedConn) Read(b []byte) (int, error) {
+	lc.extendDeadline()
+	n, err := lc.Conn.Read(b)
+	lc.download.wait(n)
+	return n, err
+}
+
+// Write implements the io.Writer interface.
+func (lc *limitedConn) Write(b []byte) (int, error) {
+	lc.upload.wait(len(b))
+	lc.extendDeadline()
+	return lc.Conn.Write(b)
+}
+
+// connIP returns the IP that a connection originates from.
+func connIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// acceptConn checks a new connection against the host's connection limits. If
+// the connection is allowed, it is counted and wrapped in a limitedConn.
+func (h *Host) acceptConn(conn net.Conn) (*limitedConn, bool) {
+	lockID := h.mu.Lock()
+	defer h.mu.Unlock(lockID)
+
+	// No new connections are accepted once the host is shutting down.
+	select {
+	case <-h.closeChan:
+		return nil, false
+	default:
+	}
+
+	ip := connIP(conn)
+	ic, exists := h.connsByIP[ip]
+	if h.limits.MaxConns > 0 && h.activeConns >= h.limits.MaxConns {
+		h.log.Printf("INFO: rejected connection from %v: too many connections", conn.RemoteAddr())
+		return nil, false
+	}
+	if exists && h.limits.MaxConnsPerIP > 0 && ic.count >= h.limits.MaxConnsPerIP {
+		h.log.Printf("INFO: rejected connection from %v: too many connections from that IP", conn.RemoteAddr())
+		return nil, false
+	}
+	if !exists {
+		ic = &ipConns{
+			upload:   &rateLimiter{rate: h.limits.UploadRate},
+			download: &rateLimiter{rate: h.limits.DownloadRate},
+		}
+		h.connsByIP[ip] = ic
+	}
+	ic.count++
+	h.activeConns++
+
+	return &limitedConn{
+		Conn:     conn,
+		timeout:  h.limits.RPCTimeout,
+		upload:   ic.upload,
+		download: ic.download,
+	}, true
+}
+
+// releaseConn stops counting a connection against the host's limits.
+func (h *Host) releaseConn(conn net.Conn) {
+	lockID := h.mu.Lock()
+	defer h.mu.Unlock(lockID)
+
+	ip := connIP(conn)
+	h.activeConns--
+	if ic, exists := h.connsByIP[ip]; exists {
+		ic.count--
+		if ic.count <= 0 {
+			delete(h.connsByIP, ip)
+		}
+	}
+
+	// Let Close know when the last connection has finished.
+	if h.drained != nil && h.activeConns == 0 {
+		close(h.drained)
+		h.drained = nil
+	}
+}
+
 // listen listens for incoming RPCs and spawns an appropriate handler for each.
 func (h *Host) listen() {
 	for {
@@ -21,17 +166,30 @@ func (h *Host) listen() {
 		if err != nil {
 			return
 		}
-		go h.handleConn(conn)
+		lc, ok := h.acceptConn(conn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+		go h.handleConn(lc)
 	}
 }
 
-func (h *Host) handleConn(conn net.Conn) {
+func (h *Host) handleConn(conn *limitedConn) {
+	defer h.releaseConn(conn)
 	defer conn.Close()
+	conn.extendDeadline()
 	var id rpcID
 	if err := encoding.ReadObject(conn, &id, 8); err != nil {
 		// log
 		return
 	}
+	// Every RPC begins with the host proving that it holds the key it
+	// announced. secretKey is only set in New, so no lock is needed.
+	if err := modules.ProveHostIdentity(conn, h.secretKey); err != nil {
+		return
+	}
+
 	switch id {
 	case idSettings:
 		h.rpcSettings(conn)
@@ -44,6 +202,27 @@ func (h *Host) handleConn(conn net.Conn) {
 	}
 }
 
+// rpcSettings sends the host's settings, along with its remaining storage.
 func (h *Host) rpcSettings(conn net.Conn) error {
-	return encoding.WriteObject(conn, h.Settings())
+	lockID := h.mu.RLock()
+	settings := h.HostSettings
+	settings.RemainingStorage = h.spaceRemaining
+	h.mu.RUnlock(lockID)
+	return encoding.WriteObject(conn, settings)
+}
+
+// SetNetworkLimits sets the connection limits of the host. Connections that
+// are already open keep their rate limits.
+func (h *Host) SetNetworkLimits(limits modules.HostNetworkLimits) {
+	lockID := h.mu.Lock()
+	defer h.mu.Unlock(lockID)
+	h.limits = limits
+	h.save()
+}
+
+// NetworkLimits returns the connection limits of the host.
+func (h *Host) NetworkLimits() modules.HostNetworkLimits {
+	lockID := h.mu.RLock()
+	defer h.mu.RUnlock(lockID)
+	return h.limits
 }
@@ -0,0 +1,108 @@
+package host
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestConnLimits checks that the host refuses connections beyond its global
+// and per-IP limits.
+func TestConnLimits(t *testing.T) {
+	ht := CreateHostTester("TestConnLimits", t)
+	h := ht.host
+	h.SetNetworkLimits(modules.HostNetworkLimits{
+		MaxConns:      3,
+		MaxConnsPerIP: 2,
+	})
+
+	// net.Pipe connections all share the same remote address.
+	c1, _ := net.Pipe()
+	c2, _ := net.Pipe()
+	c3, _ := net.Pipe()
+	if _, ok := h.acceptConn(c1); !ok {
+		t.Fatal("first connection was rejected")
+	}
+	if _, ok := h.acceptConn(c2); !ok {
+		t.Fatal("second connection was rejected")
+	}
+	if _, ok := h.acceptConn(c3); ok {
+		t.Fatal("connection beyond the per-IP limit was accepted")
+	}
+	h.releaseConn(c1)
+	if _, ok := h.acceptConn(c3); !ok {
+		t.Fatal("connection was rejected after another was released")
+	}
+	if h.Info().ActiveConns != 2 {
+		t.Error("wrong number of active connections:", h.Info().ActiveConns)
+	}
+
+	// Lift the per-IP limit so that the global limit is reached.
+	h.SetNetworkLimits(modules.HostNetworkLimits{MaxConns: 3})
+	c4, _ := net.Pipe()
+	c5, _ := net.Pipe()
+	if _, ok := h.acceptConn(c4); !ok {
+		t.Fatal("connection was rejected below the global limit")
+	}
+	if _, ok := h.acceptConn(c5); ok {
+		t.Fatal("connection beyond the global limit was accepted")
+	}
+}
+
+// TestRateLimiter checks that a rateLimiter paces transfers.
+func TestRateLimiter(t *testing.T) {
+	rl := &rateLimiter{rate: 1000}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		rl.wait(100)
+	}
+	// The first wait does not block; the next two wait 100ms each.
+	if time.Since(start) < 200*time.Millisecond {
+		t.Error("rate limiter did not pace transfers")
+	}
+
+	// An unlimited rateLimiter should never block.
+	rl = &rateLimiter{}
+	start = time.Now()
+	rl.wait(1e9)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("unlimited rate limiter blocked")
+	}
+}
+
+// TestHostIdentity checks that the host proves ownership of its public key at
+// the start of an RPC.
+func TestHostIdentity(t *testing.T) {
+	ht := CreateHostTester("TestHostIdentity", t)
+	pk := ht.host.Settings().PublicKey
+
+	rpcSettings := func(pk crypto.PublicKey) error {
+		conn, err := net.Dial("tcp", string(ht.host.Address()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := encoding.WriteObject(conn, idSettings); err != nil {
+			t.Fatal(err)
+		}
+		if err := modules.VerifyHostIdentity(conn, pk); err != nil {
+			return err
+		}
+		var settings modules.HostSettings
+		return encoding.ReadObject(conn, &settings, 1024)
+	}
+	if err := rpcSettings(pk); err != nil {
+		t.Fatal(err)
+	}
+	_, wrongKey, err := crypto.GenerateSignatureKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpcSettings(wrongKey); err != modules.ErrWrongHostKey {
+		t.Fatal("expected ErrWrongHostKey, got", err)
+	}
+}
@@ -1,8 +1,11 @@
 package host
 
 import (
+	"log"
+	"os"
 	"path/filepath"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/persist"
 	"github.com/NebulousLabs/Sia/types"
@@ -19,6 +22,9 @@ type savedHost struct {
 	Profit         types.Currency
 	HostSettings   modules.HostSettings
 	Obligations    []contractObligation
+	Contracts      []modules.HostContract
+	NetworkLimits  modules.HostNetworkLimits
+	SecretKey      crypto.SecretKey
 }
 
 func (h *Host) save() error {
@@ -28,16 +34,26 @@ func (h *Host) save() error {
 		Profit:         h.profit,
 		HostSettings:   h.HostSettings,
 		Obligations:    make([]contractObligation, 0, len(h.obligationsByID)),
+		Contracts:      make([]modules.HostContract, 0, len(h.contracts)),
+		NetworkLimits:  h.limits,
+		SecretKey:      h.secretKey,
 	}
 	for _, obligation := range h.obligationsByID {
 		sHost.Obligations = append(sHost.Obligations, obligation)
 	}
+	for _, hc := range h.contracts {
+		sHost.Contracts = append(sHost.Contracts, hc)
+	}
 
 	return persist.SaveFile(persistMetadata, sHost, filepath.Join(h.saveDir, "settings.json"))
 }
 
 func (h *Host) load() error {
-	var sHost savedHost
+	// Settings that are missing from older save files keep their defaults.
+	sHost := savedHost{
+		HostSettings:  h.HostSettings,
+		NetworkLimits: h.limits,
+	}
 	err := persist.LoadFile(persistMetadata, &sHost, filepath.Join(h.saveDir, "settings.json"))
 	if err != nil {
 		return err
@@ -47,6 +63,8 @@ func (h *Host) load() error {
 	h.fileCounter = sHost.FileCounter
 	h.HostSettings = sHost.HostSettings
 	h.profit = sHost.Profit
+	h.limits = sHost.NetworkLimits
+	h.secretKey = sHost.SecretKey
 	// recreate maps
 	for _, obligation := range sHost.Obligations {
 		height := obligation.FileContract.WindowStart + StorageProofReorgDepth
@@ -55,6 +73,18 @@ func (h *Host) load() error {
 		// update spaceRemaining
 		h.spaceRemaining -= int64(obligation.FileContract.FileSize)
 	}
+	for _, hc := range sHost.Contracts {
+		h.contracts[hc.ID] = hc
+	}
 
 	return nil
 }
+
+func makeLogger(saveDir string) (*log.Logger, error) {
+	// if the log file already exists, append to it
+	logFile, err := os.OpenFile(filepath.Join(saveDir, "host.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(logFile, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile), nil
+}
@@ -1,7 +1,12 @@
 package host
 
 import (
+	"path/filepath"
 	"testing"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
 )
 
 // TestSaveLoad tests that saving and loading a Host restores its data.
@@ -17,3 +22,24 @@ func TestSaveLoad(t *testing.T) {
 		ht.t.Fatal(err)
 	}
 }
+
+// TestLoadWithoutLimits checks that loading a save file written before network
+// limits were saved keeps the default limits.
+func TestLoadWithoutLimits(t *testing.T) {
+	ht := CreateHostTester("TestLoadWithoutLimits", t)
+	oldHost := struct {
+		HostSettings modules.HostSettings
+		SecretKey    crypto.SecretKey
+	}{ht.host.HostSettings, ht.host.secretKey}
+	err := persist.SaveFile(persistMetadata, oldHost, filepath.Join(ht.host.saveDir, "settings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ht.host.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ht.host.limits != defaultNetworkLimits {
+		t.Fatal("default network limits were not kept:", ht.host.limits)
+	}
+}
@@ -0,0 +1,112 @@
+package host
+
+// scrub.go contains the scrubber, which periodically verifies that the files
+// stored by the host still match the Merkle roots in their file contracts.
+// Without scrubbing, corruption would only be discovered when a storage proof
+// is attempted, at which point it is too late to do anything about it.
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+var (
+	errBadMerkleRoot = errors.New("file does not match the Merkle root of its contract")
+	errBadFileSize   = errors.New("file does not match the size of its contract")
+
+	// scrubSleep is the amount of time the scrubber waits between passes over
+	// the host's obligations.
+	scrubSleep = func() time.Duration {
+		switch build.Release {
+		case "dev":
+			return 10 * time.Minute
+		case "testing":
+			return 5 * time.Second
+		default:
+			return 6 * time.Hour
+		}
+	}()
+)
+
+// verifyObligation checks that the file of an obligation is intact by
+// recomputing its Merkle root.
+func (h *Host) verifyObligation(co contractObligation) error {
+	file, err := os.Open(filepath.Join(h.saveDir, co.Path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if uint64(stat.Size()) != co.FileContract.FileSize {
+		return errBadFileSize
+	}
+	root, err := crypto.ReaderMerkleRoot(io.LimitReader(file, int64(co.FileContract.FileSize)))
+	if err != nil {
+		return err
+	}
+	if root != co.FileContract.FileMerkleRoot {
+		return errBadMerkleRoot
+	}
+	return nil
+}
+
+// scrub makes a single pass over the host's obligations, recording which
+// obligations have files that fail verification. The host is not locked while
+// files are being read.
+func (h *Host) scrub() {
+	lockID := h.mu.RLock()
+	obligations := make([]contractObligation, 0, len(h.obligationsByID))
+	for _, co := range h.obligationsByID {
+		obligations = append(obligations, co)
+	}
+	h.mu.RUnlock(lockID)
+
+	failures := make(map[types.FileContractID]error)
+	for _, co := range obligations {
+		if err := h.verifyObligation(co); err != nil {
+			failures[co.ID] = err
+		}
+	}
+
+	lockID = h.mu.Lock()
+	defer h.mu.Unlock(lockID)
+	for _, co := range obligations {
+		// The obligation may have been removed while the scrub was running.
+		if _, exists := h.obligationsByID[co.ID]; !exists {
+			continue
+		}
+		err, failed := failures[co.ID]
+		_, wasFailing := h.failingObligations[co.ID]
+		if failed {
+			h.log.Printf("WARN: obligation %v (file %v) failed verification, proof window starts at %v: %v", co.ID, co.Path, co.FileContract.WindowStart, err)
+			h.failingObligations[co.ID] = struct{}{}
+		} else if wasFailing {
+			h.log.Printf("INFO: obligation %v passed verification again", co.ID)
+			delete(h.failingObligations, co.ID)
+		}
+	}
+}
+
+// threadedScrub periodically scrubs the host's obligations until the host is
+// closed.
+func (h *Host) threadedScrub() {
+	for {
+		select {
+		case <-h.closeChan:
+			return
+		case <-time.After(scrubSleep):
+		}
+		h.scrub()
+	}
+}
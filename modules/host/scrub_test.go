@@ -0,0 +1,67 @@
+package host
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestScrub creates an obligation, scrubs it, corrupts its file, and then
+// checks that the next scrub reports the obligation as failing.
+func TestScrub(t *testing.T) {
+	ht := CreateHostTester("TestScrub", t)
+	h := ht.host
+
+	// Create an obligation with a valid file.
+	data := bytes.Repeat([]byte{1, 2, 3}, 1000)
+	root, err := crypto.ReaderMerkleRoot(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "scrubtest"
+	err = ioutil.WriteFile(filepath.Join(h.saveDir, path), data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	co := contractObligation{
+		ID: types.FileContractID{1},
+		FileContract: types.FileContract{
+			FileSize:       uint64(len(data)),
+			FileMerkleRoot: root,
+		},
+		Path: path,
+	}
+	lockID := h.mu.Lock()
+	h.obligationsByID[co.ID] = co
+	h.mu.Unlock(lockID)
+
+	h.scrub()
+	if len(h.Info().FailingObligations) != 0 {
+		t.Fatal("intact obligation reported as failing")
+	}
+
+	// Corrupt the file.
+	data[0]++
+	err = ioutil.WriteFile(filepath.Join(h.saveDir, path), data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.scrub()
+	failing := h.Info().FailingObligations
+	if len(failing) != 1 || failing[0] != co.ID {
+		t.Fatal("corrupted obligation not reported as failing")
+	}
+
+	// Truncate the file.
+	err = ioutil.WriteFile(filepath.Join(h.saveDir, path), data[:10], 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.verifyObligation(co) != errBadFileSize {
+		t.Error("expected errBadFileSize")
+	}
+}
@@ -23,10 +23,10 @@ func (h *Host) threadedDeleteObligation(obligation contractObligation) {
 	}
 	h.deallocate(uint64(stat.Size()), obligation.Path)
 	delete(h.obligationsByID, obligation.ID)
+	delete(h.failingObligations, obligation.ID)
 
-	// Storage proof was successful, so increment profit tracking
-	h.profit = h.profit.Add(obligation.FileContract.Payout)
-
+	// Profit is not tracked here; the ledger is updated once the storage
+	// proof appears in the blockchain.
 	_ = h.save() // TODO: Some way to communicate that the save failed.
 }
 
@@ -85,6 +85,7 @@ func (h *Host) ReceiveConsensusSetUpdate(cc modules.ConsensusChange) {
 	lockID := h.mu.Lock()
 	defer h.mu.Unlock(lockID)
 
+	h.updateContracts(cc)
 	h.blockHeight -= types.BlockHeight(len(cc.RevertedBlocks))
 
 	// Check the applied blocks and see if any of the contracts we have are
@@ -1,12 +1,30 @@
 package modules
 
 import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/types"
 )
 
 const (
 	// Denotes a host announcement in the Arbitrary Data section.
 	PrefixHostAnnouncement = "HostAnnouncement"
+
+	HostDBDir = "hostdb"
+
+	// Prefixes the challenge that a host signs to prove its identity, so
+	// that the signature cannot be reused for anything else.
+	prefixHostChallenge = "HostChallenge"
+)
+
+var (
+	ErrUnsignedAnnouncement = errors.New("host announcement does not have a valid signature")
+	ErrWrongHostKey         = errors.New("host could not prove ownership of its public key")
 )
 
 // HostAnnouncements are stored in the Arbitrary Data section of transactions
@@ -16,8 +34,31 @@ const (
 // are paired with a volume of 'frozen' coins. The FreezeIndex indicates which
 // output in the transaction contains the frozen coins, and the
 // SpendConditions indicate the number of blocks the coins are frozen for.
+//
+// The announcement is signed by the persistent public key of the host, which
+// renters use to authenticate the host whenever they connect to it.
 type HostAnnouncement struct {
 	IPAddress NetAddress
+	PublicKey crypto.PublicKey
+	Signature crypto.Signature
+}
+
+// SigHash returns the hash that is signed by the host when making the
+// announcement.
+func (ha HostAnnouncement) SigHash() crypto.Hash {
+	return crypto.HashAll(PrefixHostAnnouncement, ha.IPAddress, ha.PublicKey)
+}
+
+// Verify checks that the announcement was signed by its public key. An empty
+// public key is never valid.
+func (ha HostAnnouncement) Verify() error {
+	if ha.PublicKey == (crypto.PublicKey{}) {
+		return ErrUnsignedAnnouncement
+	}
+	if crypto.VerifyHash(ha.SigHash(), ha.PublicKey, ha.Signature) != nil {
+		return ErrUnsignedAnnouncement
+	}
+	return nil
 }
 
 // HostSettings are the parameters advertised by the host. These are the
@@ -25,6 +66,7 @@ type HostAnnouncement struct {
 // database.
 type HostSettings struct {
 	IPAddress    NetAddress
+	PublicKey    crypto.PublicKey
 	TotalStorage int64 // Can go negative.
 	MinFilesize  uint64
 	MaxFilesize  uint64
@@ -34,18 +76,233 @@ type HostSettings struct {
 	Price        types.Currency
 	Collateral   types.Currency
 	UnlockHash   types.UnlockHash
+
+	// RemainingStorage is the amount of storage that the host has not yet
+	// sold. It is filled in by the host when its settings are requested.
+	RemainingStorage int64
+
+	// AcceptingContracts is false while the host is not forming new
+	// contracts, e.g. during maintenance. Existing contracts are still
+	// served.
+	AcceptingContracts bool
+}
+
+// A HostScoreBreakdown shows how a host's weight was calculated. The weight is
+// the PriceWeight multiplied by each of the factors, which are between 0 and
+// 1.
+type HostScoreBreakdown struct {
+	Weight      types.Currency
+	PriceWeight types.Currency
+
+	CollateralFactor  float64
+	StorageFactor     float64
+	UptimeFactor      float64
+	LatencyFactor     float64
+	AgeFactor         float64
+	PerformanceFactor float64
+}
+
+const (
+	// HostInteractionUpload and HostInteractionDownload are the kinds of
+	// interaction that the renter reports to the hostdb.
+	HostInteractionUpload   = "upload"
+	HostInteractionDownload = "download"
+
+	// These are the reasons that an interaction with a host can fail.
+	HostFailureDial     = "dial"     // the host could not be reached
+	HostFailureIdentity = "identity" // the host could not prove its identity
+	HostFailureRejected = "rejected" // the host refused the request
+	HostFailureProtocol = "protocol" // the host broke off or misbehaved
+	HostFailureCorrupt  = "corrupt"  // the host returned bad data
+)
+
+// A HostInteraction is the outcome of an upload to or download from a host, as
+// reported by the renter. Bytes and Duration are only set for successful
+// interactions, and give the throughput of the transfer.
+type HostInteraction struct {
+	Timestamp     time.Time
+	Type          string
+	Success       bool
+	FailureReason string
+	Bytes         uint64
+	Duration      time.Duration
+}
+
+// HostDBScanSettings control how the hostdb scans hosts. A round of scanning
+// starts after a random interval between MinScanSleep and MaxScanSleep, and
+// ScanThreads hosts are scanned at once.
+type HostDBScanSettings struct {
+	MinScanSleep time.Duration
+	MaxScanSleep time.Duration
+	ScanThreads  int
+}
+
+// A HostDBScanStatus reports the scan settings of the hostdb, along with the
+// number of hosts that are waiting to be scanned and being scanned.
+type HostDBScanStatus struct {
+	HostDBScanSettings
+	QueueDepth  int
+	ActiveScans int
+}
+
+// A HostScan is the result of a single attempt by the hostdb to fetch the
+// settings of a host.
+type HostScan struct {
+	Timestamp       time.Time
+	Success         bool
+	Latency         time.Duration // Zero if the scan failed.
+	SettingsChanged bool
+}
+
+// HostStats summarize the recent scans of a host. Uptime is the fraction of
+// scans that succeeded, and the latency percentiles only consider successful
+// scans.
+type HostStats struct {
+	IPAddress  NetAddress
+	Uptime     float64
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	Scans      []HostScan
+}
+
+// A HostDBEntry is everything the hostdb knows about a host. Active hosts are
+// the ones that can be selected by RandomHosts, unless they are Filtered by the
+// allow and deny lists.
+type HostDBEntry struct {
+	HostSettings
+	Active         bool
+	Filtered       bool
+	Weight         types.Currency
+	Reliability    types.Currency
+	AnnounceHeight types.BlockHeight
+	Score          HostScoreBreakdown
+	Stats          HostStats
+	Interactions   []HostInteraction
+}
+
+// A HostFilterList matches hosts by their address, by their public key, or by
+// the IP subnet (in CIDR notation, e.g. "10.0.0.0/8") that their address falls
+// in. Hosts whose address is a hostname rather than an IP never match a
+// subnet.
+type HostFilterList struct {
+	Addresses  []NetAddress
+	PublicKeys []crypto.PublicKey
+	Subnets    []string
+}
+
+// A HostFilter restricts which hosts the hostdb will offer to the renter. If
+// the Allow list is not empty, only hosts that match it are offered. Hosts that
+// match the Deny list are never offered.
+type HostFilter struct {
+	Allow HostFilterList
+	Deny  HostFilterList
+}
+
+// HostSelectionConstraints restrict which combinations of hosts RandomHosts
+// may return, so that the pieces of a file are spread across hosts that are
+// unlikely to be run by the same operator. Hosts are only grouped by IP prefix;
+// the hostdb has no routing data with which to group them by autonomous
+// system. The zero value imposes no constraints. Hosts whose address is a
+// hostname rather than an IP are not constrained.
+type HostSelectionConstraints struct {
+	// IPv4SubnetBits and IPv6SubnetBits are prefix lengths. At most one host
+	// is returned from each subnet of that size. 0 disables the constraint.
+	IPv4SubnetBits int
+	IPv6SubnetBits int
+
+	// InUse lists hosts that were selected previously, e.g. the hosts
+	// holding the other pieces of a file. Their subnets count as taken.
+	InUse []NetAddress
+}
+
+// ProveHostIdentity is called by a host after reading the ID of an RPC. It
+// reads a random challenge from the renter and responds with a signature of
+// the challenge.
+func ProveHostIdentity(conn io.ReadWriter, sk crypto.SecretKey) error {
+	var challenge crypto.Hash
+	if err := encoding.ReadObject(conn, &challenge, crypto.HashSize); err != nil {
+		return err
+	}
+	sig, err := crypto.SignHash(crypto.HashAll(prefixHostChallenge, challenge), sk)
+	if err != nil {
+		return err
+	}
+	return encoding.WriteObject(conn, sig)
+}
+
+// VerifyHostIdentity is called by a renter after writing the ID of an RPC. It
+// sends a random challenge to the host and checks that the response is signed
+// by the public key that the host announced.
+func VerifyHostIdentity(conn io.ReadWriter, pk crypto.PublicKey) error {
+	_, err := IdentifyHost(conn, []crypto.PublicKey{pk})
+	return err
+}
+
+// IdentifyHost is like VerifyHostIdentity, but accepts a response signed by
+// any of the keys, and returns the key that signed it. The keys are tried in
+// order.
+func IdentifyHost(conn io.ReadWriter, keys []crypto.PublicKey) (crypto.PublicKey, error) {
+	var challenge crypto.Hash
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return crypto.PublicKey{}, err
+	}
+	if err := encoding.WriteObject(conn, challenge); err != nil {
+		return crypto.PublicKey{}, err
+	}
+	var sig crypto.Signature
+	if err := encoding.ReadObject(conn, &sig, crypto.SignatureSize); err != nil {
+		return crypto.PublicKey{}, err
+	}
+	hash := crypto.HashAll(prefixHostChallenge, challenge)
+	for _, pk := range keys {
+		if crypto.VerifyHash(hash, pk, sig) == nil {
+			return pk, nil
+		}
+	}
+	return crypto.PublicKey{}, ErrWrongHostKey
 }
 
 // A HostDB is a database of hosts that the renter can use for figuring out who
 // to upload to, and download from.
 type HostDB interface {
 	// ActiveHosts returns the list of hosts that are actively being selected
-	// from.
+	// from. Hosts excluded by the filter are not returned.
 	ActiveHosts() []HostSettings
 
 	// AllHosts returns the full list of hosts known to the hostdb.
 	AllHosts() []HostSettings
 
+	// Close saves the hostdb.
+	Close() error
+
+	// Host returns the full entry of a host.
+	Host(NetAddress) (HostDBEntry, error)
+
+	// HostEntries returns the full entries of the active hosts, or of every
+	// known host if the argument is false.
+	HostEntries(bool) []HostDBEntry
+
+	// HostStats returns the uptime and latency of every host known to the
+	// hostdb.
+	HostStats() []HostStats
+
+	// Filter returns the allow and deny lists of the hostdb.
+	Filter() HostFilter
+
+	// SetFilter replaces the allow and deny lists of the hostdb. An error is
+	// returned if a subnet is not valid.
+	SetFilter(HostFilter) error
+
+	// AddFilterEntries adds entries to the allow list of the hostdb, or to
+	// the deny list if the first argument is true. An error is returned if a
+	// subnet is not valid.
+	AddFilterEntries(bool, HostFilterList) error
+
+	// RemoveFilterEntries removes entries from the allow list of the hostdb,
+	// or from the deny list if the first argument is true. An error is
+	// returned if an entry is not in the list.
+	RemoveFilterEntries(bool, HostFilterList) error
+
 	// HostDBNotify will push a struct down the returned channel every time the
 	// hostdb receives an update from the consensus set.
 	HostDBNotify() <-chan struct{}
@@ -53,11 +310,32 @@ type HostDB interface {
 	// InsertHost adds a host to the database.
 	InsertHost(HostSettings) error
 
+	// RecordInteraction stores the outcome of an interaction between the
+	// renter and a host, which is used to favor or penalize the host.
+	RecordInteraction(NetAddress, HostInteraction)
+
 	// RandomHosts will pull up to 'num' random hosts from the hostdb. There
 	// will be no repeats, but the length of the slice returned may be less
 	// than 'num', and may even be 0. The hosts returned first have the higher
-	// priority.
-	RandomHosts(num int) []HostSettings
+	// priority. No two hosts returned will violate the constraints.
+	RandomHosts(num int, constraints HostSelectionConstraints) []HostSettings
+
+	// ScanAll queues every known host to be scanned immediately.
+	ScanAll()
+
+	// ScanHost queues a host to be scanned immediately.
+	ScanHost(NetAddress) error
+
+	// ScanStatus returns the scan settings and the depth of the scan queue.
+	ScanStatus() HostDBScanStatus
+
+	// SetScanSettings changes how often and how many hosts are scanned at
+	// once. The new settings take effect immediately.
+	SetScanSettings(HostDBScanSettings) error
+
+	// ScoreBreakdown returns the weight of a host along with the
+	// contribution of each factor to the weight.
+	ScoreBreakdown(NetAddress) (HostScoreBreakdown, error)
 
 	// Remove deletes the host with the input address from the database.
 	RemoveHost(NetAddress) error
@@ -0,0 +1,67 @@
+package hostdb
+
+// diversity.go enforces the selection constraints of RandomHosts. Each host is
+// assigned a group key for its subnet. Two hosts sharing a key may not both be
+// selected.
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// groupKeys returns the groups that a host belongs to under the given
+// constraints. Hosts without an IP address belong to no groups.
+func groupKeys(addr modules.NetAddress, c modules.HostSelectionConstraints) (keys []string) {
+	ip := net.ParseIP(addr.Host())
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil && c.IPv4SubnetBits > 0 {
+		subnet := ip4.Mask(net.CIDRMask(c.IPv4SubnetBits, 32))
+		keys = append(keys, fmt.Sprintf("%v/%v", subnet, c.IPv4SubnetBits))
+	} else if ip.To4() == nil && c.IPv6SubnetBits > 0 {
+		subnet := ip.Mask(net.CIDRMask(c.IPv6SubnetBits, 128))
+		keys = append(keys, fmt.Sprintf("%v/%v", subnet, c.IPv6SubnetBits))
+	}
+	return keys
+}
+
+// A selectionGroups tracks the groups that have already been used by a
+// selection.
+type selectionGroups struct {
+	constraints modules.HostSelectionConstraints
+	taken       map[string]struct{}
+}
+
+// newSelectionGroups returns a selectionGroups in which the groups of the
+// hosts that are already in use are taken.
+func newSelectionGroups(c modules.HostSelectionConstraints) *selectionGroups {
+	sg := &selectionGroups{
+		constraints: c,
+		taken:       make(map[string]struct{}),
+	}
+	for _, addr := range c.InUse {
+		sg.take(addr)
+	}
+	return sg
+}
+
+// allowed reports whether a host shares no groups with the hosts selected so
+// far.
+func (sg *selectionGroups) allowed(addr modules.NetAddress) bool {
+	for _, key := range groupKeys(addr, sg.constraints) {
+		if _, exists := sg.taken[key]; exists {
+			return false
+		}
+	}
+	return true
+}
+
+// take marks the groups of a host as taken.
+func (sg *selectionGroups) take(addr modules.NetAddress) {
+	for _, key := range groupKeys(addr, sg.constraints) {
+		sg.taken[key] = struct{}{}
+	}
+}
@@ -0,0 +1,67 @@
+package hostdb
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestGroupKeys checks that hosts are grouped by subnet.
+func TestGroupKeys(t *testing.T) {
+	c := modules.HostSelectionConstraints{IPv4SubnetBits: 24, IPv6SubnetBits: 48}
+	if len(groupKeys("foo.com:9982", c)) != 0 {
+		t.Error("hostnames should not be constrained")
+	}
+	if len(groupKeys("1.2.3.4:9982", modules.HostSelectionConstraints{})) != 0 {
+		t.Error("the zero constraints should not group hosts")
+	}
+	a, b := groupKeys("1.2.3.4:9982", c), groupKeys("1.2.3.200:9982", c)
+	if len(a) != 1 || len(b) != 1 || a[0] != b[0] {
+		t.Error("hosts in the same /24 should share a group:", a, b)
+	}
+	if groupKeys("1.2.4.4:9982", c)[0] == a[0] {
+		t.Error("hosts in different /24s should not share a group")
+	}
+	a, b = groupKeys("[2001:db8:1::1]:9982", c), groupKeys("[2001:db8:1:2::1]:9982", c)
+	if len(a) != 1 || len(b) != 1 || a[0] != b[0] {
+		t.Error("hosts in the same /48 should share a group:", a, b)
+	}
+}
+
+// TestRandomHostsDiversity checks that RandomHosts returns at most one host
+// per subnet when asked to.
+func TestRandomHostsDiversity(t *testing.T) {
+	hdbt := newHDBTester("TestRandomHostsDiversity", t)
+
+	// Two hosts in 10.0.0.0/24, two in 10.0.1.0/24 and one hostname.
+	addrs := []modules.NetAddress{"10.0.0.1:1", "10.0.0.2:1", "10.0.1.1:1", "10.0.1.2:1", "foo.com:1"}
+	for _, addr := range addrs {
+		hdbt.hostdb.insertNode(&hostEntry{
+			HostSettings: modules.HostSettings{IPAddress: addr},
+			weight:       types.NewCurrency64(1),
+		})
+	}
+
+	c := modules.HostSelectionConstraints{IPv4SubnetBits: 24}
+	for i := 0; i < 20; i++ {
+		hosts := hdbt.hostdb.RandomHosts(len(addrs), c)
+		if len(hosts) != 3 {
+			t.Fatal("expected one host per subnet plus the hostname, got", len(hosts))
+		}
+		if len(hdbt.hostdb.activeHosts) != len(addrs) {
+			t.Fatal("constrained hosts were not returned to the tree")
+		}
+	}
+	if len(hdbt.hostdb.RandomHosts(len(addrs), modules.HostSelectionConstraints{})) != len(addrs) {
+		t.Error("unconstrained selection should return every host")
+	}
+
+	// Hosts in use take up their subnets.
+	c.InUse = []modules.NetAddress{"10.0.0.9:1"}
+	for _, host := range hdbt.hostdb.RandomHosts(len(addrs), c) {
+		if host.IPAddress == "10.0.0.1:1" || host.IPAddress == "10.0.0.2:1" {
+			t.Error("selected a host in the subnet of a host in use")
+		}
+	}
+}
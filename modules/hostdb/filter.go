@@ -0,0 +1,219 @@
+package hostdb
+
+// filter.go applies the allow and deny lists of the hostdb. Filtered hosts are
+// still scanned and kept in the database, so that they can be offered again
+// as soon as the filter is changed.
+
+import (
+	"errors"
+	"net"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+var (
+	errNotInFilter = errors.New("entry is not in the filter list")
+)
+
+// matches reports whether a host matches any entry of a filter list.
+func matches(list modules.HostFilterList, settings modules.HostSettings) bool {
+	for _, addr := range list.Addresses {
+		if addr == settings.IPAddress {
+			return true
+		}
+	}
+	for _, pk := range list.PublicKeys {
+		if pk == settings.PublicKey {
+			return true
+		}
+	}
+	ip := net.ParseIP(settings.IPAddress.Host())
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range list.Subnets {
+		_, ipnet, err := net.ParseCIDR(subnet)
+		if err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty reports whether a filter list has no entries.
+func isEmpty(list modules.HostFilterList) bool {
+	return len(list.Addresses) == 0 && len(list.PublicKeys) == 0 && len(list.Subnets) == 0
+}
+
+// allowed reports whether the filter of the hostdb allows a host to be
+// offered to the renter.
+func (hdb *HostDB) allowed(entry *hostEntry) bool {
+	if matches(hdb.filter.Deny, entry.HostSettings) {
+		return false
+	}
+	return isEmpty(hdb.filter.Allow) || matches(hdb.filter.Allow, entry.HostSettings)
+}
+
+// copyFilterList returns a copy of a filter list that does not share memory
+// with the original.
+func copyFilterList(list modules.HostFilterList) modules.HostFilterList {
+	return modules.HostFilterList{
+		Addresses:  append([]modules.NetAddress(nil), list.Addresses...),
+		PublicKeys: append([]crypto.PublicKey(nil), list.PublicKeys...),
+		Subnets:    append([]string(nil), list.Subnets...),
+	}
+}
+
+// checkSubnets returns an error if any subnet of a filter list is not valid.
+func checkSubnets(list modules.HostFilterList) error {
+	for _, subnet := range list.Subnets {
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterList returns the deny list of the hostdb if deny is true, and the
+// allow list otherwise.
+func (hdb *HostDB) filterList(deny bool) *modules.HostFilterList {
+	if deny {
+		return &hdb.filter.Deny
+	}
+	return &hdb.filter.Allow
+}
+
+// Filter returns a copy of the allow and deny lists of the hostdb.
+func (hdb *HostDB) Filter() modules.HostFilter {
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+	return modules.HostFilter{
+		Allow: copyFilterList(hdb.filter.Allow),
+		Deny:  copyFilterList(hdb.filter.Deny),
+	}
+}
+
+// SetFilter replaces the allow and deny lists of the hostdb.
+func (hdb *HostDB) SetFilter(filter modules.HostFilter) error {
+	if err := checkSubnets(filter.Allow); err != nil {
+		return err
+	}
+	if err := checkSubnets(filter.Deny); err != nil {
+		return err
+	}
+
+	id := hdb.mu.Lock()
+	defer hdb.mu.Unlock(id)
+	hdb.filter = modules.HostFilter{
+		Allow: copyFilterList(filter.Allow),
+		Deny:  copyFilterList(filter.Deny),
+	}
+	hdb.notifySubscribers()
+	return hdb.save()
+}
+
+// AddFilterEntries adds entries to the allow list of the hostdb, or to the
+// deny list if deny is true. Entries that are already in the list are ignored.
+func (hdb *HostDB) AddFilterEntries(deny bool, entries modules.HostFilterList) error {
+	if err := checkSubnets(entries); err != nil {
+		return err
+	}
+
+	id := hdb.mu.Lock()
+	defer hdb.mu.Unlock(id)
+	list := hdb.filterList(deny)
+	for _, addr := range entries.Addresses {
+		if !containsAddress(list.Addresses, addr) {
+			list.Addresses = append(list.Addresses, addr)
+		}
+	}
+	for _, pk := range entries.PublicKeys {
+		if !containsKey(list.PublicKeys, pk) {
+			list.PublicKeys = append(list.PublicKeys, pk)
+		}
+	}
+	for _, subnet := range entries.Subnets {
+		if !containsSubnet(list.Subnets, subnet) {
+			list.Subnets = append(list.Subnets, subnet)
+		}
+	}
+	hdb.notifySubscribers()
+	return hdb.save()
+}
+
+// RemoveFilterEntries removes entries from the allow list of the hostdb, or
+// from the deny list if deny is true. If an entry is not in the list, an error
+// is returned and the list is not changed.
+func (hdb *HostDB) RemoveFilterEntries(deny bool, entries modules.HostFilterList) error {
+	id := hdb.mu.Lock()
+	defer hdb.mu.Unlock(id)
+	list := hdb.filterList(deny)
+	for _, addr := range entries.Addresses {
+		if !containsAddress(list.Addresses, addr) {
+			return errNotInFilter
+		}
+	}
+	for _, pk := range entries.PublicKeys {
+		if !containsKey(list.PublicKeys, pk) {
+			return errNotInFilter
+		}
+	}
+	for _, subnet := range entries.Subnets {
+		if !containsSubnet(list.Subnets, subnet) {
+			return errNotInFilter
+		}
+	}
+
+	// The new lists are built in fresh slices, so that copies handed out
+	// earlier are never modified.
+	var kept modules.HostFilterList
+	for _, addr := range list.Addresses {
+		if !containsAddress(entries.Addresses, addr) {
+			kept.Addresses = append(kept.Addresses, addr)
+		}
+	}
+	for _, pk := range list.PublicKeys {
+		if !containsKey(entries.PublicKeys, pk) {
+			kept.PublicKeys = append(kept.PublicKeys, pk)
+		}
+	}
+	for _, subnet := range list.Subnets {
+		if !containsSubnet(entries.Subnets, subnet) {
+			kept.Subnets = append(kept.Subnets, subnet)
+		}
+	}
+	*list = kept
+	hdb.notifySubscribers()
+	return hdb.save()
+}
+
+// containsAddress reports whether addrs contains addr.
+func containsAddress(addrs []modules.NetAddress, addr modules.NetAddress) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// containsKey reports whether keys contains pk.
+func containsKey(keys []crypto.PublicKey, pk crypto.PublicKey) bool {
+	for _, k := range keys {
+		if k == pk {
+			return true
+		}
+	}
+	return false
+}
+
+// containsSubnet reports whether subnets contains subnet.
+func containsSubnet(subnets []string, subnet string) bool {
+	for _, s := range subnets {
+		if s == subnet {
+			return true
+		}
+	}
+	return false
+}
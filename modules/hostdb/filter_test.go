@@ -0,0 +1,131 @@
+package hostdb
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestFilterMatches probes the matches function.
+func TestFilterMatches(t *testing.T) {
+	settings := modules.HostSettings{
+		IPAddress: "10.1.2.3:9982",
+		PublicKey: crypto.PublicKey{1},
+	}
+	tests := []struct {
+		list    modules.HostFilterList
+		matches bool
+	}{
+		{modules.HostFilterList{}, false},
+		{modules.HostFilterList{Addresses: []modules.NetAddress{"10.1.2.3:9982"}}, true},
+		{modules.HostFilterList{Addresses: []modules.NetAddress{"10.1.2.3:9983"}}, false},
+		{modules.HostFilterList{PublicKeys: []crypto.PublicKey{{1}}}, true},
+		{modules.HostFilterList{PublicKeys: []crypto.PublicKey{{2}}}, false},
+		{modules.HostFilterList{Subnets: []string{"10.0.0.0/8"}}, true},
+		{modules.HostFilterList{Subnets: []string{"10.1.3.0/24"}}, false},
+	}
+	for i, test := range tests {
+		if matches(test.list, settings) != test.matches {
+			t.Errorf("test %v: expected %v", i, test.matches)
+		}
+	}
+
+	// Hostnames never match a subnet.
+	settings.IPAddress = "example.com:9982"
+	if matches(modules.HostFilterList{Subnets: []string{"0.0.0.0/0"}}, settings) {
+		t.Error("hostname matched a subnet")
+	}
+}
+
+// TestFilterSelection checks that filtered hosts are not returned by
+// ActiveHosts or RandomHosts.
+func TestFilterSelection(t *testing.T) {
+	hdbt := newHDBTester("TestFilterSelection", t)
+	hdb := hdbt.hostdb
+
+	// Insert some active hosts directly into the tree.
+	id := hdb.mu.Lock()
+	for _, addr := range []modules.NetAddress{"10.0.0.1:1", "10.0.0.2:1", "192.168.0.1:1"} {
+		entry := &hostEntry{
+			HostSettings: modules.HostSettings{IPAddress: addr},
+			weight:       types.NewCurrency64(1),
+		}
+		hdb.allHosts[addr] = entry
+		hdb.insertNode(entry)
+	}
+	hdb.mu.Unlock(id)
+
+	err := hdb.SetFilter(modules.HostFilter{
+		Allow: modules.HostFilterList{Subnets: []string{"10.0.0.0/8"}},
+		Deny:  modules.HostFilterList{Addresses: []modules.NetAddress{"10.0.0.2:1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hosts := range [][]modules.HostSettings{hdb.ActiveHosts(), hdb.RandomHosts(3, modules.HostSelectionConstraints{})} {
+		if len(hosts) != 1 || hosts[0].IPAddress != "10.0.0.1:1" {
+			t.Error("filter was not applied:", hosts)
+		}
+	}
+
+	// Filtered hosts must be put back into the tree.
+	if len(hdb.activeHosts) != 3 {
+		t.Error("filtered hosts were removed from the set of active hosts")
+	}
+
+	// Invalid subnets are rejected.
+	err = hdb.SetFilter(modules.HostFilter{Deny: modules.HostFilterList{Subnets: []string{"bad"}}})
+	if err == nil {
+		t.Error("invalid subnet was accepted")
+	}
+	if len(hdb.Filter().Allow.Subnets) != 1 {
+		t.Error("filter was changed by an invalid update")
+	}
+}
+
+// TestFilterEntries checks that entries can be added to and removed from the
+// filter lists, and that the filter returned by Filter is a copy.
+func TestFilterEntries(t *testing.T) {
+	hdbt := newHDBTester("TestFilterEntries", t)
+	hdb := hdbt.hostdb
+
+	entries := modules.HostFilterList{Addresses: []modules.NetAddress{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.3:1"}}
+	if err := hdb.AddFilterEntries(true, entries); err != nil {
+		t.Fatal(err)
+	}
+	// Adding an entry twice has no effect.
+	if err := hdb.AddFilterEntries(true, modules.HostFilterList{Addresses: entries.Addresses[:1]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hdb.AddFilterEntries(false, modules.HostFilterList{Subnets: []string{"bad"}}); err == nil {
+		t.Error("invalid subnet was accepted")
+	}
+	filter := hdb.Filter()
+	if len(filter.Deny.Addresses) != 3 || !isEmpty(filter.Allow) {
+		t.Fatal("entries were not added correctly:", filter)
+	}
+
+	// Modifying the returned filter must not modify the hostdb.
+	filter.Deny.Addresses[0] = "10.0.0.9:1"
+	if hdb.Filter().Deny.Addresses[0] != "10.0.0.1:1" {
+		t.Fatal("Filter returned a filter that shares memory with the hostdb")
+	}
+
+	err := hdb.RemoveFilterEntries(true, modules.HostFilterList{Addresses: []modules.NetAddress{"10.0.0.1:1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = hdb.RemoveFilterEntries(true, modules.HostFilterList{Addresses: []modules.NetAddress{"10.0.0.2:1", "10.0.0.9:1"}})
+	if err != errNotInFilter {
+		t.Fatal("expected errNotInFilter, got", err)
+	}
+	if deny := hdb.Filter().Deny.Addresses; len(deny) != 2 || deny[0] != "10.0.0.2:1" || deny[1] != "10.0.0.3:1" {
+		t.Fatal("entries were not removed correctly:", deny)
+	}
+	// The copy handed out earlier must be unaffected by the removal.
+	if filter.Deny.Addresses[1] != "10.0.0.2:1" {
+		t.Fatal("removal modified a copy of the filter")
+	}
+}
@@ -0,0 +1,99 @@
+package hostdb
+
+// history.go keeps a rolling history of the scans of each host. The history is
+// used to calculate the uptime and latency of a host, which feed into the
+// weight of the host.
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// maxScanHistory is the number of scans that are remembered for each
+	// host. Older scans are discarded.
+	maxScanHistory = 100
+)
+
+// settingsChanged reports whether a host has changed its settings. The
+// remaining storage of a host changes with every contract, so it is ignored.
+func settingsChanged(prev, cur modules.HostSettings) bool {
+	prev.RemainingStorage = 0
+	cur.RemainingStorage = 0
+	return !bytes.Equal(encoding.Marshal(prev), encoding.Marshal(cur))
+}
+
+// recordScan adds the result of a scan to the history of a host, discarding
+// the oldest scan if the history is full.
+func (entry *hostEntry) recordScan(scan modules.HostScan) {
+	entry.scanHistory = append(entry.scanHistory, scan)
+	if len(entry.scanHistory) > maxScanHistory {
+		entry.scanHistory = entry.scanHistory[len(entry.scanHistory)-maxScanHistory:]
+	}
+}
+
+// uptime returns the fraction of scans in the history of a host that were
+// successful. false is returned if the host has never been scanned.
+func (entry *hostEntry) uptime() (float64, bool) {
+	if len(entry.scanHistory) == 0 {
+		return 0, false
+	}
+	successes := 0
+	for _, scan := range entry.scanHistory {
+		if scan.Success {
+			successes++
+		}
+	}
+	return float64(successes) / float64(len(entry.scanHistory)), true
+}
+
+// latencyPercentile returns the pth percentile of the latencies of the
+// successful scans in the history of a host. 0 is returned if no scans were
+// successful.
+func (entry *hostEntry) latencyPercentile(p int) time.Duration {
+	var latencies []time.Duration
+	for _, scan := range entry.scanHistory {
+		if scan.Success {
+			latencies = append(latencies, scan.Latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Sort(durations(latencies))
+	return latencies[(len(latencies)-1)*p/100]
+}
+
+// durations implements sort.Interface for a slice of time.Durations.
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// stats summarizes the scan history of a host.
+func (entry *hostEntry) stats() modules.HostStats {
+	uptime, _ := entry.uptime()
+	return modules.HostStats{
+		IPAddress:  entry.IPAddress,
+		Uptime:     uptime,
+		LatencyP50: entry.latencyPercentile(50),
+		LatencyP90: entry.latencyPercentile(90),
+		Scans:      append([]modules.HostScan(nil), entry.scanHistory...),
+	}
+}
+
+// HostStats returns the uptime and latency of every host known to the hostdb.
+func (hdb *HostDB) HostStats() (stats []modules.HostStats) {
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+
+	for _, entry := range hdb.allHosts {
+		stats = append(stats, entry.stats())
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package hostdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestScanHistory checks the uptime and latency calculated from the scan
+// history of a host.
+func TestScanHistory(t *testing.T) {
+	var entry hostEntry
+	if _, ok := entry.uptime(); ok {
+		t.Error("uptime reported for a host that was never scanned")
+	}
+
+	// Record 3 successful scans and 1 failed scan.
+	for _, latency := range []time.Duration{300, 100, 200} {
+		entry.recordScan(modules.HostScan{Success: true, Latency: latency})
+	}
+	entry.recordScan(modules.HostScan{Success: false})
+	if uptime, _ := entry.uptime(); uptime != 0.75 {
+		t.Error("wrong uptime:", uptime)
+	}
+	if p50 := entry.latencyPercentile(50); p50 != 200 {
+		t.Error("wrong median latency:", p50)
+	}
+	if p90 := entry.latencyPercentile(90); p90 != 200 {
+		t.Error("wrong 90th percentile latency:", p90)
+	}
+
+	// The history should not grow beyond maxScanHistory.
+	for i := 0; i < maxScanHistory; i++ {
+		entry.recordScan(modules.HostScan{Success: true})
+	}
+	if len(entry.scanHistory) != maxScanHistory {
+		t.Error("scan history grew too large:", len(entry.scanHistory))
+	}
+	if uptime, _ := entry.uptime(); uptime != 1 {
+		t.Error("old scans were not discarded")
+	}
+}
+
+// TestSettingsChanged probes the settingsChanged function.
+func TestSettingsChanged(t *testing.T) {
+	prev := modules.HostSettings{Price: types.NewCurrency64(1), RemainingStorage: 10}
+	cur := prev
+	cur.RemainingStorage = 5
+	if settingsChanged(prev, cur) {
+		t.Error("a change in remaining storage should not count as a settings change")
+	}
+	cur.Price = types.NewCurrency64(2)
+	if !settingsChanged(prev, cur) {
+		t.Error("a change in price was not detected")
+	}
+}
+
+// TestProbeHistory checks that probing a host adds to its scan history.
+func TestProbeHistory(t *testing.T) {
+	hdbt := newHDBTester("TestProbeHistory", t)
+	hdbt.hostdb.InsertHost(modules.HostSettings{
+		IPAddress: hdbt.host.Address(),
+		PublicKey: hdbt.host.Settings().PublicKey,
+	})
+	<-hdbt.hostdbUpdateChan
+
+	stats := hdbt.hostdb.HostStats()
+	if len(stats) != 1 {
+		t.Fatal("expected stats for 1 host, got", len(stats))
+	}
+	if len(stats[0].Scans) != 1 || !stats[0].Scans[0].Success || stats[0].Uptime != 1 {
+		t.Error("successful scan was not recorded:", stats[0])
+	}
+	if stats[0].LatencyP50 <= 0 {
+		t.Error("latency was not recorded")
+	}
+}
@@ -8,10 +8,12 @@ package hostdb
 
 import (
 	"errors"
+	"os"
 
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/modules/consensus"
 	"github.com/NebulousLabs/Sia/sync"
+	"github.com/NebulousLabs/Sia/types"
 )
 
 const (
@@ -21,8 +23,9 @@ const (
 
 	// scanPoolSize sets the buffer size of the channel that holds hosts which
 	// need to be scanned. A thread pool pulls from the scan pool to query
-	// hosts that are due for an update.
-	scanPoolSize = 1000
+	// hosts that are due for an update. The pool is large enough to hold a
+	// full round of scanning.
+	scanPoolSize = MaxActiveHosts + InactiveHostCheckupQuantity
 )
 
 var (
@@ -34,6 +37,13 @@ var (
 // host based on their hosting parameters, and then can select hosts at random
 // for uploading files.
 type HostDB struct {
+	// Implementation note: the counters are declared first to ensure that
+	// they are 64-bit aligned, which is necessary for atomic operations on ARM
+	// and x86-32. scanQueue is the number of hosts waiting to be scanned and
+	// activeScans is the number of hosts being scanned.
+	scanQueue   int64
+	activeScans int64
+
 	consensusSet *consensus.State
 	gateway      modules.Gateway
 
@@ -42,11 +52,20 @@ type HostDB struct {
 	// random. 'activeHosts' provides a lookup from hostname to the the
 	// corresponding node, as the hostTree is unsorted. A host is active if
 	// it is currently responding to queries about price and other
-	// settings.
+	// settings. emptyNodes are the nodes of hosts that have been removed,
+	// which are refilled before the tree is grown.
 	hostTree        *hostNode
 	activeHosts     map[modules.NetAddress]*hostNode
+	emptyNodes      []*hostNode
 	consensusHeight int
 
+	// savedHeight is the block height when the hostdb was last saved. The
+	// consensus set replays every block on startup, so savedHeight is used
+	// as the current height until the replay catches up to it. Otherwise
+	// hosts loaded from disk would be weighed as if they were just
+	// announced.
+	savedHeight types.BlockHeight
+
 	// allHosts is a simple list of all known hosts by their network address,
 	// including hosts that are currently offline.
 	allHosts map[modules.NetAddress]*hostEntry
@@ -56,14 +75,30 @@ type HostDB struct {
 	// scan.
 	scanPool chan *hostEntry
 
+	// scanSettings control the interval between rounds of scanning and the
+	// number of scanning threads. scanThreads is the number of threads
+	// currently running; threads are stopped by sending on stopScanThread.
+	// scanSettingsChanged wakes threadedScan when the interval changes.
+	scanSettings        modules.HostDBScanSettings
+	scanThreads         int
+	stopScanThread      chan struct{}
+	scanSettingsChanged chan struct{}
+
+	// filter restricts which hosts are offered by ActiveHosts and
+	// RandomHosts.
+	filter modules.HostFilter
+
 	subscribers []chan struct{}
 
+	persistDir string
+
 	mu *sync.RWMutex
 }
 
 // New returns a host database that will still crawling the hosts it finds on
-// the blockchain.
-func New(cs *consensus.State, g modules.Gateway) (hdb *HostDB, err error) {
+// the blockchain. Hosts that were found in previous sessions are loaded from
+// persistDir.
+func New(cs *consensus.State, g modules.Gateway, persistDir string) (hdb *HostDB, err error) {
 	// Check for nil dependencies.
 	if cs == nil {
 		err = ErrNilConsensusSet
@@ -83,16 +118,42 @@ func New(cs *consensus.State, g modules.Gateway) (hdb *HostDB, err error) {
 
 		allHosts: make(map[modules.NetAddress]*hostEntry),
 
-		scanPool: make(chan *hostEntry, scanPoolSize),
+		scanPool:            make(chan *hostEntry, scanPoolSize),
+		scanSettings:        defaultScanSettings,
+		stopScanThread:      make(chan struct{}),
+		scanSettingsChanged: make(chan struct{}, 1),
+
+		persistDir: persistDir,
 
 		mu: sync.New(modules.SafeMutexDelay, 1),
 	}
 
-	// Begin listening to consensus and looking for hosts.
-	for i := 0; i < scanningThreads; i++ {
-		go hdb.threadedProbeHosts()
+	// Load the hosts from previous sessions.
+	err = os.MkdirAll(persistDir, 0700)
+	if err != nil {
+		return
 	}
+	err = hdb.load()
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	err = nil
+
+	// Begin listening to consensus and looking for hosts.
+	id := hdb.mu.Lock()
+	hdb.setScanThreads(hdb.scanSettings.ScanThreads)
+	hdb.mu.Unlock(id)
 	go hdb.threadedScan()
 	cs.ConsensusSetSubscribe(hdb)
 	return
 }
+
+// blockHeight returns the height of the current block, or savedHeight if the
+// consensus set has not caught up to it yet. consensusHeight counts the
+// genesis block, so it is one larger than the height.
+func (hdb *HostDB) blockHeight() types.BlockHeight {
+	if hdb.consensusHeight <= int(hdb.savedHeight) {
+		return hdb.savedHeight
+	}
+	return types.BlockHeight(hdb.consensusHeight - 1)
+}
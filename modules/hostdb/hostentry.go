@@ -1,68 +1,119 @@
 package hostdb
 
 import (
-	"math/big"
-
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
 )
 
-var (
-	// Because most weights would otherwise be fractional, we set the base
-	// weight to 10^80 to give ourselves lots of precision when determing the
-	// weight of a host
-	baseWeight = types.NewCurrency(new(big.Int).Exp(big.NewInt(10), big.NewInt(120), nil))
-)
-
 // A hostEntry represents a host on the network.
 type hostEntry struct {
 	modules.HostSettings
 	weight      types.Currency
 	reliability types.Currency
+
+	// scanHistory contains the most recent scans of the host, oldest first.
+	scanHistory []modules.HostScan
+
+	// announceHeight is the height of the block containing the host's
+	// earliest announcement.
+	announceHeight types.BlockHeight
+
+	// announcements are the blocks in which the host has been announced.
+	announcements []announcement
+
+	// interactions are the most recent interactions of the renter with the
+	// host, oldest first.
+	interactions []modules.HostInteraction
+
+	// queued is set while the host is waiting in the scan pool, so that it
+	// is not queued more than once.
+	queued bool
+}
+
+// An announcement records a block containing a host announcement, so that the
+// announcement can be undone if the block is reverted. Hosts that are inserted
+// by hand get an announcement with an empty BlockID, which is never reverted.
+// PublicKey is the key that the announcement was signed with; announcements
+// saved before keys were recorded have an empty key.
+type announcement struct {
+	BlockID   types.BlockID
+	Height    types.BlockHeight
+	PublicKey crypto.PublicKey
 }
 
-// hostWeight returns the weight of a host according to the settings of the
-// host database. Currently, only the price is considered.
-func (hdb *HostDB) hostWeight(entry hostEntry) (weight types.Currency) {
-	// Prevent a divide by zero error by making sure the price is at least one.
-	price := entry.Price
-	if price.Cmp(types.NewCurrency64(0)) <= 0 {
-		price = types.NewCurrency64(1)
+// announced reports whether the host has been announced with a key.
+func (entry *hostEntry) announced(pk crypto.PublicKey) bool {
+	for _, a := range entry.announcements {
+		if a.PublicKey == pk {
+			return true
+		}
 	}
+	return false
+}
 
-	// Divide the base weight by the cube of the price.
-	return baseWeight.Div(price).Div(price).Div(price)
+// candidateKeys returns the keys that the host has been announced with, other
+// than its current key, newest first. Anyone can announce an address, so an
+// announced key only becomes the current key once the host proves that it
+// holds it during a scan.
+func (entry *hostEntry) candidateKeys() (keys []crypto.PublicKey) {
+	seen := map[crypto.PublicKey]bool{
+		entry.PublicKey:    true,
+		crypto.PublicKey{}: true,
+	}
+	for i := len(entry.announcements) - 1; i >= 0; i-- {
+		pk := entry.announcements[i].PublicKey
+		if !seen[pk] {
+			seen[pk] = true
+			keys = append(keys, pk)
+		}
+	}
+	return keys
 }
 
 // insert adds a host entry to the state. The host will be inserted into the
 // set of all hosts, and if it is online and responding to requests it will be
-// put into the list of active hosts.
-func (hdb *HostDB) insertHost(host modules.HostSettings) {
-	// Add the host to allHosts.
-	entry := &hostEntry{
-		HostSettings: host,
-		reliability:  DefaultReliability,
-	}
-	_, exists := hdb.allHosts[entry.IPAddress]
+// put into the list of active hosts. If the host is already known, the
+// announcement is recorded, and a key that the host has not been announced
+// with before is checked with a scan. The key of the host does not change
+// until a scan proves that the host holds the new key.
+// Announcements are saved to disk and replayed by the consensus set on every
+// startup, so an announcement that is already recorded is ignored.
+func (hdb *HostDB) insertHost(host modules.HostSettings, a announcement) {
+	a.PublicKey = host.PublicKey
+	entry, exists := hdb.allHosts[host.IPAddress]
 	if !exists {
+		// Add the host to allHosts.
+		entry = &hostEntry{
+			HostSettings: host,
+			reliability:  DefaultReliability,
+		}
 		hdb.allHosts[entry.IPAddress] = entry
 		hdb.scanHostEntry(entry)
 	}
+	for _, known := range entry.announcements {
+		if known.BlockID == a.BlockID && known.PublicKey == a.PublicKey {
+			return
+		}
+	}
+	newKey := exists && a.PublicKey != entry.PublicKey && !entry.announced(a.PublicKey)
+	entry.announcements = append(entry.announcements, a)
+	entry.earliestAnnouncement()
+	if newKey {
+		hdb.scanHostEntry(entry)
+	}
 }
 
 // Remove deletes an entry from the hostdb.
-func (hdb *HostDB) removeHost(addr modules.NetAddress) error {
+func (hdb *HostDB) removeHost(addr modules.NetAddress) {
 	delete(hdb.allHosts, addr)
 
 	// See if the node is in the set of active hosts.
 	node, exists := hdb.activeHosts[addr]
 	if exists {
-		delete(hdb.activeHosts, addr)
-		node.removeNode()
+		hdb.removeNode(node)
 		hdb.notifySubscribers()
 	}
-
-	return nil
 }
 
 // ActiveHosts returns the hosts that can be randomly selected out of the
@@ -72,7 +123,9 @@ func (hdb *HostDB) ActiveHosts() (activeHosts []modules.HostSettings) {
 	defer hdb.mu.RUnlock(id)
 
 	for _, node := range hdb.activeHosts {
-		activeHosts = append(activeHosts, node.hostEntry.HostSettings)
+		if hdb.allowed(node.hostEntry) {
+			activeHosts = append(activeHosts, node.hostEntry.HostSettings)
+		}
 	}
 	return
 }
@@ -89,17 +142,67 @@ func (hdb *HostDB) AllHosts() (allHosts []modules.HostSettings) {
 	return
 }
 
+// dbEntry returns the full entry of a host as seen by callers of the hostdb.
+func (hdb *HostDB) dbEntry(entry *hostEntry) modules.HostDBEntry {
+	_, active := hdb.activeHosts[entry.IPAddress]
+	return modules.HostDBEntry{
+		HostSettings:   entry.HostSettings,
+		Active:         active,
+		Filtered:       !hdb.allowed(entry),
+		Weight:         entry.weight,
+		Reliability:    entry.reliability,
+		AnnounceHeight: entry.announceHeight,
+		Score:          hdb.scoreBreakdown(*entry),
+		Stats:          entry.stats(),
+		Interactions:   append([]modules.HostInteraction(nil), entry.interactions...),
+	}
+}
+
+// Host returns the full entry of a host.
+func (hdb *HostDB) Host(addr modules.NetAddress) (modules.HostDBEntry, error) {
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+
+	entry, exists := hdb.allHosts[addr]
+	if !exists {
+		return modules.HostDBEntry{}, errUnknownHost
+	}
+	return hdb.dbEntry(entry), nil
+}
+
+// HostEntries returns the full entries of the active hosts, or of every known
+// host if active is false. Like ActiveHosts, active hosts excluded by the
+// filter are not returned.
+func (hdb *HostDB) HostEntries(active bool) (entries []modules.HostDBEntry) {
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+
+	if active {
+		for _, node := range hdb.activeHosts {
+			if hdb.allowed(node.hostEntry) {
+				entries = append(entries, hdb.dbEntry(node.hostEntry))
+			}
+		}
+		return
+	}
+	for _, entry := range hdb.allHosts {
+		entries = append(entries, hdb.dbEntry(entry))
+	}
+	return
+}
+
 // InsertHost inserts a host into the database.
 func (hdb *HostDB) InsertHost(host modules.HostSettings) error {
 	id := hdb.mu.Lock()
 	defer hdb.mu.Unlock(id)
-	hdb.insertHost(host)
-	return nil
+	hdb.insertHost(host, announcement{Height: hdb.blockHeight()})
+	return hdb.save()
 }
 
 // RemoveHost removes a host from the database.
 func (hdb *HostDB) RemoveHost(addr modules.NetAddress) error {
 	id := hdb.mu.Lock()
 	defer hdb.mu.Unlock(id)
-	return hdb.removeHost(addr)
+	hdb.removeHost(addr)
+	return hdb.save()
 }
@@ -63,7 +63,10 @@ func TestInsertHost(t *testing.T) {
 		t.Error("not expecting an active host")
 	}
 
-	hdbt.hostdb.InsertHost(modules.HostSettings{IPAddress: hdbt.host.Address()})
+	hdbt.hostdb.InsertHost(modules.HostSettings{
+		IPAddress: hdbt.host.Address(),
+		PublicKey: hdbt.host.Settings().PublicKey,
+	})
 	if len(hdbt.hostdb.allHosts) != 2 {
 		t.Error("host was not inserted")
 	}
@@ -72,3 +75,53 @@ func TestInsertHost(t *testing.T) {
 		t.Error("expecting an active host")
 	}
 }
+
+// TestHost checks that Host reports the full entry of a host.
+func TestHost(t *testing.T) {
+	hdbt := newHDBTester("TestHost", t)
+
+	entry := &hostEntry{
+		HostSettings:   modules.HostSettings{IPAddress: fakeAddr(1)},
+		weight:         types.NewCurrency64(5),
+		announceHeight: 3,
+	}
+	hdbt.hostdb.allHosts[entry.IPAddress] = entry
+	hdbt.hostdb.insertNode(entry)
+
+	he, err := hdbt.hostdb.Host(fakeAddr(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !he.Active || he.Filtered || he.AnnounceHeight != 3 || he.Weight.Cmp(types.NewCurrency64(5)) != 0 {
+		t.Error("wrong entry:", he)
+	}
+	if he.Stats.IPAddress != fakeAddr(1) {
+		t.Error("stats are missing from the entry")
+	}
+	if _, err := hdbt.hostdb.Host(fakeAddr(2)); err != errUnknownHost {
+		t.Error("expected errUnknownHost, got", err)
+	}
+}
+
+// TestHostEntries checks that HostEntries returns the entries of the active
+// hosts or of every host.
+func TestHostEntries(t *testing.T) {
+	hdbt := newHDBTester("TestHostEntries", t)
+
+	active := &hostEntry{
+		HostSettings: modules.HostSettings{IPAddress: fakeAddr(1)},
+		weight:       types.NewCurrency64(5),
+	}
+	hdbt.hostdb.allHosts[active.IPAddress] = active
+	hdbt.hostdb.insertNode(active)
+	inactive := &hostEntry{HostSettings: modules.HostSettings{IPAddress: fakeAddr(2)}}
+	hdbt.hostdb.allHosts[inactive.IPAddress] = inactive
+
+	entries := hdbt.hostdb.HostEntries(true)
+	if len(entries) != 1 || entries[0].IPAddress != fakeAddr(1) || !entries[0].Active {
+		t.Error("wrong active entries:", entries)
+	}
+	if entries := hdbt.hostdb.HostEntries(false); len(entries) != 2 {
+		t.Error("wrong number of entries:", len(entries))
+	}
+}
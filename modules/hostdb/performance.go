@@ -0,0 +1,95 @@
+package hostdb
+
+// performance.go keeps the outcomes of the renter's interactions with hosts.
+// Scans only show whether a host is online; interactions show whether the host
+// actually stores and returns files, and how quickly.
+
+import (
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// maxInteractions is the number of interactions kept for each host.
+	maxInteractions = 100
+
+	// interactionPrior is the number of successful interactions that every
+	// host is assumed to have had, so that a single failure does not ruin a
+	// host with no history.
+	interactionPrior = 3
+
+	// throughputTarget is the median throughput, in bytes per second, below
+	// which a host is penalized.
+	throughputTarget = 256 << 10 // 256 KiB/s
+
+	// minPerformanceFactor is the lowest performance factor that a host can
+	// have.
+	minPerformanceFactor = 0.01
+)
+
+// recordInteraction adds an interaction to the history of a host, dropping the
+// oldest interaction if the history is full.
+func (entry *hostEntry) recordInteraction(hi modules.HostInteraction) {
+	entry.interactions = append(entry.interactions, hi)
+	if len(entry.interactions) > maxInteractions {
+		entry.interactions = entry.interactions[len(entry.interactions)-maxInteractions:]
+	}
+}
+
+// medianThroughput returns the median throughput of the successful
+// interactions with a host, in bytes per second. false is returned if no
+// transfers have been reported.
+func (entry *hostEntry) medianThroughput() (float64, bool) {
+	var throughputs []float64
+	for _, hi := range entry.interactions {
+		if hi.Success && hi.Bytes > 0 && hi.Duration > 0 {
+			throughputs = append(throughputs, float64(hi.Bytes)/hi.Duration.Seconds())
+		}
+	}
+	if len(throughputs) == 0 {
+		return 0, false
+	}
+	sort.Float64s(throughputs)
+	return throughputs[len(throughputs)/2], true
+}
+
+// performanceFactor penalizes hosts that have failed interactions with the
+// renter, or that transfer files slowly. The success rate is squared so that
+// unreliable hosts are strongly avoided.
+func performanceFactor(entry hostEntry) float64 {
+	successes, total := interactionPrior, interactionPrior
+	for _, hi := range entry.interactions {
+		if hi.Success {
+			successes++
+		}
+		total++
+	}
+	rate := float64(successes) / float64(total)
+	factor := rate * rate
+	if throughput, ok := entry.medianThroughput(); ok && throughput < throughputTarget {
+		factor *= throughput / throughputTarget
+	}
+	return clampFactor(factor, minPerformanceFactor)
+}
+
+// RecordInteraction stores the outcome of an interaction between the renter
+// and a host, updates the weight of the host, and saves the hostdb.
+// Interactions with unknown hosts are ignored.
+func (hdb *HostDB) RecordInteraction(addr modules.NetAddress, hi modules.HostInteraction) {
+	id := hdb.mu.Lock()
+	defer hdb.mu.Unlock(id)
+
+	entry, exists := hdb.allHosts[addr]
+	if !exists {
+		return
+	}
+	if hi.Timestamp.IsZero() {
+		hi.Timestamp = time.Now()
+	}
+
+	entry.recordInteraction(hi)
+	hdb.setWeight(entry, hdb.hostWeight(*entry))
+	_ = hdb.save() // TODO: Some way to communicate that the save failed.
+}
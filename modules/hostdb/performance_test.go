@@ -0,0 +1,93 @@
+package hostdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestPerformanceFactor checks that failed and slow interactions lower the
+// performance factor of a host.
+func TestPerformanceFactor(t *testing.T) {
+	var entry hostEntry
+	if performanceFactor(entry) != 1 {
+		t.Error("a host with no interactions should not be penalized")
+	}
+
+	// A single failure is softened by the prior.
+	entry.recordInteraction(modules.HostInteraction{FailureReason: modules.HostFailureDial})
+	if f := performanceFactor(entry); f != 0.75*0.75 {
+		t.Error("wrong factor after one failure:", f)
+	}
+	for i := 0; i < 2*maxInteractions; i++ {
+		entry.recordInteraction(modules.HostInteraction{FailureReason: modules.HostFailureDial})
+	}
+	if len(entry.interactions) != maxInteractions {
+		t.Error("interaction history was not capped:", len(entry.interactions))
+	}
+	if performanceFactor(entry) != minPerformanceFactor {
+		t.Error("a host that always fails should have the minimum factor")
+	}
+
+	// Fast transfers are not penalized, slow transfers are.
+	fast := modules.HostInteraction{Success: true, Bytes: throughputTarget * 2, Duration: time.Second}
+	slow := modules.HostInteraction{Success: true, Bytes: throughputTarget / 2, Duration: time.Second}
+	entry = hostEntry{}
+	entry.recordInteraction(fast)
+	if performanceFactor(entry) != 1 {
+		t.Error("a fast host should not be penalized")
+	}
+	entry = hostEntry{}
+	entry.recordInteraction(slow)
+	if performanceFactor(entry) != 0.5 {
+		t.Error("a host at half the target throughput should have a factor of 0.5:", performanceFactor(entry))
+	}
+}
+
+// TestRecordInteraction checks that reported interactions change the weight of
+// an active host.
+func TestRecordInteraction(t *testing.T) {
+	hdbt := newHDBTester("TestRecordInteraction", t)
+	hdb := hdbt.hostdb
+
+	entry := &hostEntry{
+		HostSettings: modules.HostSettings{IPAddress: fakeAddr(1), Price: types.NewCurrency64(1)},
+		reliability:  MaxReliability,
+	}
+	entry.weight = hdb.hostWeight(*entry)
+	hdb.allHosts[entry.IPAddress] = entry
+	hdb.insertNode(entry)
+	initial := entry.weight
+
+	hdb.RecordInteraction(fakeAddr(1), modules.HostInteraction{FailureReason: modules.HostFailureCorrupt})
+	if entry.weight.Cmp(initial) >= 0 {
+		t.Error("a failed interaction did not lower the weight of the host")
+	}
+	if hdb.hostTree.weight.Cmp(entry.weight) != 0 {
+		t.Error("the weight of the tree was not updated")
+	}
+	he, err := hdb.Host(fakeAddr(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(he.Interactions) != 1 || he.Interactions[0].Timestamp.IsZero() {
+		t.Error("interaction was not recorded:", he.Interactions)
+	}
+
+	// The interaction should have been saved.
+	var sdb savedHostDB
+	err = persist.LoadFile(persistMetadata, &sdb, filepath.Join(hdb.persistDir, "hostdb.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sdb.Hosts) != 1 || len(sdb.Hosts[0].Interactions) != 1 {
+		t.Error("interaction was not saved")
+	}
+
+	// Interactions with unknown hosts are ignored.
+	hdb.RecordInteraction(fakeAddr(2), modules.HostInteraction{Success: true})
+}
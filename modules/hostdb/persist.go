@@ -0,0 +1,104 @@
+package hostdb
+
+import (
+	"path/filepath"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+var persistMetadata = persist.Metadata{
+	Header:  "Sia HostDB",
+	Version: "0.3.3",
+}
+
+// savedHostEntry is the persisted form of a hostEntry. Weights are not saved,
+// because they are recomputed from the settings when the hostdb is loaded.
+type savedHostEntry struct {
+	Settings     modules.HostSettings
+	Reliability  types.Currency
+	Active       bool
+	ScanHistory  []modules.HostScan
+	Interactions []modules.HostInteraction
+
+	AnnounceHeight types.BlockHeight
+	Announcements  []announcement
+}
+
+// savedHostDB is the persisted form of the hostdb. BlockHeight is the height
+// that the weights were computed at.
+type savedHostDB struct {
+	Hosts        []savedHostEntry
+	Filter       modules.HostFilter
+	ScanSettings modules.HostDBScanSettings
+	BlockHeight  types.BlockHeight
+}
+
+// save writes every known host to disk, along with the filter and the scan
+// settings.
+func (hdb *HostDB) save() error {
+	sdb := savedHostDB{
+		Hosts:        make([]savedHostEntry, 0, len(hdb.allHosts)),
+		Filter:       hdb.filter,
+		ScanSettings: hdb.scanSettings,
+		BlockHeight:  hdb.blockHeight(),
+	}
+	for _, entry := range hdb.allHosts {
+		_, active := hdb.activeHosts[entry.IPAddress]
+		sdb.Hosts = append(sdb.Hosts, savedHostEntry{
+			Settings:     entry.HostSettings,
+			Reliability:  entry.reliability,
+			Active:       active,
+			ScanHistory:  entry.scanHistory,
+			Interactions: entry.interactions,
+
+			AnnounceHeight: entry.announceHeight,
+			Announcements:  entry.announcements,
+		})
+	}
+	return persist.SaveFile(persistMetadata, sdb, filepath.Join(hdb.persistDir, "hostdb.json"))
+}
+
+// load restores the hosts, filter and scan settings saved to disk. Hosts that were active
+// when the hostdb was saved are put straight back into the set of active hosts,
+// so that renters do not need to wait for a scan before uploading. All hosts
+// will be rescanned by threadedScan. Weights are computed at the saved height,
+// since the consensus set has not sent any blocks yet.
+func (hdb *HostDB) load() error {
+	// Files written before the scan settings were saved keep the defaults.
+	sdb := savedHostDB{ScanSettings: hdb.scanSettings}
+	err := persist.LoadFile(persistMetadata, &sdb, filepath.Join(hdb.persistDir, "hostdb.json"))
+	if err != nil {
+		return err
+	}
+	hdb.filter = sdb.Filter
+	hdb.savedHeight = sdb.BlockHeight
+	if checkScanSettings(sdb.ScanSettings) == nil {
+		hdb.scanSettings = sdb.ScanSettings
+	}
+	for _, se := range sdb.Hosts {
+		entry := &hostEntry{
+			HostSettings: se.Settings,
+			reliability:  se.Reliability,
+			scanHistory:  se.ScanHistory,
+			interactions: se.Interactions,
+
+			announceHeight: se.AnnounceHeight,
+			announcements:  se.Announcements,
+		}
+		entry.weight = hdb.hostWeight(*entry)
+		hdb.allHosts[entry.IPAddress] = entry
+		if se.Active && len(hdb.activeHosts) < MaxActiveHosts {
+			hdb.insertNode(entry)
+		}
+	}
+	return nil
+}
+
+// Close saves the state of the hostdb.
+func (hdb *HostDB) Close() error {
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+	return hdb.save()
+}
@@ -0,0 +1,84 @@
+package hostdb
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestSaveLoad checks that hosts, along with whether they are active, survive
+// a restart of the hostdb.
+func TestSaveLoad(t *testing.T) {
+	hdbt := newHDBTester("TestSaveLoad", t)
+
+	hdbt.hostdb.InsertHost(modules.HostSettings{IPAddress: "foo:1234"})
+	hdbt.hostdb.InsertHost(modules.HostSettings{
+		IPAddress: hdbt.host.Address(),
+		PublicKey: hdbt.host.Settings().PublicKey,
+	})
+	<-hdbt.hostdbUpdateChan
+	if len(hdbt.hostdb.ActiveHosts()) != 1 {
+		t.Fatal("expecting an active host")
+	}
+	err := hdbt.hostdb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a new hostdb using the same directory.
+	hdb, err := New(hdbt.cs, hdbt.gateway, hdbt.hostdb.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+	if len(hdb.allHosts) != 2 {
+		t.Fatal("wrong number of hosts after loading:", len(hdb.allHosts))
+	}
+	if _, exists := hdb.activeHosts[hdbt.host.Address()]; !exists {
+		t.Fatal("active host was not restored")
+	}
+	entry := hdb.allHosts[hdbt.host.Address()]
+	if entry.PublicKey != hdbt.host.Settings().PublicKey || len(entry.scanHistory) == 0 {
+		t.Error("host entry was not restored correctly")
+	}
+	if entry.weight.IsZero() {
+		t.Error("weight of the restored host was not computed")
+	}
+}
+
+// TestLoadWeights checks that the weights of loaded hosts are computed at the
+// height the hostdb was saved at, rather than at the start of the replay.
+func TestLoadWeights(t *testing.T) {
+	hdbt := newHDBTester("TestLoadWeights", t)
+
+	id := hdbt.hostdb.mu.Lock()
+	hdbt.hostdb.savedHeight = 1000
+	hdbt.hostdb.allHosts[fakeAddr(1)] = &hostEntry{
+		HostSettings: modules.HostSettings{IPAddress: fakeAddr(1)},
+		reliability:  MaxReliability,
+	}
+	err := hdbt.hostdb.save()
+	hdbt.hostdb.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a new hostdb using the same directory.
+	hdb, err := New(hdbt.cs, hdbt.gateway, hdbt.hostdb.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id = hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+	entry := hdb.allHosts[fakeAddr(1)]
+	if entry == nil {
+		t.Fatal("host was not loaded")
+	}
+	if hdb.ageFactor(*entry) <= minAgeFactor {
+		t.Error("loaded host was weighed as if it was just announced")
+	}
+	if entry.weight.Cmp(hdb.hostWeight(*entry)) != 0 {
+		t.Error("loaded host has a stale weight")
+	}
+}
@@ -6,11 +6,13 @@ package hostdb
 
 import (
 	"crypto/rand"
+	"errors"
 	"math/big"
-	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
@@ -21,32 +23,51 @@ const (
 	MaxScanSleep     = 6 * time.Hour
 	MinScanSleep     = 1 * time.Hour
 
-	MaxActiveHosts              = 500
+	MaxActiveHosts              = 20000
 	InactiveHostCheckupQuantity = 250
 
 	maxSettingsLen = 1024
 
 	hostRequestTimeout = 5 * time.Second
 
-	// scanningThreads is the number of threads that will be probing hosts for
-	// their settings and checking for reliability.
+	// scanningThreads is the default number of threads that will be probing
+	// hosts for their settings and checking for reliability.
 	scanningThreads = 25
+
+	// maxScanningThreads is the largest number of scanning threads that can
+	// be configured.
+	maxScanningThreads = 250
 )
 
 var (
+	errBadScanSleep   = errors.New("scan sleeps must be positive, and the maximum must be at least the minimum")
+	errBadScanThreads = errors.New("the number of scanning threads must be between 1 and 250")
+
+	// defaultScanSettings are the scan settings of a new hostdb.
+	defaultScanSettings = modules.HostDBScanSettings{
+		MinScanSleep: MinScanSleep,
+		MaxScanSleep: MaxScanSleep,
+		ScanThreads:  scanningThreads,
+	}
+
 	MaxReliability     = types.NewCurrency64(50) // Given the scanning defaults, about 1 week of survival.
 	DefaultReliability = types.NewCurrency64(20) // Given the scanning defaults, about 3 days of survival.
 	UnreachablePenalty = types.NewCurrency64(1)
 )
 
-// addHostToScanPool creates a gofunc that adds a host to the scan pool. If the
-// scan pool is currently full, the blocking gofunc will not cause a deadlock.
-// The gofunc is created inside of this function to eliminate the burden of
-// needing to remember to call 'go addHostToScanPool'.
+// scanHostEntry adds a host to the scan pool. A host that is already waiting
+// in the pool is not added again. If the pool is full, the host is skipped
+// until the next round of scanning. The hostdb must be write-locked.
 func (hdb *HostDB) scanHostEntry(entry *hostEntry) {
-	go func() {
-		hdb.scanPool <- entry
-	}()
+	if entry.queued {
+		return
+	}
+	select {
+	case hdb.scanPool <- entry:
+		entry.queued = true
+		atomic.AddInt64(&hdb.scanQueue, 1)
+	default:
+	}
 }
 
 // decrementReliability reduces the reliability of a node, moving it out of the
@@ -63,8 +84,7 @@ func (hdb *HostDB) decrementReliability(addr modules.NetAddress, penalty types.C
 	// database.
 	node, exists := hdb.activeHosts[addr]
 	if exists {
-		delete(hdb.activeHosts, entry.IPAddress)
-		node.removeNode()
+		hdb.removeNode(node)
 		hdb.notifySubscribers()
 	}
 
@@ -75,53 +95,167 @@ func (hdb *HostDB) decrementReliability(addr modules.NetAddress, penalty types.C
 	}
 }
 
-// threadedProbeHost tries to fetch the settings of a host. If successful, the
-// host is put in the set of active hosts. If unsuccessful, the host id deleted
-// from the set of active hosts.
+// probeHost tries to fetch the settings of a host. If successful, the host is
+// put in the set of active hosts. If unsuccessful, the host id deleted from the
+// set of active hosts.
+func (hdb *HostDB) probeHost(hostEntry *hostEntry) {
+	// The public key of the entry can change while the host is being probed,
+	// so the address and keys are copied under lock. The host may prove that
+	// it holds its current key or any other key it has been announced with;
+	// the current key is tried first.
+	// The host has left the scan pool, so it may be queued again.
+	id := hdb.mu.Lock()
+	hostEntry.queued = false
+	addr, pubKey := hostEntry.IPAddress, hostEntry.PublicKey
+	keys := append([]crypto.PublicKey{pubKey}, hostEntry.candidateKeys()...)
+	hdb.mu.Unlock(id)
+
+	// Request settings from the queued host entry, timing how long the
+	// request takes.
+	var settings modules.HostSettings
+	var provenKey crypto.PublicKey
+	start := time.Now()
+	err := func() error {
+		conn, err := modules.Dial(addr, hostRequestTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		err = encoding.WriteObject(conn, [8]byte{'S', 'e', 't', 't', 'i', 'n', 'g', 's'})
+		if err != nil {
+			return err
+		}
+		provenKey, err = modules.IdentifyHost(conn, keys)
+		if err != nil {
+			return err
+		}
+		return encoding.ReadObject(conn, &settings, maxSettingsLen)
+	}()
+	// The latency is measured before locking the hostdb, so that hosts are
+	// not penalized for time spent waiting on the lock.
+	latency := time.Since(start)
+
+	// Now that network communication is done, lock the hostdb to modify the
+	// host entry.
+	id = hdb.mu.Lock()
+	{
+		// If the key changed during the probe, the result does not apply to
+		// the new key, which has already been queued for another scan.
+		if hostEntry.PublicKey != pubKey {
+			hdb.mu.Unlock(id)
+			return
+		}
+		scan := modules.HostScan{
+			Timestamp: start,
+			Success:   err == nil,
+		}
+		if err != nil {
+			hostEntry.recordScan(scan)
+			hdb.decrementReliability(hostEntry.IPAddress, UnreachablePenalty)
+			hdb.mu.Unlock(id)
+			return
+		}
+
+		node, exists1 := hdb.activeHosts[hostEntry.IPAddress]
+		_, exists2 := hdb.allHosts[hostEntry.IPAddress]
+
+		// Update the host settings, reliability, and weight. The old IPAddress
+		// must be preserved, and the PublicKey is the announced key that the
+		// host proved it holds. The weight of an active host is updated in
+		// place.
+		settings.IPAddress = hostEntry.HostSettings.IPAddress
+		settings.PublicKey = provenKey
+		scan.Latency = latency
+		scan.SettingsChanged = settingsChanged(hostEntry.HostSettings, settings)
+		hostEntry.recordScan(scan)
+		hostEntry.HostSettings = settings
+		hostEntry.reliability = MaxReliability
+		hdb.setWeight(hostEntry, hdb.hostWeight(*hostEntry))
+
+		// If 'MaxActiveHosts' has not been reached, add the host to the
+		// set of active hosts. A host that is not accepting contracts is
+		// online, but should not be selected for uploads, so it is left
+		// out.
+		active := settings.AcceptingContracts && exists2
+		if active && !exists1 && len(hdb.activeHosts) < MaxActiveHosts {
+			hdb.insertNode(hostEntry)
+			hdb.notifySubscribers()
+		} else if !active && exists1 {
+			hdb.removeNode(node)
+			hdb.notifySubscribers()
+		}
+	}
+	hdb.mu.Unlock(id)
+}
+
+// threadedProbeHosts probes the hosts in the scan pool until the thread is
+// stopped by setScanThreads.
 func (hdb *HostDB) threadedProbeHosts() {
-	for hostEntry := range hdb.scanPool {
-		// Request settings from the queued host entry.
-		var settings modules.HostSettings
-		err := func() error {
-			conn, err := net.DialTimeout("tcp", string(hostEntry.IPAddress), hostRequestTimeout)
-			if err != nil {
-				return err
-			}
-			defer conn.Close()
-			err = encoding.WriteObject(conn, [8]byte{'S', 'e', 't', 't', 'i', 'n', 'g', 's'})
-			if err != nil {
-				return err
-			}
-			return encoding.ReadObject(conn, &settings, maxSettingsLen)
+	for {
+		select {
+		case entry := <-hdb.scanPool:
+			atomic.AddInt64(&hdb.scanQueue, -1)
+			atomic.AddInt64(&hdb.activeScans, 1)
+			hdb.probeHost(entry)
+			atomic.AddInt64(&hdb.activeScans, -1)
+		case <-hdb.stopScanThread:
+			return
+		}
+	}
+}
+
+// setScanThreads starts or stops scanning threads until n are running. A
+// thread that is probing a host finishes the probe before stopping.
+func (hdb *HostDB) setScanThreads(n int) {
+	for hdb.scanThreads < n {
+		hdb.scanThreads++
+		go hdb.threadedProbeHosts()
+	}
+	for hdb.scanThreads > n {
+		hdb.scanThreads--
+		go func() {
+			hdb.stopScanThread <- struct{}{}
 		}()
+	}
+}
 
-		// Now that network communication is done, lock the hostdb to modify the
-		// host entry.
-		id := hdb.mu.Lock()
-		{
-			if err != nil {
-				hdb.decrementReliability(hostEntry.IPAddress, UnreachablePenalty)
-				hdb.mu.Unlock(id)
-				continue
-			}
+// scanSleep returns a random amount of time to sleep between rounds of
+// scanning. The minimums and maximums keep the scan time reasonable, while the
+// randomness prevents the scanning from always happening at the same time of
+// day or week.
+func (hdb *HostDB) scanSleep() time.Duration {
+	id := hdb.mu.RLock()
+	settings := hdb.scanSettings
+	hdb.mu.RUnlock(id)
+
+	spread := settings.MaxScanSleep - settings.MinScanSleep
+	if spread <= 0 {
+		return settings.MinScanSleep
+	}
+	randSleep, err := rand.Int(rand.Reader, big.NewInt(int64(spread)))
+	if err != nil {
+		if build.DEBUG {
+			panic(err)
+		}
+		// If there's an error, sleep for the default amount of time.
+		return DefaultScanSleep
+	}
+	return settings.MinScanSleep + time.Duration(randSleep.Int64())
+}
 
-			// Update the host settings, reliability, and weight. The old IPAddress
-			// must be preserved.
-			settings.IPAddress = hostEntry.HostSettings.IPAddress
-			hostEntry.HostSettings = settings
-			hostEntry.reliability = MaxReliability
-			hostEntry.weight = hdb.hostWeight(*hostEntry)
-
-			// If the host is not already in the database and 'MaxActiveHosts' has not
-			// been reached, add the host to the database.
-			_, exists1 := hdb.activeHosts[hostEntry.IPAddress]
-			_, exists2 := hdb.allHosts[hostEntry.IPAddress]
-			if !exists1 && exists2 && len(hdb.activeHosts) < MaxActiveHosts {
-				hdb.insertNode(hostEntry)
-				hdb.notifySubscribers()
+// shuffleHosts randomizes the order of a slice of hosts by swapping each
+// element with an element that hasn't been visited yet.
+func shuffleHosts(hosts []*hostEntry) {
+	for i := 0; i < len(hosts)-1; i++ {
+		N, err := rand.Int(rand.Reader, big.NewInt(int64(len(hosts)-i)))
+		if err != nil {
+			if build.DEBUG {
+				panic(err)
 			}
+			return
 		}
-		hdb.mu.Unlock(id)
+		n := int(N.Int64()) + i
+		hosts[i], hosts[n] = hosts[n], hosts[i]
 	}
 }
 
@@ -154,23 +288,7 @@ func (hdb *HostDB) threadedScan() {
 				}
 			}
 
-			// Randomize the slice by swapping each element with an element
-			// that hasn't been visited yet.
-			for i := 0; i < len(random); i++ {
-				N, err := rand.Int(rand.Reader, big.NewInt(int64(len(random)-i-1)))
-				if err != nil {
-					if build.DEBUG {
-						panic(err)
-					}
-				} else {
-					break
-				}
-
-				n := int(N.Int64()) + i
-				tmp := random[i]
-				random[i] = random[n]
-				random[n] = tmp
-			}
+			shuffleHosts(random)
 
 			// Select the first InactiveHostCheckupQuantity hosts from the
 			// shuffled list and scan them.
@@ -181,25 +299,87 @@ func (hdb *HostDB) threadedScan() {
 			for i := 0; i < n; i++ {
 				hdb.scanHostEntry(random[i])
 			}
+
+			// Save the results of the previous round of scanning.
+			_ = hdb.save() // TODO: Some way to communicate that the save failed.
 		}
 		hdb.mu.Unlock(id)
 
 		// Sleep for a random amount of time before doing another round of
-		// scanning. The minimums and maximums keep the scan time reasonable,
-		// while the randomness prevents the scanning from always happening at
-		// the same time of day or week.
-		maxBig := big.NewInt(int64(MaxScanSleep))
-		minBig := big.NewInt(int64(MinScanSleep))
-		randSleep, err := rand.Int(rand.Reader, maxBig.Sub(maxBig, minBig))
-		if err != nil {
-			if build.DEBUG {
-				panic(err)
-			} else {
-				// If there's an error, sleep for the default amount of time.
-				defaultBig := big.NewInt(int64(DefaultScanSleep))
-				randSleep = defaultBig.Sub(defaultBig, minBig)
+		// scanning. If the scan settings change, a new sleep is picked,
+		// still counting from the end of this round.
+		roundEnd := time.Now()
+		for waiting := true; waiting; {
+			select {
+			case <-time.After(hdb.scanSleep() - time.Since(roundEnd)):
+				waiting = false
+			case <-hdb.scanSettingsChanged:
 			}
 		}
-		time.Sleep(time.Duration(randSleep.Int64()) + MinScanSleep) // this means the MaxScanSleep is actual Max+Min.
 	}
 }
+
+// checkScanSettings returns an error if the scan settings are not usable.
+func checkScanSettings(settings modules.HostDBScanSettings) error {
+	if settings.MinScanSleep <= 0 || settings.MaxScanSleep < settings.MinScanSleep {
+		return errBadScanSleep
+	}
+	if settings.ScanThreads < 1 || settings.ScanThreads > maxScanningThreads {
+		return errBadScanThreads
+	}
+	return nil
+}
+
+// ScanAll queues every known host to be scanned immediately. Hosts that are
+// already queued are not queued again.
+func (hdb *HostDB) ScanAll() {
+	id := hdb.mu.Lock()
+	defer hdb.mu.Unlock(id)
+	for _, entry := range hdb.allHosts {
+		hdb.scanHostEntry(entry)
+	}
+}
+
+// ScanHost queues a host to be scanned immediately.
+func (hdb *HostDB) ScanHost(addr modules.NetAddress) error {
+	id := hdb.mu.Lock()
+	defer hdb.mu.Unlock(id)
+	entry, exists := hdb.allHosts[addr]
+	if !exists {
+		return errUnknownHost
+	}
+	hdb.scanHostEntry(entry)
+	return nil
+}
+
+// ScanStatus returns the scan settings of the hostdb, along with the number of
+// hosts waiting to be scanned and being scanned.
+func (hdb *HostDB) ScanStatus() modules.HostDBScanStatus {
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+	return modules.HostDBScanStatus{
+		HostDBScanSettings: hdb.scanSettings,
+		QueueDepth:         int(atomic.LoadInt64(&hdb.scanQueue)),
+		ActiveScans:        int(atomic.LoadInt64(&hdb.activeScans)),
+	}
+}
+
+// SetScanSettings changes the interval between rounds of scanning and the
+// number of scanning threads. The settings are saved across restarts.
+func (hdb *HostDB) SetScanSettings(settings modules.HostDBScanSettings) error {
+	if err := checkScanSettings(settings); err != nil {
+		return err
+	}
+	id := hdb.mu.Lock()
+	defer hdb.mu.Unlock(id)
+	hdb.scanSettings = settings
+	hdb.setScanThreads(settings.ScanThreads)
+
+	// Wake threadedScan so that the new interval applies to the current
+	// sleep. If it has already been woken, there is nothing more to do.
+	select {
+	case hdb.scanSettingsChanged <- struct{}{}:
+	default:
+	}
+	return hdb.save()
+}
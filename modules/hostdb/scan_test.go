@@ -0,0 +1,138 @@
+package hostdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestScanSettings checks that the scan settings can be changed at runtime and
+// are saved across restarts.
+func TestScanSettings(t *testing.T) {
+	hdbt := newHDBTester("TestScanSettings", t)
+	hdb := hdbt.hostdb
+
+	if hdb.ScanStatus().HostDBScanSettings != defaultScanSettings {
+		t.Fatal("a new hostdb should use the default scan settings")
+	}
+	bad := []modules.HostDBScanSettings{
+		{MinScanSleep: 0, MaxScanSleep: time.Hour, ScanThreads: 1},
+		{MinScanSleep: time.Hour, MaxScanSleep: time.Minute, ScanThreads: 1},
+		{MinScanSleep: time.Minute, MaxScanSleep: time.Hour, ScanThreads: 0},
+		{MinScanSleep: time.Minute, MaxScanSleep: time.Hour, ScanThreads: maxScanningThreads + 1},
+	}
+	for _, settings := range bad {
+		if hdb.SetScanSettings(settings) == nil {
+			t.Error("bad scan settings were accepted:", settings)
+		}
+	}
+
+	settings := modules.HostDBScanSettings{MinScanSleep: time.Minute, MaxScanSleep: time.Minute, ScanThreads: 3}
+	err := hdb.SetScanSettings(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := hdb.mu.RLock()
+	threads := hdb.scanThreads
+	hdb.mu.RUnlock(id)
+	if hdb.ScanStatus().HostDBScanSettings != settings || threads != 3 {
+		t.Error("scan settings were not applied")
+	}
+	if hdb.scanSleep() != time.Minute {
+		t.Error("wrong scan sleep:", hdb.scanSleep())
+	}
+
+	// Reload the hostdb and check that the settings were saved.
+	hdb2, err := New(hdbt.cs, hdbt.gateway, hdb.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdb2.ScanStatus().HostDBScanSettings != settings {
+		t.Error("scan settings were not saved")
+	}
+}
+
+// TestScanHost checks that a host can be scanned on demand.
+func TestScanHost(t *testing.T) {
+	hdbt := newHDBTester("TestScanHost", t)
+	hdb := hdbt.hostdb
+
+	if hdb.ScanHost(fakeAddr(1)) != errUnknownHost {
+		t.Fatal("scanned an unknown host")
+	}
+
+	// Add an unreachable host without scanning it, then scan it.
+	entry := &hostEntry{
+		HostSettings: modules.HostSettings{IPAddress: fakeAddr(1)},
+		reliability:  DefaultReliability,
+	}
+	id := hdb.mu.Lock()
+	hdb.allHosts[entry.IPAddress] = entry
+	hdb.mu.Unlock(id)
+	if err := hdb.ScanHost(entry.IPAddress); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		id := hdb.mu.RLock()
+		scans := len(entry.scanHistory)
+		hdb.mu.RUnlock(id)
+		if scans == 1 {
+			break
+		} else if i == 100 {
+			t.Fatal("host was not scanned")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	status := hdb.ScanStatus()
+	if status.QueueDepth != 0 {
+		t.Error("scan queue should be empty, has", status.QueueDepth)
+	}
+}
+
+// TestShuffleHosts checks that shuffleHosts permutes its input, and that every
+// host can end up first.
+func TestShuffleHosts(t *testing.T) {
+	// Empty and single-host slices should not cause a panic.
+	shuffleHosts(nil)
+	shuffleHosts([]*hostEntry{{}})
+
+	hosts := make([]*hostEntry, 5)
+	for i := range hosts {
+		hosts[i] = &hostEntry{HostSettings: modules.HostSettings{IPAddress: fakeAddr(uint8(i))}}
+	}
+	first := make(map[modules.NetAddress]bool)
+	for i := 0; i < 200; i++ {
+		shuffleHosts(hosts)
+		seen := make(map[modules.NetAddress]bool)
+		for _, h := range hosts {
+			seen[h.IPAddress] = true
+		}
+		if len(seen) != len(hosts) {
+			t.Fatal("shuffle did not produce a permutation")
+		}
+		first[hosts[0].IPAddress] = true
+	}
+	if len(first) != len(hosts) {
+		t.Fatal("not every host was shuffled to the front:", len(first))
+	}
+}
+
+// TestScanHostEntryQueue checks that a host is only queued once, and that
+// hosts are skipped when the scan pool is full.
+func TestScanHostEntryQueue(t *testing.T) {
+	hdb := &HostDB{scanPool: make(chan *hostEntry, 1)}
+	entry1, entry2 := new(hostEntry), new(hostEntry)
+
+	hdb.scanHostEntry(entry1)
+	hdb.scanHostEntry(entry1)
+	if len(hdb.scanPool) != 1 || !entry1.queued || hdb.scanQueue != 1 {
+		t.Fatal("host was not queued exactly once")
+	}
+
+	// The pool is full, so the second host is skipped without blocking.
+	hdb.scanHostEntry(entry2)
+	if len(hdb.scanPool) != 1 || entry2.queued || hdb.scanQueue != 1 {
+		t.Fatal("host was queued in a full pool")
+	}
+}
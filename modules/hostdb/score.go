@@ -0,0 +1,165 @@
+package hostdb
+
+// score.go determines the weight of a host, which is the likelihood that the
+// host will be selected by RandomHosts. The weight starts from the price of the
+// host and is scaled by several factors, each between 0 and 1, which penalize
+// hosts that offer little collateral, have little storage remaining, are
+// unreliable or slow to respond, have only just appeared on the network, or
+// have performed poorly.
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// storageTarget is the amount of remaining storage at which a host
+	// stops being penalized for having too little storage.
+	storageTarget = 1 << 30 // 1 GiB
+
+	// ageTarget is the number of blocks after its announcement at which a
+	// host stops being penalized for being new.
+	ageTarget = 1008 // 1 week
+
+	// latencyTarget is the 90th percentile scan latency below which a host
+	// is not penalized for being slow.
+	latencyTarget = 500 * time.Millisecond
+
+	// The smallest factors that a host can receive. A floor keeps a single
+	// bad factor from making a host impossible to select.
+	minCollateralFactor = 0.25
+	minStorageFactor    = 0.01
+	minUptimeFactor     = 0.01
+	minLatencyFactor    = 0.25
+	minAgeFactor        = 0.25
+)
+
+var (
+	errUnknownHost = errors.New("host is not in the hostdb")
+
+	// Because most weights would otherwise be fractional, we set the base
+	// weight to 10^120 to give ourselves lots of precision when determing
+	// the weight of a host
+	baseWeight = types.NewCurrency(new(big.Int).Exp(big.NewInt(10), big.NewInt(120), nil))
+)
+
+// clampFactor limits a factor to the range [min, 1].
+func clampFactor(f, min float64) float64 {
+	if f < min {
+		return min
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// collateralFactor rewards hosts that put up collateral. A host that offers at
+// least as much collateral as it charges is not penalized.
+func collateralFactor(entry hostEntry) float64 {
+	if entry.Price.IsZero() {
+		return 1
+	}
+	ratio, _ := new(big.Rat).SetFrac(entry.Collateral.Big(), entry.Price.Big()).Float64()
+	return clampFactor(minCollateralFactor+(1-minCollateralFactor)*ratio, minCollateralFactor)
+}
+
+// storageFactor penalizes hosts that are running out of storage.
+func storageFactor(entry hostEntry) float64 {
+	return clampFactor(float64(entry.RemainingStorage)/storageTarget, minStorageFactor)
+}
+
+// uptimeFactor penalizes hosts that have recently failed to respond to scans.
+// The uptime is cubed so that the occasional failure matters little, but
+// frequent failures matter a lot. Hosts that have not been scanned fall back
+// to their reliability.
+func uptimeFactor(entry hostEntry) float64 {
+	uptime, ok := entry.uptime()
+	if !ok {
+		uptime, _ = new(big.Rat).SetFrac(entry.reliability.Big(), MaxReliability.Big()).Float64()
+	}
+	return clampFactor(uptime*uptime*uptime, minUptimeFactor)
+}
+
+// latencyFactor penalizes hosts that are slow to respond to scans.
+func latencyFactor(entry hostEntry) float64 {
+	latency := entry.latencyPercentile(90)
+	if latency <= latencyTarget {
+		return 1
+	}
+	return clampFactor(float64(latencyTarget)/float64(latency), minLatencyFactor)
+}
+
+// ageFactor penalizes hosts that were announced recently, as they have not had
+// time to prove themselves.
+func (hdb *HostDB) ageFactor(entry hostEntry) float64 {
+	height := hdb.blockHeight()
+	if height <= entry.announceHeight {
+		return minAgeFactor
+	}
+	return clampFactor(float64(height-entry.announceHeight)/ageTarget, minAgeFactor)
+}
+
+// hostPrice returns the price of a host. To prevent a divide by zero error, the
+// price is at least one.
+func hostPrice(entry hostEntry) types.Currency {
+	if entry.Price.Cmp(types.NewCurrency64(0)) <= 0 {
+		return types.NewCurrency64(1)
+	}
+	return entry.Price
+}
+
+// priceWeight returns the base weight divided by the cube of the price.
+func priceWeight(entry hostEntry) types.Currency {
+	price := hostPrice(entry)
+	return baseWeight.Div(price).Div(price).Div(price)
+}
+
+// scoreBreakdown calculates the weight of a host along with the contribution
+// of each factor.
+func (hdb *HostDB) scoreBreakdown(entry hostEntry) modules.HostScoreBreakdown {
+	sb := modules.HostScoreBreakdown{
+		PriceWeight:       priceWeight(entry),
+		CollateralFactor:  collateralFactor(entry),
+		StorageFactor:     storageFactor(entry),
+		UptimeFactor:      uptimeFactor(entry),
+		LatencyFactor:     latencyFactor(entry),
+		AgeFactor:         hdb.ageFactor(entry),
+		PerformanceFactor: performanceFactor(entry),
+	}
+
+	// The factors are multiplied into the base weight before dividing by the
+	// price, so that the weight is only rounded once. This keeps the ratio
+	// between the weights of two hosts that differ only in price exact.
+	price := hostPrice(entry)
+	w := new(big.Rat).SetInt(baseWeight.Big())
+	for _, f := range []float64{sb.CollateralFactor, sb.StorageFactor, sb.UptimeFactor, sb.LatencyFactor, sb.AgeFactor, sb.PerformanceFactor} {
+		w.Mul(w, new(big.Rat).SetFloat64(f))
+	}
+	w.Quo(w, new(big.Rat).SetInt(price.Mul(price).Mul(price).Big()))
+	sb.Weight = types.NewCurrency(new(big.Int).Div(w.Num(), w.Denom()))
+	return sb
+}
+
+// hostWeight returns the weight of a host according to the settings of the
+// host database.
+func (hdb *HostDB) hostWeight(entry hostEntry) types.Currency {
+	return hdb.scoreBreakdown(entry).Weight
+}
+
+// ScoreBreakdown returns the weight of a host along with the contribution of
+// each factor, for debugging host selection.
+func (hdb *HostDB) ScoreBreakdown(addr modules.NetAddress) (modules.HostScoreBreakdown, error) {
+	id := hdb.mu.RLock()
+	defer hdb.mu.RUnlock(id)
+
+	entry, exists := hdb.allHosts[addr]
+	if !exists {
+		return modules.HostScoreBreakdown{}, errUnknownHost
+	}
+	return hdb.scoreBreakdown(*entry), nil
+}
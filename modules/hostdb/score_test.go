@@ -0,0 +1,76 @@
+package hostdb
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestScoreFactors checks that each factor of the score moves the weight of a
+// host in the right direction.
+func TestScoreFactors(t *testing.T) {
+	hdbt := newHDBTester("TestScoreFactors", t)
+	hdb := hdbt.hostdb
+
+	base := hostEntry{
+		HostSettings: modules.HostSettings{
+			Price:            types.NewCurrency64(10),
+			RemainingStorage: storageTarget,
+		},
+		reliability: MaxReliability,
+	}
+	baseWeight := hdb.hostWeight(base)
+
+	collateral := base
+	collateral.Collateral = types.NewCurrency64(10)
+	if hdb.hostWeight(collateral).Cmp(baseWeight) <= 0 {
+		t.Error("offering collateral did not increase the weight")
+	}
+
+	full := base
+	full.RemainingStorage = 0
+	if hdb.hostWeight(full).Cmp(baseWeight) >= 0 {
+		t.Error("running out of storage did not decrease the weight")
+	}
+
+	unreliable := base
+	unreliable.reliability = DefaultReliability
+	if hdb.hostWeight(unreliable).Cmp(baseWeight) >= 0 {
+		t.Error("low reliability did not decrease the weight")
+	}
+
+	// Pretend the blockchain has grown so that the age of a host matters.
+	id := hdb.mu.Lock()
+	hdb.consensusHeight += ageTarget
+	hdb.mu.Unlock(id)
+	young := base
+	young.announceHeight = hdb.blockHeight()
+	if hdb.hostWeight(young).Cmp(hdb.hostWeight(base)) >= 0 {
+		t.Error("a new host did not have a lower weight than an old one")
+	}
+}
+
+// TestScoreBreakdown checks that ScoreBreakdown reports the weight of a known
+// host.
+func TestScoreBreakdown(t *testing.T) {
+	hdbt := newHDBTester("TestScoreBreakdown", t)
+	_, err := hdbt.hostdb.ScoreBreakdown("foo:1234")
+	if err != errUnknownHost {
+		t.Fatal("expected errUnknownHost, got", err)
+	}
+
+	hdbt.hostdb.InsertHost(modules.HostSettings{IPAddress: "foo:1234"})
+	sb, err := hdbt.hostdb.ScoreBreakdown("foo:1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb.Weight.Cmp(sb.PriceWeight) > 0 {
+		t.Error("weight should not exceed the price weight")
+	}
+	for _, f := range []float64{sb.CollateralFactor, sb.StorageFactor, sb.UptimeFactor, sb.LatencyFactor, sb.AgeFactor, sb.PerformanceFactor} {
+		if f <= 0 || f > 1 {
+			t.Error("factor out of range:", f)
+		}
+	}
+}
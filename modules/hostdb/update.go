@@ -13,13 +13,15 @@ package hostdb
 import (
 	"strings"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
 )
 
 // findHostAnnouncements returns a list of the host announcements found within
-// a given block. No check is made to see that the ip address found in the
+// a given block. Announcements that are not signed by their public key are
+// ignored. No check is made to see that the ip address found in the
 // announcement is actually a valid ip address.
 func findHostAnnouncements(b types.Block) (announcements []modules.HostSettings) {
 	for _, t := range b.Transactions {
@@ -36,10 +38,14 @@ func findHostAnnouncements(b types.Block) (announcements []modules.HostSettings)
 			if err != nil {
 				continue
 			}
+			if ha.Verify() != nil {
+				continue
+			}
 
 			// Add the announcement to the slice being returned.
 			announcements = append(announcements, modules.HostSettings{
 				IPAddress: ha.IPAddress,
+				PublicKey: ha.PublicKey,
 			})
 		}
 	}
@@ -47,21 +53,89 @@ func findHostAnnouncements(b types.Block) (announcements []modules.HostSettings)
 	return
 }
 
+// earliestAnnouncement sets the announce height of a host to the height of its
+// earliest announcement.
+func (entry *hostEntry) earliestAnnouncement() {
+	for i, a := range entry.announcements {
+		if i == 0 || a.Height < entry.announceHeight {
+			entry.announceHeight = a.Height
+		}
+	}
+}
+
+// unannounceHost undoes an announcement of a host found in a reverted block.
+// Hosts left with no announcements are removed from the hostdb.
+func (hdb *HostDB) unannounceHost(host modules.HostSettings, bid types.BlockID) {
+	addr := host.IPAddress
+	entry, exists := hdb.allHosts[addr]
+	if !exists {
+		return
+	}
+	for i := range entry.announcements {
+		a := entry.announcements[i]
+		if a.BlockID == bid && (a.PublicKey == host.PublicKey || a.PublicKey == (crypto.PublicKey{})) {
+			entry.announcements = append(entry.announcements[:i], entry.announcements[i+1:]...)
+			if len(entry.announcements) == 0 {
+				hdb.removeHost(addr)
+				return
+			}
+			entry.earliestAnnouncement()
+
+			// If the current key is no longer announced, the host falls back
+			// to the newest announced key, and is not selected again until
+			// it proves that it holds that key.
+			candidates := entry.candidateKeys()
+			if !entry.announced(entry.PublicKey) && len(candidates) > 0 {
+				entry.PublicKey = candidates[0]
+				if node, exists := hdb.activeHosts[addr]; exists {
+					hdb.removeNode(node)
+					hdb.notifySubscribers()
+				}
+				hdb.scanHostEntry(entry)
+			}
+			return
+		}
+	}
+}
+
 // ReceiveConsensusSetUpdate accepts an update from the consensus set which
 // contains new blocks.
 func (hdb *HostDB) ReceiveConsensusSetUpdate(cc modules.ConsensusChange) {
 	id := hdb.mu.Lock()
 	defer hdb.mu.Unlock(id)
 
-	// Add hosts announced in blocks that were applied.
-	for _, block := range cc.AppliedBlocks {
+	// Undo the announcements in blocks that were reverted. The hostdb is
+	// saved once for the whole change, rather than once per announcement,
+	// since the consensus set replays every block on startup.
+	changed := false
+	for _, block := range cc.RevertedBlocks {
+		for _, host := range findHostAnnouncements(block) {
+			hdb.unannounceHost(host, block.ID())
+			changed = true
+		}
+	}
+
+	// Add hosts announced in blocks that were applied. consensusHeight
+	// counts the genesis block, so after the reverted blocks are removed it
+	// is the height of the first applied block.
+	firstHeight := hdb.consensusHeight - len(cc.RevertedBlocks)
+	for i, block := range cc.AppliedBlocks {
 		for _, host := range findHostAnnouncements(block) {
-			hdb.insertHost(host)
+			hdb.insertHost(host, announcement{BlockID: block.ID(), Height: types.BlockHeight(firstHeight + i)})
+			changed = true
 		}
 	}
+	if changed {
+		_ = hdb.save() // TODO: Some way to communicate that the save failed.
+	}
 
 	hdb.consensusHeight -= len(cc.RevertedBlocks)
 	hdb.consensusHeight += len(cc.AppliedBlocks)
+	if hdb.consensusHeight > int(hdb.savedHeight) {
+		// The replay has caught up; blocks reverted from now on really
+		// lower the height.
+		hdb.savedHeight = 0
+	}
 	hdb.notifySubscribers()
 	return
 }
@@ -2,16 +2,36 @@ package hostdb
 
 import (
 	"testing"
+	"time"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
 )
 
+// signedAnnouncement returns an encoded host announcement for addr, signed by
+// a fresh key.
+func signedAnnouncement(t *testing.T, addr modules.NetAddress) string {
+	sk, pk, err := crypto.GenerateSignatureKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha := modules.HostAnnouncement{
+		IPAddress: addr,
+		PublicKey: pk,
+	}
+	ha.Signature, err = crypto.SignHash(ha.SigHash(), sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return modules.PrefixHostAnnouncement + string(encoding.Marshal(ha))
+}
+
 // TestFindHostAnnouncements probes the findHostAnnouncements function
 func TestFindHostAnnouncements(t *testing.T) {
 	// Create a block with a host announcement.
-	announcement := modules.PrefixHostAnnouncement + string(encoding.Marshal(modules.HostAnnouncement{}))
+	announcement := signedAnnouncement(t, "foo:1234")
 	b := types.Block{
 		Transactions: []types.Transaction{
 			types.Transaction{
@@ -21,7 +41,30 @@ func TestFindHostAnnouncements(t *testing.T) {
 	}
 	announcements := findHostAnnouncements(b)
 	if len(announcements) != 1 {
-		t.Error("host announcement not found in block")
+		t.Fatal("host announcement not found in block")
+	}
+	if announcements[0].PublicKey == (crypto.PublicKey{}) {
+		t.Error("public key of the announcement was not returned")
+	}
+
+	// Try with an announcement that is not signed.
+	b.Transactions[0].ArbitraryData[0] = modules.PrefixHostAnnouncement + string(encoding.Marshal(modules.HostAnnouncement{IPAddress: "foo:1234"}))
+	announcements = findHostAnnouncements(b)
+	if len(announcements) != 0 {
+		t.Error("host announcement found when there was no valid signature")
+	}
+
+	// Try with an announcement whose address was changed after signing.
+	var ha modules.HostAnnouncement
+	err := encoding.Unmarshal([]byte(announcement[len(modules.PrefixHostAnnouncement):]), &ha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha.IPAddress = "bar:1234"
+	b.Transactions[0].ArbitraryData[0] = modules.PrefixHostAnnouncement + string(encoding.Marshal(ha))
+	announcements = findHostAnnouncements(b)
+	if len(announcements) != 0 {
+		t.Error("host announcement found when the address did not match the signature")
 	}
 
 	// Try with an altered prefix
@@ -45,14 +88,12 @@ func TestReceiveConsensusSetUpdate(t *testing.T) {
 	hdbt := newHDBTester("TestFindHostAnnouncements", t)
 
 	// Put a host announcement into the blockchain.
-	announcement := encoding.Marshal(modules.HostAnnouncement{
-		IPAddress: hdbt.gateway.Address(),
-	})
+	announcement := signedAnnouncement(t, hdbt.gateway.Address())
 	id, err := hdbt.wallet.RegisterTransaction(types.Transaction{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, _, err = hdbt.wallet.AddArbitraryData(id, modules.PrefixHostAnnouncement+string(announcement))
+	_, _, err = hdbt.wallet.AddArbitraryData(id, announcement)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -84,3 +125,202 @@ func TestReceiveConsensusSetUpdate(t *testing.T) {
 		t.Fatal("hostdb should have a host after getting a host announcement transcation")
 	}
 }
+
+// TestRevertAnnouncements checks that announcements are undone when the blocks
+// containing them are reverted.
+func TestRevertAnnouncements(t *testing.T) {
+	hdbt := newHDBTester("TestRevertAnnouncements", t)
+	hdb := hdbt.hostdb
+
+	announcementBlock := func(addr modules.NetAddress) types.Block {
+		return types.Block{Transactions: []types.Transaction{{
+			ArbitraryData: []string{signedAnnouncement(t, addr)},
+		}}}
+	}
+	b1 := announcementBlock(fakeAddr(1))
+	b2 := announcementBlock(fakeAddr(1))
+	b3 := announcementBlock(fakeAddr(2))
+	height := hdb.blockHeight()
+	hdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{AppliedBlocks: []types.Block{b1, b2, b3}})
+
+	id := hdb.mu.RLock()
+	entry1, entry2 := hdb.allHosts[fakeAddr(1)], hdb.allHosts[fakeAddr(2)]
+	hdb.mu.RUnlock(id)
+	if entry1 == nil || entry2 == nil {
+		t.Fatal("announced hosts were not inserted")
+	}
+	if entry1.announceHeight != height+1 || entry2.announceHeight != height+3 {
+		t.Error("wrong announce heights:", entry1.announceHeight, entry2.announceHeight)
+	}
+
+	// A host with another announcement is kept when one of its
+	// announcements is reverted.
+	hdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{RevertedBlocks: []types.Block{b3, b2}})
+	id = hdb.mu.RLock()
+	_, exists1 := hdb.allHosts[fakeAddr(1)]
+	_, exists2 := hdb.allHosts[fakeAddr(2)]
+	hdb.mu.RUnlock(id)
+	if !exists1 || exists2 {
+		t.Fatal("wrong hosts after reverting b2 and b3:", exists1, exists2)
+	}
+	if len(entry1.announcements) != 1 || entry1.announceHeight != height+1 {
+		t.Error("wrong announcements after revert:", entry1.announcements)
+	}
+	hdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{RevertedBlocks: []types.Block{b1}})
+	id = hdb.mu.RLock()
+	_, exists1 = hdb.allHosts[fakeAddr(1)]
+	hdb.mu.RUnlock(id)
+	if exists1 {
+		t.Fatal("host with no remaining announcements was not removed")
+	}
+
+	// Hosts inserted by hand are not removed by reverts.
+	hdb.InsertHost(modules.HostSettings{IPAddress: fakeAddr(3)})
+	b4 := announcementBlock(fakeAddr(3))
+	hdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{AppliedBlocks: []types.Block{b4}})
+	hdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{RevertedBlocks: []types.Block{b4}})
+	if _, err := hdb.Host(fakeAddr(3)); err != nil {
+		t.Error("host inserted by hand was removed by a revert")
+	}
+}
+
+// TestRevertAfterRestart checks that an announcement replayed by the consensus
+// set after a restart is not recorded twice, so that reverting its block still
+// removes the host.
+func TestRevertAfterRestart(t *testing.T) {
+	hdbt := newHDBTester("TestRevertAfterRestart", t)
+	b := types.Block{Transactions: []types.Transaction{{
+		ArbitraryData: []string{signedAnnouncement(t, fakeAddr(1))},
+	}}}
+	hdbt.hostdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{AppliedBlocks: []types.Block{b}})
+	if err := hdbt.hostdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the hostdb and replay the block, as the consensus set does on
+	// startup.
+	hdb, err := New(hdbt.cs, hdbt.gateway, hdbt.hostdb.persistDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{AppliedBlocks: []types.Block{b}})
+	id := hdb.mu.RLock()
+	entry, exists := hdb.allHosts[fakeAddr(1)]
+	hdb.mu.RUnlock(id)
+	if !exists {
+		t.Fatal("announced host was not restored")
+	}
+	if len(entry.announcements) != 1 {
+		t.Fatal("replayed announcement was recorded again:", entry.announcements)
+	}
+
+	hdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{RevertedBlocks: []types.Block{b}})
+	if _, err := hdb.Host(fakeAddr(1)); err != errUnknownHost {
+		t.Fatal("host was not removed after its announcement was reverted")
+	}
+}
+
+// mineAnnouncement puts an encoded host announcement into a new block.
+func (hdbt *hdbTester) mineAnnouncement(announcement string) {
+	id, err := hdbt.wallet.RegisterTransaction(types.Transaction{})
+	if err != nil {
+		hdbt.t.Fatal(err)
+	}
+	if _, _, err = hdbt.wallet.AddArbitraryData(id, announcement); err != nil {
+		hdbt.t.Fatal(err)
+	}
+	txn, err := hdbt.wallet.SignTransaction(id, true)
+	if err != nil {
+		hdbt.t.Fatal(err)
+	}
+	if err = hdbt.tpool.AcceptTransaction(txn); err != nil {
+		hdbt.t.Fatal(err)
+	}
+	hdbt.tpUpdateWait()
+	b, _ := hdbt.miner.FindBlock()
+	if err = hdbt.cs.AcceptBlock(b); err != nil {
+		hdbt.t.Fatal(err)
+	}
+	hdbt.csUpdateWait()
+}
+
+// TestAnnouncementSquatting checks that a host whose address was announced
+// first by someone else's key takes over the entry by announcing itself and
+// proving its key, and that further announcements of its address by other keys
+// do not demote it.
+func TestAnnouncementSquatting(t *testing.T) {
+	hdbt := newHDBTester("TestAnnouncementSquatting", t)
+	addr := hdbt.host.Address()
+
+	// An attacker announces the host's address with their own key.
+	hdbt.mineAnnouncement(signedAnnouncement(t, addr))
+	entry, err := hdbt.hostdb.Host(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	squatterKey := entry.PublicKey
+	if squatterKey == hdbt.host.Settings().PublicKey {
+		t.Fatal("squatter announcement has the host's key")
+	}
+
+	// The host announces itself, and should become active under its own
+	// key.
+	if err := hdbt.host.ForceAnnounce(); err != nil {
+		t.Fatal(err)
+	}
+	hdbt.tpUpdateWait()
+	b, _ := hdbt.miner.FindBlock()
+	if err := hdbt.cs.AcceptBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	hdbt.csUpdateWait()
+	for i := 0; ; i++ {
+		entry, err = hdbt.hostdb.Host(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Active {
+			break
+		} else if i == 100 {
+			t.Fatal("announced host did not become active")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if entry.PublicKey != hdbt.host.Settings().PublicKey {
+		t.Fatal("host entry has the wrong key")
+	}
+
+	// The attacker announces the address again in every block. The host
+	// keeps proving its key, so it must stay active under that key, even
+	// after the new keys have been scanned.
+	for i := 0; i < 3; i++ {
+		hdbt.mineAnnouncement(signedAnnouncement(t, addr))
+		for j := 0; ; j++ {
+			status := hdbt.hostdb.ScanStatus()
+			if status.QueueDepth == 0 && status.ActiveScans == 0 {
+				break
+			} else if j == 100 {
+				t.Fatal("scans did not finish")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		entry, err = hdbt.hostdb.Host(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !entry.Active || entry.PublicKey != hdbt.host.Settings().PublicKey {
+			t.Fatal("squatting announcement demoted the host")
+		}
+	}
+
+	// If the host's announcement is reverted, its key is no longer announced,
+	// so the entry falls back to a squatter's key and is no longer active.
+	hdbt.hostdb.ReceiveConsensusSetUpdate(modules.ConsensusChange{RevertedBlocks: []types.Block{b}})
+	entry, err = hdbt.hostdb.Host(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.PublicKey == hdbt.host.Settings().PublicKey || entry.Active {
+		t.Fatal("reverting the host's announcement did not drop its key")
+	}
+}
@@ -19,9 +19,11 @@ var (
 
 // hostNode is the node of an unsorted, balanced, weighted binary tree. When
 // inserting elements, elements are inserted on the side of the tree with the
-// fewest elements. When removing, the node is just made empty but the tree is
-// not reorganized. The size of the tree will never decrease, but it will also
-// not increase unless it has more entries than it has ever had before.
+// fewest elements. When removing, the node is just made empty and is kept by
+// the hostdb to be refilled by the next insertion, so the tree is never
+// reorganized and its depth stays logarithmic in the largest number of hosts it
+// has ever held. Every operation on a node walks at most from the node to the
+// root.
 type hostNode struct {
 	parent *hostNode
 	count  int // Cumulative count of this node and  all children.
@@ -51,53 +53,63 @@ func createNode(parent *hostNode, entry *hostEntry) *hostNode {
 	}
 }
 
+// addWeight adds weight to the node and all of its ancestors.
+func (hn *hostNode) addWeight(weight types.Currency) {
+	for current := hn; current != nil; current = current.parent {
+		current.weight = current.weight.Add(weight)
+	}
+}
+
+// subWeight subtracts weight from the node and all of its ancestors.
+func (hn *hostNode) subWeight(weight types.Currency) {
+	for current := hn; current != nil; current = current.parent {
+		current.weight = current.weight.Sub(weight)
+	}
+}
+
 // nodeAtWeight grabs an element in the tree that appears at the given weight.
 // Though the tree has an arbitrary sorting, a sufficiently random weight will
 // pull a random element. The tree is searched through in a post-ordered way.
 func (hn *hostNode) nodeAtWeight(weight types.Currency) (*hostNode, error) {
 	// Sanity check - weight must be less than the total weight of the tree.
-	if weight.Cmp(hn.weight) > 0 {
+	if weight.Cmp(hn.weight) >= 0 {
 		return nil, ErrOverweight
 	}
 
-	// Check if the left or right child should be returned.
-	if hn.left != nil {
-		if weight.Cmp(hn.left.weight) < 0 {
-			return hn.left.nodeAtWeight(weight)
+	for {
+		// Check if the left or right child should be searched.
+		if hn.left != nil {
+			if weight.Cmp(hn.left.weight) < 0 {
+				hn = hn.left
+				continue
+			}
+			weight = weight.Sub(hn.left.weight) // Search from 0th index of right side.
+		}
+		if hn.right != nil && weight.Cmp(hn.right.weight) < 0 {
+			hn = hn.right
+			continue
 		}
-		weight = weight.Sub(hn.left.weight) // Search from 0th index of right side.
-	}
-	if hn.right != nil && weight.Cmp(hn.right.weight) < 0 {
-		return hn.right.nodeAtWeight(weight)
-	}
 
-	// Sanity check
-	if build.DEBUG {
-		if !hn.taken {
-			panic("should not be returning a nil entry")
+		// Sanity check
+		if build.DEBUG {
+			if !hn.taken {
+				panic("should not be returning a nil entry")
+			}
 		}
-	}
 
-	// Return the root entry.
-	return hn, nil
+		// Return the root entry.
+		return hn, nil
+	}
 }
 
-// recursiveInsert is a recurisve function for adding a hostNode to an existing tree
-// of hostNodes. The first call should always be on hostdb.hostTree. Running
-// time of recursiveInsert is log(n) in the maximum number of elements that have
-// ever been in the tree.
+// recursiveInsert is a recurisve function for adding a hostNode to an existing
+// tree of hostNodes. The first call should always be on hostdb.hostTree, and
+// only when there are no empty nodes to refill. Running time of
+// recursiveInsert is log(n) in the maximum number of elements that have ever
+// been in the tree.
 func (hn *hostNode) recursiveInsert(entry *hostEntry) (nodesAdded int, newNode *hostNode) {
 	hn.weight = hn.weight.Add(entry.weight)
 
-	// If the current node is empty, add the entry but don't increase the
-	// count.
-	if !hn.taken {
-		hn.taken = true
-		hn.hostEntry = entry
-		newNode = hn
-		return
-	}
-
 	// Insert the element into the lest populated side.
 	if hn.left == nil {
 		hn.left = createNode(hn, entry)
@@ -122,42 +134,64 @@ func (hn *hostNode) recursiveInsert(entry *hostEntry) (nodesAdded int, newNode *
 // with 0 weight will never be selected, they are accetped into the tree.
 func (hdb *HostDB) insertNode(entry *hostEntry) {
 	// If there's already a host of the same id, remove that host.
-	priorEntry, exists := hdb.activeHosts[entry.IPAddress]
+	priorNode, exists := hdb.activeHosts[entry.IPAddress]
 	if exists {
-		priorEntry.removeNode()
+		hdb.removeNode(priorNode)
 	}
 
-	// Insert the updated entry into the host tree.
-	if hdb.hostTree == nil {
-		hdb.hostTree = createNode(nil, entry)
-		hdb.activeHosts[entry.IPAddress] = hdb.hostTree
+	// Refill an empty node if there is one, otherwise grow the tree.
+	var node *hostNode
+	if n := len(hdb.emptyNodes); n > 0 {
+		node = hdb.emptyNodes[n-1]
+		hdb.emptyNodes = hdb.emptyNodes[:n-1]
+		node.taken = true
+		node.hostEntry = entry
+		node.addWeight(entry.weight)
+	} else if hdb.hostTree == nil {
+		node = createNode(nil, entry)
+		hdb.hostTree = node
 	} else {
-		_, hostNode := hdb.hostTree.recursiveInsert(entry)
-		hdb.activeHosts[entry.IPAddress] = hostNode
+		_, node = hdb.hostTree.recursiveInsert(entry)
 	}
+	hdb.activeHosts[entry.IPAddress] = node
+}
+
+// removeNode empties a node of the host tree, removing its host from the set
+// of active hosts. The node is kept to be refilled by a later insertion.
+func (hdb *HostDB) removeNode(node *hostNode) {
+	node.subWeight(node.hostEntry.weight)
+	node.taken = false
+	delete(hdb.activeHosts, node.hostEntry.IPAddress)
+	node.hostEntry = nil
+	hdb.emptyNodes = append(hdb.emptyNodes, node)
 }
 
-// remove takes a node and removes it from the tree by climbing through the
-// list of parents. remove does not delete nodes.
-func (hn *hostNode) removeNode() {
-	hn.weight = hn.weight.Sub(hn.hostEntry.weight)
-	hn.taken = false
-	current := hn.parent
-	for current != nil {
-		current.weight = current.weight.Sub(hn.hostEntry.weight)
-		current = current.parent
+// setWeight changes the weight of a host. If the host is active, its node is
+// updated in place.
+func (hdb *HostDB) setWeight(entry *hostEntry, weight types.Currency) {
+	node, exists := hdb.activeHosts[entry.IPAddress]
+	if exists && node.hostEntry == entry {
+		node.subWeight(entry.weight)
+		node.addWeight(weight)
 	}
+	entry.weight = weight
 }
 
 // RandomHosts will pull up to 'num' random hosts from the hostdb. There will
 // be no repeats, but the length of the slice returned may be less than 'num',
 // and may even be 0. The hosts that get returned first have the higher
-// priority.
-func (hdb *HostDB) RandomHosts(count int) (hosts []modules.HostSettings) {
+// priority. Hosts excluded by the filter, or that would violate the
+// constraints, are drawn but not returned.
+//
+// Each drawn host has its weight taken out of the tree so that it cannot be
+// drawn again, and put back once the selection is complete, so drawing k hosts
+// takes O(k log n) time.
+func (hdb *HostDB) RandomHosts(count int, constraints modules.HostSelectionConstraints) (hosts []modules.HostSettings) {
 	id := hdb.mu.Lock()
 	defer hdb.mu.Unlock(id)
 
-	var removedEntries []*hostEntry
+	groups := newSelectionGroups(constraints)
+	var drawnNodes []*hostNode
 	for len(hosts) < count {
 		if hdb.hostTree == nil || hdb.hostTree.weight.IsZero() {
 			break
@@ -170,18 +204,20 @@ func (hdb *HostDB) RandomHosts(count int) (hosts []modules.HostSettings) {
 		if err != nil {
 			break
 		}
-		hosts = append(hosts, node.hostEntry.HostSettings)
-		node.removeNode()
-		delete(hdb.activeHosts, node.hostEntry.IPAddress)
+		if hdb.allowed(node.hostEntry) && groups.allowed(node.hostEntry.IPAddress) {
+			hosts = append(hosts, node.hostEntry.HostSettings)
+			groups.take(node.hostEntry.IPAddress)
+		}
 
-		// remove the entry from the hostdb so it won't be selected as a
-		// repeat.
-		removedEntries = append(removedEntries, node.hostEntry)
+		// Take the weight of the node out of the tree so that it won't be
+		// selected as a repeat.
+		node.subWeight(node.hostEntry.weight)
+		drawnNodes = append(drawnNodes, node)
 	}
 
-	// Add back all of the entries that got removed.
-	for _, entry := range removedEntries {
-		hdb.insertNode(entry)
+	// Put back the weight of every node that was drawn.
+	for _, node := range drawnNodes {
+		node.addWeight(node.hostEntry.weight)
 	}
 	return hosts
 }
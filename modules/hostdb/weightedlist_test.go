@@ -3,11 +3,13 @@ package hostdb
 import (
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"math/big"
 	"strconv"
 	"testing"
 
 	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/sync"
 	"github.com/NebulousLabs/Sia/types"
 )
 
@@ -22,7 +24,12 @@ func fakeAddr(n uint8) modules.NetAddress {
 // every entropy has the same weight.
 func (hdbt *hdbTester) uniformTreeVerification(numEntries int) error {
 	// Check that the weight of the hostTree is what is expected.
-	expectedWeight := types.NewCurrency64(uint64(numEntries)).Mul(hdbt.hostdb.hostTree.hostEntry.weight)
+	var entryWeight types.Currency
+	for _, node := range hdbt.hostdb.activeHosts {
+		entryWeight = node.hostEntry.weight
+		break
+	}
+	expectedWeight := types.NewCurrency64(uint64(numEntries)).Mul(entryWeight)
 	if hdbt.hostdb.hostTree.weight.Cmp(expectedWeight) != 0 {
 		return errors.New("expected weight is incorrect")
 	}
@@ -42,7 +49,7 @@ func (hdbt *hdbTester) uniformTreeVerification(numEntries int) error {
 		selectionMap := make(map[modules.NetAddress]int)
 		expected := 100
 		for i := 0; i < expected*numEntries; i++ {
-			entries := hdbt.hostdb.RandomHosts(1)
+			entries := hdbt.hostdb.RandomHosts(1, modules.HostSelectionConstraints{})
 			if len(entries) == 0 {
 				return errors.New("no hosts!")
 			}
@@ -73,12 +80,10 @@ func (hdbt *hdbTester) uniformTreeVerification(numEntries int) error {
 		if err != nil {
 			break
 		}
-		node.removeNode()
-		delete(hdbt.hostdb.activeHosts, node.hostEntry.IPAddress)
-
 		// remove the entry from the hostdb so it won't be selected as a
 		// repeat.
 		removedEntries = append(removedEntries, node.hostEntry)
+		hdbt.hostdb.removeNode(node)
 	}
 	for _, entry := range removedEntries {
 		hdbt.hostdb.insertNode(entry)
@@ -177,7 +182,7 @@ func TestVariedWeights(t *testing.T) {
 	// time.
 	selectionMap := make(map[string]int)
 	for i := 0; i < selections; i++ {
-		randEntry := hdbt.hostdb.RandomHosts(1)
+		randEntry := hdbt.hostdb.RandomHosts(1, modules.HostSelectionConstraints{})
 		if len(randEntry) == 0 {
 			t.Fatal("no hosts!")
 		}
@@ -254,7 +259,7 @@ func TestRandomHosts(t *testing.T) {
 	}
 
 	// Grab 1 random host.
-	randHosts := hdbt.hostdb.RandomHosts(1)
+	randHosts := hdbt.hostdb.RandomHosts(1, modules.HostSelectionConstraints{})
 	if len(randHosts) != 1 {
 		t.Error("didn't get 1 hosts")
 	}
@@ -266,7 +271,7 @@ func TestRandomHosts(t *testing.T) {
 	}
 
 	// Grab 2 random hosts.
-	randHosts = hdbt.hostdb.RandomHosts(2)
+	randHosts = hdbt.hostdb.RandomHosts(2, modules.HostSelectionConstraints{})
 	if len(randHosts) != 2 {
 		t.Error("didn't get 2 hosts")
 	}
@@ -281,7 +286,7 @@ func TestRandomHosts(t *testing.T) {
 	}
 
 	// Grab 3 random hosts.
-	randHosts = hdbt.hostdb.RandomHosts(3)
+	randHosts = hdbt.hostdb.RandomHosts(3, modules.HostSelectionConstraints{})
 	if len(randHosts) != 3 {
 		t.Error("didn't get 3 hosts")
 	}
@@ -296,7 +301,7 @@ func TestRandomHosts(t *testing.T) {
 	}
 
 	// Grab 4 random hosts. 3 should be returned.
-	randHosts = hdbt.hostdb.RandomHosts(4)
+	randHosts = hdbt.hostdb.RandomHosts(4, modules.HostSelectionConstraints{})
 	if len(randHosts) != 3 {
 		t.Error("didn't get 3 hosts")
 	}
@@ -310,3 +315,144 @@ func TestRandomHosts(t *testing.T) {
 		t.Error("doubled up")
 	}
 }
+
+// benchmarkAddr returns a distinct address for each i.
+func benchmarkAddr(i int) modules.NetAddress {
+	return modules.NetAddress(fmt.Sprintf("10.%d.%d.%d:9982", i>>16&255, i>>8&255, i&255))
+}
+
+// newTreeHostDB returns a hostdb, without any of its dependencies, holding n
+// active hosts of varied weight.
+func newTreeHostDB(n int) *HostDB {
+	hdb := &HostDB{
+		activeHosts: make(map[modules.NetAddress]*hostNode),
+		allHosts:    make(map[modules.NetAddress]*hostEntry),
+		mu:          sync.New(modules.SafeMutexDelay, 1),
+	}
+	for i := 0; i < n; i++ {
+		entry := &hostEntry{
+			HostSettings: modules.HostSettings{IPAddress: benchmarkAddr(i)},
+			weight:       types.NewCurrency64(uint64(i%1000 + 1)),
+		}
+		hdb.allHosts[entry.IPAddress] = entry
+		hdb.insertNode(entry)
+	}
+	return hdb
+}
+
+// depth returns the depth of the tree below a node.
+func (hn *hostNode) depth() int {
+	if hn == nil {
+		return 0
+	}
+	left, right := hn.left.depth(), hn.right.depth()
+	if left > right {
+		return left + 1
+	}
+	return right + 1
+}
+
+// TestTreeScaling checks that the tree stays shallow and does not grow when
+// hosts are removed and inserted, and that weights are updated in place.
+func TestTreeScaling(t *testing.T) {
+	const n = 1 << 14
+	hdb := newTreeHostDB(n)
+	if d := hdb.hostTree.depth(); d > 15 {
+		t.Fatal("tree of", n, "hosts is too deep:", d)
+	}
+
+	// Remove and reinsert half of the hosts. The removed nodes should be
+	// refilled rather than new nodes being added.
+	for i := 0; i < n; i += 2 {
+		hdb.removeNode(hdb.activeHosts[benchmarkAddr(i)])
+	}
+	for i := 0; i < n; i += 2 {
+		hdb.insertNode(hdb.allHosts[benchmarkAddr(i)])
+	}
+	if hdb.hostTree.count != n || len(hdb.emptyNodes) != 0 {
+		t.Error("tree grew after hosts were reinserted:", hdb.hostTree.count)
+	}
+
+	// Update a weight in place and check the total weight of the tree.
+	total := hdb.hostTree.weight
+	entry := hdb.allHosts[benchmarkAddr(7)]
+	node := hdb.activeHosts[entry.IPAddress]
+	hdb.setWeight(entry, entry.weight.Add(types.NewCurrency64(5)))
+	if hdb.hostTree.weight.Cmp(total.Add(types.NewCurrency64(5))) != 0 {
+		t.Error("weight update did not reach the root")
+	}
+	if hdb.activeHosts[entry.IPAddress] != node {
+		t.Error("weight update moved the host")
+	}
+
+	// Sampling without replacement leaves the tree as it was.
+	total = hdb.hostTree.weight
+	hosts := hdb.RandomHosts(100, modules.HostSelectionConstraints{})
+	if len(hosts) != 100 {
+		t.Fatal("wrong number of hosts:", len(hosts))
+	}
+	seen := make(map[modules.NetAddress]struct{})
+	for _, host := range hosts {
+		if _, exists := seen[host.IPAddress]; exists {
+			t.Fatal("host selected twice")
+		}
+		seen[host.IPAddress] = struct{}{}
+	}
+	if hdb.hostTree.weight.Cmp(total) != 0 || len(hdb.activeHosts) != n {
+		t.Error("RandomHosts did not restore the tree")
+	}
+}
+
+// BenchmarkInsertNode benchmarks inserting hosts into the tree.
+func BenchmarkInsertNode(b *testing.B) {
+	hdb := newTreeHostDB(0)
+	entries := make([]*hostEntry, b.N)
+	for i := range entries {
+		entries[i] = &hostEntry{
+			HostSettings: modules.HostSettings{IPAddress: benchmarkAddr(i)},
+			weight:       types.NewCurrency64(uint64(i%1000 + 1)),
+		}
+	}
+	b.ResetTimer()
+	for _, entry := range entries {
+		hdb.insertNode(entry)
+	}
+}
+
+// BenchmarkRemoveInsert50k benchmarks removing a host from a tree of 50,000
+// hosts and inserting it again.
+func BenchmarkRemoveInsert50k(b *testing.B) {
+	const n = 50e3
+	hdb := newTreeHostDB(n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entry := hdb.allHosts[benchmarkAddr(i%n)]
+		hdb.removeNode(hdb.activeHosts[entry.IPAddress])
+		hdb.insertNode(entry)
+	}
+}
+
+// BenchmarkSetWeight50k benchmarks updating the weight of a host in a tree of
+// 50,000 hosts.
+func BenchmarkSetWeight50k(b *testing.B) {
+	const n = 50e3
+	hdb := newTreeHostDB(n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entry := hdb.allHosts[benchmarkAddr(i%n)]
+		hdb.setWeight(entry, types.NewCurrency64(uint64(i%1000+1)))
+	}
+}
+
+// benchmarkRandomHosts benchmarks drawing k hosts from a tree of n hosts.
+func benchmarkRandomHosts(b *testing.B, n, k int) {
+	hdb := newTreeHostDB(n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hdb.RandomHosts(k, modules.HostSelectionConstraints{})
+	}
+}
+
+func BenchmarkRandomHosts1k(b *testing.B)   { benchmarkRandomHosts(b, 1e3, 24) }
+func BenchmarkRandomHosts50k(b *testing.B)  { benchmarkRandomHosts(b, 50e3, 24) }
+func BenchmarkRandomHosts50k1(b *testing.B) { benchmarkRandomHosts(b, 50e3, 1) }
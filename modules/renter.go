@@ -71,6 +71,18 @@ type DownloadInfo interface {
 	Nickname() string
 }
 
+// A RenterContract is a file contract that the renter has formed with a host
+// to store a piece of a file.
+type RenterContract struct {
+	ID          types.FileContractID
+	HostIP      NetAddress
+	Nickname    string
+	FileSize    uint64
+	WindowStart types.BlockHeight
+	WindowEnd   types.BlockHeight
+	Active      bool
+}
+
 // RentInfo contains a list of all files by nickname. (deprecated)
 type RentInfo struct {
 	Files      []string
@@ -81,6 +93,10 @@ type RentInfo struct {
 // A Renter uploads, tracks, repairs, and downloads a set of files for the
 // user.
 type Renter interface {
+	// Contracts returns the file contracts that the renter has formed with
+	// hosts.
+	Contracts() []RenterContract
+
 	// DeleteFile deletes a file entry from the renter.
 	DeleteFile(nickname string) error
 
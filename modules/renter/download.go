@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	"errors"
 	"io"
-	"net"
 	"os"
 	"sync/atomic"
 	"time"
@@ -76,35 +75,43 @@ func (d *Download) Write(b []byte) (int, error) {
 
 // downloadPiece attempts to retrieve a file piece from a host.
 func (d *Download) downloadPiece(piece filePiece) error {
-	conn, err := net.DialTimeout("tcp", string(piece.HostIP), 10e9)
+	conn, err := modules.Dial(piece.HostIP, 10e9)
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureDial, err)
 	}
 	defer conn.Close()
 	err = encoding.WriteObject(conn, [8]byte{'R', 'e', 't', 'r', 'i', 'e', 'v', 'e'})
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
+	}
+	err = modules.VerifyHostIdentity(conn, piece.HostPublicKey)
+	if err != nil {
+		return hostErr(modules.HostFailureIdentity, err)
 	}
 
 	// Send the ID of the contract for the file piece we're requesting.
 	if err := encoding.WriteObject(conn, piece.ContractID); err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
 	}
 
 	// Simultaneously download, decrypt, and calculate the Merkle root of the file.
+	hc := &hostConn{rw: conn}
 	tee := io.TeeReader(
 		// Use a LimitedReader to ensure we don't read indefinitely.
-		io.LimitReader(conn, int64(piece.Contract.FileSize)),
+		io.LimitReader(hc, int64(piece.Contract.FileSize)),
 		// Write the decrypted bytes to the file.
 		piece.EncryptionKey.NewWriter(d),
 	)
 	merkleRoot, err := crypto.ReaderMerkleRoot(tee)
-	if err != nil {
+	if hc.err != nil {
+		return hostErr(modules.HostFailureProtocol, err)
+	} else if err != nil {
+		// Writing to the destination failed; this is not the host's fault.
 		return err
 	}
 
 	if merkleRoot != piece.Contract.FileMerkleRoot {
-		return errors.New("host provided a file that's invalid")
+		return hostErr(modules.HostFailureCorrupt, errors.New("host provided a file that's invalid"))
 	}
 
 	return nil
@@ -167,7 +174,9 @@ func (r *Renter) Download(nickname, destination string) error {
 	// until a download succeeds.
 	for i := 0; i < downloadAttempts; i++ {
 		for _, piece := range d.pieces {
+			start := time.Now()
 			downloadErr := d.downloadPiece(piece)
+			r.reportInteraction(piece.HostIP, modules.HostInteractionDownload, start, piece.Contract.FileSize, downloadErr)
 			if downloadErr == nil {
 				// done
 				d.complete = true
@@ -50,9 +50,10 @@ type filePiece struct {
 	Contract   types.FileContract   // The contract being enforced.
 	ContractID types.FileContractID // The ID of the contract.
 
-	HostIP     modules.NetAddress // Where to find the file piece.
-	StartIndex uint64
-	EndIndex   uint64
+	HostIP        modules.NetAddress // Where to find the file piece.
+	HostPublicKey crypto.PublicKey   // Authenticates the host holding the file piece.
+	StartIndex    uint64
+	EndIndex      uint64
 
 	PieceSize uint64
 
@@ -180,6 +181,32 @@ func (r *Renter) FileList() (files []modules.FileInfo) {
 	return
 }
 
+// Contracts returns the file contracts that the renter has formed with hosts.
+func (r *Renter) Contracts() (contracts []modules.RenterContract) {
+	lockID := r.mu.RLock()
+	defer r.mu.RUnlock(lockID)
+
+	for _, f := range r.files {
+		for _, piece := range f.Pieces {
+			// Pieces that are still being uploaded for the first time do not
+			// have a contract yet.
+			if piece.ContractID == (types.FileContractID{}) {
+				continue
+			}
+			contracts = append(contracts, modules.RenterContract{
+				ID:          piece.ContractID,
+				HostIP:      piece.HostIP,
+				Nickname:    f.Name,
+				FileSize:    piece.Contract.FileSize,
+				WindowStart: piece.Contract.WindowStart,
+				WindowEnd:   piece.Contract.WindowEnd,
+				Active:      piece.Active,
+			})
+		}
+	}
+	return
+}
+
 // RenameFile takes an existing file and changes the nickname. The original
 // file must exist, and there must not be any file that already has the
 // replacement nickname.
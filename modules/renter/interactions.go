@@ -0,0 +1,77 @@
+package renter
+
+// interactions.go reports the outcome of every upload and download to the
+// hostdb, so that hosts which fail or transfer slowly are selected less often.
+
+import (
+	"io"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// A hostError is an error that was caused by a host, as opposed to an error in
+// the renter such as a missing file or an empty wallet.
+type hostError struct {
+	reason string
+	err    error
+}
+
+// Error implements the error interface.
+func (he hostError) Error() string {
+	return he.err.Error()
+}
+
+// hostErr marks an error as being caused by a host. nil is returned if err is
+// nil.
+func hostErr(reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return hostError{reason, err}
+}
+
+// A hostConn wraps a connection to a host and records the first error it
+// returns. This tells failures of the connection apart from failures of local
+// files when both are read and written in the same call.
+type hostConn struct {
+	rw  io.ReadWriter
+	err error
+}
+
+// Read implements the io.Reader interface.
+func (hc *hostConn) Read(b []byte) (int, error) {
+	n, err := hc.rw.Read(b)
+	if err != nil && err != io.EOF && hc.err == nil {
+		hc.err = err
+	}
+	return n, err
+}
+
+// Write implements the io.Writer interface.
+func (hc *hostConn) Write(b []byte) (int, error) {
+	n, err := hc.rw.Write(b)
+	if err != nil && hc.err == nil {
+		hc.err = err
+	}
+	return n, err
+}
+
+// reportInteraction reports an interaction with a host to the hostdb. Errors
+// that were not caused by the host are not reported.
+func (r *Renter) reportInteraction(addr modules.NetAddress, kind string, start time.Time, bytes uint64, err error) {
+	hi := modules.HostInteraction{
+		Timestamp: start,
+		Type:      kind,
+		Success:   err == nil,
+	}
+	if err == nil {
+		hi.Bytes = bytes
+		hi.Duration = time.Since(start)
+	} else if he, ok := err.(hostError); ok {
+		hi.FailureReason = he.reason
+	} else {
+		return
+	}
+	r.hostDB.RecordInteraction(addr, hi)
+}
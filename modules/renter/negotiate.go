@@ -3,7 +3,6 @@ package renter
 import (
 	"errors"
 	"io"
-	"net"
 	"os"
 	"sync/atomic"
 	"time"
@@ -75,8 +74,8 @@ func (uw *uploadWriter) Write(b []byte) (int, error) {
 
 // negotiateContract creates a file contract for a host according to the
 // requests of the host. There is an assumption that only hosts with acceptable
-// terms will be put into the hostdb.
-func (r *Renter) negotiateContract(host modules.HostSettings, up modules.FileUploadParams, piece *filePiece) error {
+// terms will be put into the hostdb. The outcome is reported to the hostdb.
+func (r *Renter) negotiateContract(host modules.HostSettings, up modules.FileUploadParams, piece *filePiece) (err error) {
 	lockID := r.mu.RLock()
 	height := r.blockHeight
 	r.mu.RUnlock(lockID)
@@ -132,38 +131,50 @@ func (r *Renter) negotiateContract(host modules.HostSettings, up modules.FileUpl
 	time.Sleep(types.RenterZeroConfDelay)
 
 	// Perform the negotiations with the host through a network call.
-	conn, err := net.DialTimeout("tcp", string(host.IPAddress), 10e9)
+	start := time.Now()
+	defer func() {
+		r.reportInteraction(host.IPAddress, modules.HostInteractionUpload, start, filesize, err)
+	}()
+	conn, err := modules.Dial(host.IPAddress, 10e9)
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureDial, err)
 	}
 	defer conn.Close()
 	err = encoding.WriteObject(conn, [8]byte{'C', 'o', 'n', 't', 'r', 'a', 'c', 't'})
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
+	}
+	err = modules.VerifyHostIdentity(conn, host.PublicKey)
+	if err != nil {
+		return hostErr(modules.HostFailureIdentity, err)
 	}
 
 	// Send the contract terms and read the response.
 	if err = encoding.WriteObject(conn, terms); err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
 	}
 	var response string
 	if err = encoding.ReadObject(conn, &response, 128); err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
 	}
 	if response != modules.AcceptTermsResponse {
-		return errors.New(response)
+		return hostErr(modules.HostFailureRejected, errors.New(response))
 	}
 
 	// Encrypt and transmit the file data while calculating its Merkle root.
+	hc := &hostConn{rw: conn}
 	tee := io.TeeReader(
 		// wrap file reader in encryption layer
 		key.NewReader(file),
 		// each byte we read from tee will also be written to conn;
 		// the uploadWriter updates the piece's 'Transferred' field
-		&uploadWriter{piece, conn},
+		&uploadWriter{piece, hc},
 	)
 	merkleRoot, err := crypto.ReaderMerkleRoot(tee)
-	if err != nil {
+	if hc.err != nil {
+		return hostErr(modules.HostFailureProtocol, err)
+	} else if err != nil {
+		// Reading the file failed; this is not the host's fault.
 		return err
 	}
 
@@ -179,7 +190,7 @@ func (r *Renter) negotiateContract(host modules.HostSettings, up modules.FileUpl
 	// Send the unsigned transaction to the host.
 	err = encoding.WriteObject(conn, unsignedTxn)
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
 	}
 
 	// The host will respond with a transaction with the collateral added.
@@ -188,7 +199,7 @@ func (r *Renter) negotiateContract(host modules.HostSettings, up modules.FileUpl
 	var collateralTxn types.Transaction
 	err = encoding.ReadObject(conn, &collateralTxn, 16e3)
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
 	}
 	for i := len(unsignedTxn.SiacoinInputs); i < len(collateralTxn.SiacoinInputs); i++ {
 		_, _, err = r.wallet.AddSiacoinInput(txnRef, collateralTxn.SiacoinInputs[i])
@@ -204,17 +215,17 @@ func (r *Renter) negotiateContract(host modules.HostSettings, up modules.FileUpl
 	// Send the signed transaction back to the host.
 	err = encoding.WriteObject(conn, signedTxn)
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
 	}
 
 	// Read an ack from the host that all is well.
 	var ack bool
 	err = encoding.ReadObject(conn, &ack, 1)
 	if err != nil {
-		return err
+		return hostErr(modules.HostFailureProtocol, err)
 	}
 	if !ack {
-		return errors.New("host negotiation failed")
+		return hostErr(modules.HostFailureRejected, errors.New("host negotiation failed"))
 	}
 
 	// TODO: We don't actually watch the blockchain to make sure that the
@@ -227,6 +238,7 @@ func (r *Renter) negotiateContract(host modules.HostSettings, up modules.FileUpl
 	piece.Contract = signedTxn.FileContracts[0]
 	piece.ContractID = signedTxn.FileContractID(0)
 	piece.HostIP = host.IPAddress
+	piece.HostPublicKey = host.PublicKey
 	piece.EncryptionKey = key
 	r.save()
 	r.mu.Unlock(lockID)
@@ -94,7 +94,7 @@ func (r *Renter) Info() (ri modules.RentInfo) {
 	// Calculate the average cost of a file.
 	var totalPrice types.Currency
 	sampleSize := redundancy * 3 / 2
-	hosts := r.hostDB.RandomHosts(sampleSize)
+	hosts := r.hostDB.RandomHosts(sampleSize, modules.HostSelectionConstraints{})
 	for _, host := range hosts {
 		totalPrice = totalPrice.Add(host.Price)
 	}
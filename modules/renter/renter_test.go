@@ -70,7 +70,7 @@ func newRenterTester(name string, t *testing.T) *renterTester {
 	}
 
 	// Create the hostdb.
-	hdb, err := hostdb.New(cs, g)
+	hdb, err := hostdb.New(cs, g, filepath.Join(testdir, modules.HostDBDir))
 	if err != nil {
 		t.Fatal(err)
 	}
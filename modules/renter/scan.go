@@ -1,13 +1,22 @@
 package renter
 
 // scanAllFiles checks all files for pieces that are not yet active and then
-// uploads them to the network.
+// uploads them to the network. Replacement hosts are kept out of the subnets
+// of the hosts already holding pieces of the same file.
 func (r *Renter) scanAllFiles() {
 	for _, file := range r.files {
+		constraints := uploadConstraints
+		constraints.InUse = nil
+		for _, piece := range file.Pieces {
+			if piece.Active || piece.Repairing {
+				constraints.InUse = append(constraints.InUse, piece.HostIP)
+			}
+		}
 		for i := range file.Pieces {
 			if !file.Pieces[i].Active && !file.Pieces[i].Repairing {
-				hosts := r.hostDB.RandomHosts(1)
+				hosts := r.hostDB.RandomHosts(1, constraints)
 				if len(hosts) == 1 {
+					constraints.InUse = append(constraints.InUse, hosts[0].IPAddress)
 					go r.threadedUploadPiece(hosts[0], file.UploadParams, &file.Pieces[i])
 				}
 			}
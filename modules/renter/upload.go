@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
 )
@@ -20,6 +21,19 @@ var (
 	errUploadFailed = errors.New("failed to upload to the desired host")
 
 	redundancy = 8
+
+	// uploadConstraints keep the pieces of a file on hosts in different
+	// subnets, so that a single operator cannot hold every copy. Test and dev
+	// networks run all of their hosts on one machine, and are left
+	// unconstrained.
+	uploadConstraints = func() modules.HostSelectionConstraints {
+		switch build.Release {
+		case "dev", "testing":
+			return modules.HostSelectionConstraints{}
+		default:
+			return modules.HostSelectionConstraints{IPv4SubnetBits: 24, IPv6SubnetBits: 48}
+		}
+	}()
 )
 
 // checkWalletBalance looks at an upload and determines if there is enough
@@ -35,7 +49,7 @@ func (r *Renter) checkWalletBalance(up modules.FileUploadParams) error {
 
 	var averagePrice types.Currency
 	sampleSize := redundancy * 3 / 2
-	hosts := r.hostDB.RandomHosts(sampleSize)
+	hosts := r.hostDB.RandomHosts(sampleSize, modules.HostSelectionConstraints{})
 	for _, host := range hosts {
 		averagePrice = averagePrice.Add(host.Price)
 	}
@@ -154,7 +168,7 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 	// hosts and file pieces, and spawn goroutines that attempt to match each
 	// piece to a host.
 	hostPool := make(chan modules.HostSettings, 3*redundancy)
-	for _, host := range r.hostDB.RandomHosts(3 * redundancy) {
+	for _, host := range r.hostDB.RandomHosts(3*redundancy, uploadConstraints) {
 		hostPool <- host
 	}
 	piecePool := make(chan *filePiece, len(f.Pieces))
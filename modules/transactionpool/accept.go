@@ -260,7 +260,7 @@ func (tp *TransactionPool) AcceptTransaction(t types.Transaction) (err error) {
 	// the transaction.
 	tp.addTransactionToPool(t)
 	tp.updateSubscribers(modules.ConsensusChange{}, tp.transactionList, tp.unconfirmedSiacoinOutputDiffs())
-	go tp.gateway.Broadcast("RelayTransaction", t)
+	go tp.gateway.Relay("RelayTransaction", txnHash, t)
 	return
 }
 
@@ -280,3 +280,24 @@ func (tp *TransactionPool) RelayTransaction(conn modules.PeerConn) error {
 	}
 	return err
 }
+
+// hasTransaction reports whether the transaction with the given hash is in
+// the pool. It is used by the gateway to decide whether to request relayed
+// transactions.
+func (tp *TransactionPool) hasTransaction(txnHash crypto.Hash) bool {
+	id := tp.mu.RLock()
+	defer tp.mu.RUnlock(id)
+	_, exists := tp.transactions[txnHash]
+	return exists
+}
+
+// relayedTransactionHash returns the hash of an encoded transaction. It is
+// used by the gateway to check that a relayed transaction is the one that was
+// announced.
+func relayedTransactionHash(enc []byte) (crypto.Hash, error) {
+	var t types.Transaction
+	if err := encoding.Unmarshal(enc, &t); err != nil {
+		return crypto.Hash{}, err
+	}
+	return crypto.HashObject(t), nil
+}
@@ -120,6 +120,7 @@ func New(cs modules.ConsensusSet, g modules.Gateway) (tp *TransactionPool, err e
 
 	// Register RPCs
 	g.RegisterRPC("RelayTransaction", tp.RelayTransaction)
+	g.RegisterRelay("RelayTransaction", tp.hasTransaction, relayedTransactionHash)
 
 	// Subscribe the transaction pool to the consensus set.
 	cs.ConsensusSetSubscribe(tp)
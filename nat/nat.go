@@ -0,0 +1,183 @@
+// Package nat forwards ports on the router that a node is behind, and
+// discovers the node's external IP address. Routers are controlled with
+// either UPnP or NAT-PMP, whichever responds first.
+package nat
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// discoverTimeout is the amount of time spent searching for a router.
+	discoverTimeout = 3 * time.Second
+
+	// mappingLifetime is the lifetime requested for port mappings. Mappings
+	// are refreshed halfway through their lifetime, so that a mapping expires
+	// shortly after the node shuts down without removing it.
+	mappingLifetime = 20 * time.Minute
+)
+
+var (
+	errNoNAT = errors.New("no UPnP or NAT-PMP router found")
+
+	// privateNets are the address ranges that are not reachable from the
+	// internet.
+	privateNets = func() []*net.IPNet {
+		var nets []*net.IPNet
+		for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16", "fc00::/7", "fe80::/10"} {
+			_, n, _ := net.ParseCIDR(cidr)
+			nets = append(nets, n)
+		}
+		return nets
+	}()
+)
+
+// A NAT is a router that can report its external IP address and forward TCP
+// ports to this computer.
+type NAT interface {
+	// ExternalIP returns the router's external IP address.
+	ExternalIP() (net.IP, error)
+
+	// AddPortMapping forwards the external port to the same port on this
+	// computer for the given lifetime.
+	AddPortMapping(port uint16, description string, lifetime time.Duration) error
+
+	// DeletePortMapping removes a port mapping.
+	DeletePortMapping(port uint16) error
+}
+
+// Discover searches for a UPnP or NAT-PMP router, returning the first to
+// respond.
+func Discover() (NAT, error) {
+	found := make(chan NAT, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if n, err := discoverUPnP(ssdpAddr, discoverTimeout); err == nil {
+			found <- n
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if n, err := discoverNATPMP(); err == nil {
+			found <- n
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+	n, ok := <-found
+	if !ok {
+		return nil, errNoNAT
+	}
+	return n, nil
+}
+
+// IsPublic returns true if ip is reachable from the internet.
+func IsPublic(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// InterfaceIP returns a public IP address assigned to one of this computer's
+// network interfaces. Computers that are not behind a router, such as
+// servers, are reachable at this address.
+func InterfaceIP() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && IsPublic(ipnet.IP) {
+			return ipnet.IP, nil
+		}
+	}
+	return nil, errors.New("no network interface has a public address")
+}
+
+// A Forwarder keeps a port forwarded on a router until it is closed.
+type Forwarder struct {
+	nat         NAT
+	port        uint16
+	description string
+	lifetime    time.Duration
+	log         *log.Logger
+	closeChan   chan struct{}
+}
+
+// Forward discovers the router and forwards port to this computer,
+// refreshing the mapping until the Forwarder is closed. It also returns the
+// router's external IP address. Failures to refresh the mapping are written
+// to logger.
+func Forward(port uint16, description string, logger *log.Logger) (*Forwarder, net.IP, error) {
+	n, err := Discover()
+	if err != nil {
+		return nil, nil, err
+	}
+	return forward(n, port, description, mappingLifetime, logger)
+}
+
+// forward forwards port on n, refreshing the mapping every half lifetime.
+func forward(n NAT, port uint16, description string, lifetime time.Duration, logger *log.Logger) (*Forwarder, net.IP, error) {
+	ip, err := n.ExternalIP()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := n.AddPortMapping(port, description, lifetime); err != nil {
+		return nil, nil, err
+	}
+	f := &Forwarder{
+		nat:         n,
+		port:        port,
+		description: description,
+		lifetime:    lifetime,
+		log:         logger,
+		closeChan:   make(chan struct{}),
+	}
+	go f.threadedRefresh()
+	return f, ip, nil
+}
+
+// threadedRefresh renews the port mapping before it expires.
+func (f *Forwarder) threadedRefresh() {
+	for {
+		select {
+		case <-f.closeChan:
+			return
+		case <-time.After(f.lifetime / 2):
+		}
+		if err := f.nat.AddPortMapping(f.port, f.description, f.lifetime); err != nil {
+			f.log.Printf("WARN: could not refresh mapping for port %v: %v", f.port, err)
+		}
+	}
+}
+
+// Close stops refreshing the mapping and removes it from the router.
+func (f *Forwarder) Close() error {
+	close(f.closeChan)
+	return f.nat.DeletePortMapping(f.port)
+}
+
+// localIPFor returns the IP address that this computer uses to reach host.
+// Routers need it to know where to forward ports.
+func localIPFor(host string) (net.IP, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "1"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
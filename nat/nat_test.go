@@ -0,0 +1,120 @@
+package nat
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingNAT is a NAT that counts port mappings.
+type countingNAT struct {
+	adds    int
+	deleted bool
+	fail    bool
+	mu      sync.Mutex
+}
+
+func (cn *countingNAT) ExternalIP() (net.IP, error) { return net.IPv4(203, 0, 113, 1), nil }
+
+func (cn *countingNAT) AddPortMapping(uint16, string, time.Duration) error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.adds++
+	if cn.fail {
+		return errors.New("mapping failed")
+	}
+	return nil
+}
+
+func (cn *countingNAT) DeletePortMapping(uint16) error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.deleted = true
+	return nil
+}
+
+// syncBuffer is a bytes.Buffer that can be written to by a logger while it is
+// being read.
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+// TestForwarder checks that a Forwarder refreshes its mapping, logs refresh
+// errors, and removes the mapping when closed.
+func TestForwarder(t *testing.T) {
+	cn := new(countingNAT)
+	logs := new(syncBuffer)
+	f, ip, err := forward(cn, 9981, "Sia", 40*time.Millisecond, log.New(logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IPv4(203, 0, 113, 1)) {
+		t.Fatal("wrong external IP:", ip)
+	}
+	time.Sleep(70 * time.Millisecond)
+	cn.mu.Lock()
+	adds := cn.adds
+	cn.fail = true
+	cn.mu.Unlock()
+	if adds < 3 {
+		t.Fatal("mapping was not refreshed:", adds)
+	}
+	if logs.String() != "" {
+		t.Fatal("unexpected error:", logs.String())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if !strings.Contains(logs.String(), "mapping failed") {
+		t.Fatal("refresh error was not logged")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cn.mu.Lock()
+	deleted := cn.deleted
+	cn.mu.Unlock()
+	if !deleted {
+		t.Fatal("mapping was not deleted")
+	}
+}
+
+// TestIsPublic checks the classification of public addresses.
+func TestIsPublic(t *testing.T) {
+	cases := map[string]bool{
+		"203.0.113.7": true,
+		"8.8.8.8":     true,
+		"2001:db8::1": true,
+		"127.0.0.1":   false,
+		"::1":         false,
+		"10.1.2.3":    false,
+		"172.20.0.1":  false,
+		"192.168.1.1": false,
+		"100.64.0.1":  false,
+		"169.254.1.1": false,
+		"fd00::1":     false,
+		"0.0.0.0":     false,
+	}
+	for addr, public := range cases {
+		if IsPublic(net.ParseIP(addr)) != public {
+			t.Errorf("IsPublic(%v) should be %v", addr, public)
+		}
+	}
+}
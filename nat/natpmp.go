@@ -0,0 +1,154 @@
+package nat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// natpmpPort is the port that NAT-PMP routers listen on.
+	natpmpPort = 5351
+
+	// natpmpTries is the number of times a request is sent before giving up.
+	// The timeout doubles after each try, starting at natpmpInitialTimeout.
+	natpmpTries          = 4
+	natpmpInitialTimeout = 250 * time.Millisecond
+
+	// NAT-PMP opcodes. Responses have the high bit set.
+	natpmpOpExternalAddress = 0
+	natpmpOpMapTCP          = 2
+)
+
+var (
+	errNoGateway = errors.New("could not find default gateway")
+)
+
+// A natPMP is a router that supports NAT-PMP (RFC 6886).
+type natPMP struct {
+	gateway *net.UDPAddr
+}
+
+// discoverNATPMP returns the default gateway as a NAT-PMP router, if it
+// responds to NAT-PMP requests.
+func discoverNATPMP() (*natPMP, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	n := &natPMP{gateway: &net.UDPAddr{IP: gw, Port: natpmpPort}}
+	if _, err := n.ExternalIP(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// defaultGateway returns the IP of the default gateway, which is the router
+// that NAT-PMP requests are sent to. It is read from the kernel's routing
+// table, and is only supported on Linux.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, errNoGateway
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// Each line contains the interface, destination, and gateway, with
+		// addresses as little-endian hex. The default route has destination 0.
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(ip, uint32(gw))
+		return ip, nil
+	}
+	return nil, errNoGateway
+}
+
+// request sends a NAT-PMP request to the router and returns the response,
+// which must be at least minLen bytes.
+func (n *natPMP) request(req []byte, minLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, n.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp := make([]byte, 16)
+	timeout := natpmpInitialTimeout
+	for i := 0; i < natpmpTries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		timeout *= 2
+		num, err := conn.Read(resp)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		// The response opcode is the request opcode plus 128.
+		if num < minLen || resp[0] != 0 || resp[1] != req[1]+128 {
+			return nil, errors.New("router sent malformed NAT-PMP response")
+		}
+		if result := binary.BigEndian.Uint16(resp[2:4]); result != 0 {
+			return nil, errors.New("router returned NAT-PMP error " + strconv.Itoa(int(result)))
+		}
+		return resp[:num], nil
+	}
+	return nil, errors.New("router did not respond to NAT-PMP request")
+}
+
+// ExternalIP implements the NAT interface.
+func (n *natPMP) ExternalIP() (net.IP, error) {
+	resp, err := n.request([]byte{0, natpmpOpExternalAddress}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(append([]byte(nil), resp[8:12]...)), nil
+}
+
+// mapTCP requests a mapping for port with the given lifetime. A lifetime of
+// zero removes the mapping.
+func (n *natPMP) mapTCP(port uint16, lifetime time.Duration) error {
+	req := make([]byte, 12)
+	req[1] = natpmpOpMapTCP
+	binary.BigEndian.PutUint16(req[4:6], port)
+	binary.BigEndian.PutUint16(req[6:8], port)
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err := n.request(req, 16)
+	if err != nil {
+		return err
+	}
+	// The router may assign a different external port, which would make the
+	// address we advertise unreachable.
+	if lifetime != 0 && binary.BigEndian.Uint16(resp[10:12]) != port {
+		n.mapTCP(port, 0)
+		return errors.New("router could not forward port " + strconv.Itoa(int(port)))
+	}
+	return nil
+}
+
+// AddPortMapping implements the NAT interface. NAT-PMP mappings have no
+// description.
+func (n *natPMP) AddPortMapping(port uint16, _ string, lifetime time.Duration) error {
+	return n.mapTCP(port, lifetime)
+}
+
+// DeletePortMapping implements the NAT interface.
+func (n *natPMP) DeletePortMapping(port uint16) error {
+	return n.mapTCP(port, 0)
+}
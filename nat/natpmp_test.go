@@ -0,0 +1,128 @@
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeNATPMP is a NAT-PMP router on the loopback interface.
+type fakeNATPMP struct {
+	conn     *net.UDPConn
+	mappings map[uint16]uint32 // port -> lifetime
+	mu       sync.Mutex
+}
+
+// newFakeNATPMP starts a fake NAT-PMP router.
+func newFakeNATPMP(t *testing.T) *fakeNATPMP {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeNATPMP{conn: conn, mappings: make(map[uint16]uint32)}
+	go f.serve()
+	return f
+}
+
+// serve answers NAT-PMP requests.
+func (f *fakeNATPMP) serve() {
+	buf := make([]byte, 16)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		resp := make([]byte, 16)
+		resp[1] = buf[1] + 128
+		switch {
+		case n == 2 && buf[1] == natpmpOpExternalAddress:
+			copy(resp[8:12], net.IPv4(198, 51, 100, 4).To4())
+			resp = resp[:12]
+		case n == 12 && buf[1] == natpmpOpMapTCP:
+			port := binary.BigEndian.Uint16(buf[4:6])
+			lifetime := binary.BigEndian.Uint32(buf[8:12])
+			external := port
+			if port == 1 {
+				// Simulate a port that is already taken.
+				external = 2
+			}
+			f.mu.Lock()
+			if lifetime == 0 {
+				delete(f.mappings, port)
+			} else {
+				f.mappings[port] = lifetime
+			}
+			f.mu.Unlock()
+			copy(resp[8:12], buf[4:6])
+			binary.BigEndian.PutUint16(resp[10:12], external)
+			copy(resp[12:16], buf[8:12])
+		default:
+			binary.BigEndian.PutUint16(resp[2:4], 5) // unsupported opcode
+		}
+		f.conn.WriteTo(resp, addr)
+	}
+}
+
+// TestNATPMP forwards a port on a fake NAT-PMP router.
+func TestNATPMP(t *testing.T) {
+	f := newFakeNATPMP(t)
+	defer f.conn.Close()
+	n := &natPMP{gateway: f.conn.LocalAddr().(*net.UDPAddr)}
+
+	ip, err := n.ExternalIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "198.51.100.4" {
+		t.Fatal("wrong external IP:", ip)
+	}
+
+	if err := n.AddPortMapping(9981, "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	lifetime := f.mappings[9981]
+	f.mu.Unlock()
+	if lifetime != 3600 {
+		t.Fatal("port was mapped with wrong lifetime:", lifetime)
+	}
+	if err := n.DeletePortMapping(9981); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	_, exists := f.mappings[9981]
+	f.mu.Unlock()
+	if exists {
+		t.Fatal("port mapping was not deleted")
+	}
+
+	// If the router assigns a different port, the mapping is useless and
+	// should be removed.
+	if err := n.AddPortMapping(1, "", time.Hour); err == nil {
+		t.Fatal("expected error when router assigns a different port")
+	}
+	f.mu.Lock()
+	_, exists = f.mappings[1]
+	f.mu.Unlock()
+	if exists {
+		t.Fatal("unusable port mapping was not deleted")
+	}
+}
+
+// TestNATPMPNoRouter checks that requests give up when nothing answers.
+func TestNATPMPNoRouter(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	n := &natPMP{gateway: l.LocalAddr().(*net.UDPAddr)}
+	if _, err := n.ExternalIP(); err == nil {
+		t.Fatal("expected error from unresponsive router")
+	}
+}
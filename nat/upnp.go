@@ -0,0 +1,239 @@
+package nat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ssdpAddr is the multicast address that UPnP devices listen on.
+	ssdpAddr = "239.255.255.250:1900"
+
+	// ssdpSearch is the device type searched for. Every UPnP router is an
+	// InternetGatewayDevice (IGD).
+	ssdpSearch = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+
+	// maxDescriptionSize is the largest device description that will be read.
+	maxDescriptionSize = 1 << 20
+)
+
+var (
+	errNoIGD = errors.New("no UPnP router found")
+)
+
+// upnpDevice is a device in a UPnP device description. Routers describe the
+// WAN connection service as a service of a nested device.
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+// upnpService is a service in a UPnP device description.
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// upnpRoot is the root of a UPnP device description.
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// findService returns the WAN connection service of a device, searching
+// nested devices.
+func (d upnpDevice) findService() (upnpService, bool) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return s, true
+		}
+	}
+	for _, child := range d.Devices {
+		if s, ok := child.findService(); ok {
+			return s, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// An igd is a UPnP router, controlled with SOAP requests to its WAN
+// connection service.
+type igd struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+	client      http.Client
+}
+
+// discoverUPnP sends an SSDP search to addr and returns the first router that
+// responds with a usable description.
+func discoverUPnP(addr string, timeout time.Duration) (*igd, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %v\r\nST: %v\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n", ssdpAddr, ssdpSearch)
+	if _, err := conn.WriteTo([]byte(search), raddr); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, errNoIGD
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if !strings.Contains(resp.Header.Get("ST"), "InternetGatewayDevice") {
+			continue
+		}
+		if d, err := fetchIGD(resp.Header.Get("Location"), timeout); err == nil {
+			return d, nil
+		}
+	}
+}
+
+// fetchIGD downloads and parses the device description at location.
+func fetchIGD(location string, timeout time.Duration) (*igd, error) {
+	locURL, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var root upnpRoot
+	if err := xml.NewDecoder(&io.LimitedReader{R: resp.Body, N: maxDescriptionSize}).Decode(&root); err != nil {
+		return nil, err
+	}
+	service, ok := root.Device.findService()
+	if !ok {
+		return nil, errors.New("router does not provide a WAN connection service")
+	}
+
+	base := locURL
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return nil, err
+		}
+	}
+	controlURL, err := base.Parse(service.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(locURL.Host)
+	if err != nil {
+		host = locURL.Host
+	}
+	localIP, err := localIPFor(host)
+	if err != nil {
+		return nil, err
+	}
+	return &igd{
+		controlURL:  controlURL.String(),
+		serviceType: service.ServiceType,
+		localIP:     localIP,
+		client:      client,
+	}, nil
+}
+
+// soapEnvelope is the body of a response to a SOAP request. Only the
+// elements used by igd are decoded.
+type soapEnvelope struct {
+	Body struct {
+		Fault *struct {
+			String string `xml:"faultstring"`
+		} `xml:"Fault"`
+		Response struct {
+			ExternalIPAddress string `xml:"NewExternalIPAddress"`
+		} `xml:",any"`
+	}
+}
+
+// call performs a SOAP request to the router's WAN connection service. args
+// are the XML arguments of the action.
+func (d *igd) call(action, args string) (soapEnvelope, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + d.serviceType + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+	req, err := http.NewRequest("POST", d.controlURL, strings.NewReader(body))
+	if err != nil {
+		return soapEnvelope{}, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+d.serviceType+"#"+action+`"`)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return soapEnvelope{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxDescriptionSize})
+	if err != nil {
+		return soapEnvelope{}, err
+	}
+	var env soapEnvelope
+	// A failed action may return a fault without a valid envelope, so decode
+	// errors are only reported for successful responses.
+	decodeErr := xml.Unmarshal(data, &env)
+	if env.Body.Fault != nil {
+		return soapEnvelope{}, errors.New("router returned error: " + env.Body.Fault.String)
+	} else if resp.StatusCode != http.StatusOK {
+		return soapEnvelope{}, errors.New("router returned status " + resp.Status)
+	}
+	return env, decodeErr
+}
+
+// ExternalIP implements the NAT interface.
+func (d *igd) ExternalIP() (net.IP, error) {
+	env, err := d.call("GetExternalIPAddress", "")
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(env.Body.Response.ExternalIPAddress))
+	if ip == nil {
+		return nil, errors.New("router returned invalid IP address")
+	}
+	return ip, nil
+}
+
+// AddPortMapping implements the NAT interface.
+func (d *igd) AddPortMapping(port uint16, description string, lifetime time.Duration) error {
+	var desc bytes.Buffer
+	xml.EscapeText(&desc, []byte(description))
+	args := fmt.Sprintf("<NewRemoteHost></NewRemoteHost><NewExternalPort>%d</NewExternalPort><NewProtocol>TCP</NewProtocol>"+
+		"<NewInternalPort>%d</NewInternalPort><NewInternalClient>%v</NewInternalClient><NewEnabled>1</NewEnabled>"+
+		"<NewPortMappingDescription>%v</NewPortMappingDescription><NewLeaseDuration>%d</NewLeaseDuration>",
+		port, port, d.localIP, desc.String(), int(lifetime/time.Second))
+	_, err := d.call("AddPortMapping", args)
+	return err
+}
+
+// DeletePortMapping implements the NAT interface.
+func (d *igd) DeletePortMapping(port uint16) error {
+	args := fmt.Sprintf("<NewRemoteHost></NewRemoteHost><NewExternalPort>%d</NewExternalPort><NewProtocol>TCP</NewProtocol>", port)
+	_, err := d.call("DeletePortMapping", args)
+	return err
+}
@@ -0,0 +1,189 @@
+package nat
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIGDDescription describes a router whose WAN connection service is
+// nested two devices deep, as on most real routers.
+const fakeIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+				<controlURL>/l3f</controlURL>
+			</service>
+		</serviceList>
+		<deviceList>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+				<deviceList>
+					<device>
+						<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+						<serviceList>
+							<service>
+								<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+								<controlURL>/ctl/IPConn</controlURL>
+							</service>
+						</serviceList>
+					</device>
+				</deviceList>
+			</device>
+		</deviceList>
+	</device>
+</root>`
+
+// A fakeIGD is a UPnP router that answers SSDP searches and SOAP requests on
+// the loopback interface.
+type fakeIGD struct {
+	ssdp     *net.UDPConn
+	http     *httptest.Server
+	mappings map[string]string // external port -> internal client
+	mu       sync.Mutex
+}
+
+var soapArg = regexp.MustCompile(`<(New\w+)>([^<]*)</New\w+>`)
+
+// newFakeIGD starts a fake router.
+func newFakeIGD(t *testing.T) *fakeIGD {
+	ssdp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIGD{ssdp: ssdp, mappings: make(map[string]string)}
+	f.http = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	go f.serveSSDP()
+	return f
+}
+
+// Close stops the fake router.
+func (f *fakeIGD) Close() {
+	f.ssdp.Close()
+	f.http.Close()
+}
+
+// serveSSDP answers searches for InternetGatewayDevices.
+func (f *fakeIGD) serveSSDP() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := f.ssdp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !strings.Contains(string(buf[:n]), ssdpSearch) {
+			continue
+		}
+		resp := "HTTP/1.1 200 OK\r\nST: " + ssdpSearch + "\r\nLOCATION: " + f.http.URL + "/desc.xml\r\n\r\n"
+		f.ssdp.WriteTo([]byte(resp), addr)
+	}
+}
+
+// serveHTTP serves the device description and SOAP requests.
+func (f *fakeIGD) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/desc.xml" {
+		w.Write([]byte(fakeIGDDescription))
+		return
+	} else if req.URL.Path != "/ctl/IPConn" {
+		http.NotFound(w, req)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	args := make(map[string]string)
+	for _, m := range soapArg.FindAllStringSubmatch(string(body), -1) {
+		args[m[1]] = m[2]
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	action := req.Header.Get("SOAPAction")
+	var result string
+	switch {
+	case strings.HasSuffix(action, `#GetExternalIPAddress"`):
+		result = "<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>"
+	case strings.HasSuffix(action, `#AddPortMapping"`):
+		if args["NewExternalPort"] == "1" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><faultstring>UPnPError</faultstring></s:Fault></s:Body></s:Envelope>`))
+			return
+		}
+		f.mappings[args["NewExternalPort"]] = args["NewInternalClient"]
+	case strings.HasSuffix(action, `#DeletePortMapping"`):
+		delete(f.mappings, args["NewExternalPort"])
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	name := strings.Trim(action[strings.Index(action, "#")+1:], `"`)
+	w.Write([]byte(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+		`<u:` + name + `Response xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">` + result + `</u:` + name + `Response></s:Body></s:Envelope>`))
+}
+
+// mapping returns the internal client of a mapped port.
+func (f *fakeIGD) mapping(port string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	client, exists := f.mappings[port]
+	return client, exists
+}
+
+// TestUPnP discovers a fake router and forwards a port on it.
+func TestUPnP(t *testing.T) {
+	f := newFakeIGD(t)
+	defer f.Close()
+
+	d, err := discoverUPnP(f.ssdp.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(d.controlURL, "/ctl/IPConn") {
+		t.Fatal("wrong control URL:", d.controlURL)
+	}
+
+	ip, err := d.ExternalIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "203.0.113.7" {
+		t.Fatal("wrong external IP:", ip)
+	}
+
+	if err := d.AddPortMapping(9981, "Sia <test>", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if client, exists := f.mapping("9981"); !exists || client != "127.0.0.1" {
+		t.Fatal("port was not mapped to this computer:", client)
+	}
+	if err := d.DeletePortMapping(9981); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := f.mapping("9981"); exists {
+		t.Fatal("port mapping was not deleted")
+	}
+
+	// Faults should be returned as errors.
+	if err := d.AddPortMapping(1, "Sia", time.Hour); err == nil || !strings.Contains(err.Error(), "UPnPError") {
+		t.Fatal("expected fault, got", err)
+	}
+}
+
+// TestUPnPNoRouter checks that discovery times out when nothing answers.
+func TestUPnPNoRouter(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := discoverUPnP(l.LocalAddr().String(), 100*time.Millisecond); err != errNoIGD {
+		t.Fatal("expected errNoIGD, got", err)
+	}
+}
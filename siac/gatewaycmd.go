@@ -2,10 +2,14 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 
 	"github.com/NebulousLabs/Sia/api"
+	"github.com/NebulousLabs/Sia/modules"
 )
 
 var (
@@ -30,12 +34,40 @@ var (
 		Run:   wrap(gatewayremovecmd),
 	}
 
+	gatewayBansCmd = &cobra.Command{
+		Use:   "bans",
+		Short: "View a list of banned peers",
+		Long:  "View the peers that the gateway refuses to connect to.",
+		Run:   wrap(gatewaybanscmd),
+	}
+
+	gatewayBanCmd = &cobra.Command{
+		Use:   "ban [address] [duration]",
+		Short: "Ban a peer",
+		Long:  "Disconnect from a peer and refuse to reconnect to it for the given duration, e.g. \"12h\".\nIf no duration is given, the peer is banned for 24 hours.",
+		Run:   gatewaybancmd,
+	}
+
+	gatewayUnbanCmd = &cobra.Command{
+		Use:   "unban [address]",
+		Short: "Unban a peer",
+		Long:  "Lift a ban on a peer.",
+		Run:   wrap(gatewayunbancmd),
+	}
+
 	gatewayStatusCmd = &cobra.Command{
 		Use:   "status",
 		Short: "View a list of peers",
 		Long:  "View the current peer list.",
 		Run:   wrap(gatewaystatuscmd),
 	}
+
+	gatewayStatsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "View bandwidth and RPC statistics",
+		Long:  "View the traffic exchanged with each peer, and the calls, errors, and latency of each RPC.",
+		Run:   wrap(gatewaystatscmd),
+	}
 )
 
 func gatewayaddcmd(addr string) {
@@ -56,6 +88,50 @@ func gatewayremovecmd(addr string) {
 	fmt.Println("Removed", addr, "from peer list.")
 }
 
+func gatewaybanscmd() {
+	var bans api.GatewayBans
+	err := getAPI("/gateway/peers/ban", &bans)
+	if err != nil {
+		fmt.Println("Could not get ban list:", err)
+		return
+	}
+	if len(bans.Bans) == 0 {
+		fmt.Println("No banned peers.")
+		return
+	}
+	fmt.Println(len(bans.Bans), "banned peers:")
+	for _, b := range bans.Bans {
+		fmt.Printf("\t%v (%v) until %v\n", b.Address, b.Reason, b.Expiry.Format("Jan 2 15:04"))
+	}
+}
+
+// special because the duration is optional
+func gatewaybancmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		cmd.Usage()
+		return
+	}
+	addr, duration := args[0], "24h"
+	if len(args) == 2 {
+		duration = args[1]
+	}
+	err := post("/gateway/peers/ban/add", "address="+addr+"&duration="+duration)
+	if err != nil {
+		fmt.Println("Could not ban peer:", err)
+		return
+	}
+	fmt.Println("Banned", addr, "for", duration+".")
+}
+
+func gatewayunbancmd(addr string) {
+	err := post("/gateway/peers/ban/remove", "address="+addr)
+	if err != nil {
+		fmt.Println("Could not unban peer:", err)
+		return
+	}
+	fmt.Println("Unbanned", addr+".")
+}
+
 func gatewaystatuscmd() {
 	var info api.GatewayInfo
 	err := getAPI("/gateway/status", &info)
@@ -64,12 +140,54 @@ func gatewaystatuscmd() {
 		return
 	}
 	fmt.Println("Address:", info.Address)
+	fmt.Println("Node ID:", info.ID)
 	if len(info.Peers) == 0 {
 		fmt.Println("No peers to show.")
 		return
 	}
 	fmt.Println(len(info.Peers), "active peers:")
 	for _, peer := range info.Peers {
-		fmt.Println("\t", peer)
+		fmt.Println("\t", peer.NetAddress, peer.ID)
+	}
+}
+
+// printRPCStats prints a table of RPC statistics, sorted by name.
+func printRPCStats(title string, stats map[string]modules.RPCStats) {
+	if len(stats) == 0 {
+		return
+	}
+	var names []string
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println(title + ":")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tRPC\tCalls\tErrors\tIn\tOut\tAvg Latency\tMax Latency")
+	for _, name := range names {
+		s := stats[name]
+		fmt.Fprintf(w, "\t%v\t%v\t%v\t%v B\t%v B\t%v\t%v\n", name, s.Calls, s.Errors, s.BytesIn, s.BytesOut, s.AvgLatency, s.MaxLatency)
+	}
+	w.Flush()
+}
+
+func gatewaystatscmd() {
+	var info api.GatewayInfo
+	err := getAPI("/gateway/status", &info)
+	if err != nil {
+		fmt.Println("Could not get gateway stats:", err)
+		return
+	}
+	fmt.Printf("Total: %v B in, %v B out\n", info.Stats.BytesIn, info.Stats.BytesOut)
+	if len(info.Peers) != 0 {
+		fmt.Println("Peers:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "\tAddress\tRPCs\tErrors\tIn\tOut")
+		for _, peer := range info.Peers {
+			fmt.Fprintf(w, "\t%v\t%v\t%v\t%v B\t%v B\n", peer.NetAddress, peer.Stats.RPCs, peer.Stats.Errors, peer.Stats.BytesIn, peer.Stats.BytesOut)
+		}
+		w.Flush()
 	}
+	printRPCStats("Outgoing RPCs", info.Stats.Outgoing)
+	printRPCStats("Incoming RPCs", info.Stats.Incoming)
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/NebulousLabs/Sia/api"
 	"github.com/NebulousLabs/Sia/modules"
 )
 
@@ -29,7 +30,13 @@ Available settings:
 	maxduration
 	windowsize
 	price (in SC per GB per month)
-	collateral`,
+	collateral
+	acceptingcontracts (false to stop forming new contracts)
+	maxconns
+	maxconnsperip
+	rpctimeout (e.g. 2m)
+	uploadrate (in bytes per second)
+	downloadrate (in bytes per second)`,
 		Run: wrap(hostconfigcmd),
 	}
 
@@ -40,6 +47,13 @@ Available settings:
 The --force flag can be used to override connectivity checks.`,
 		Run: wrap(hostannouncecmd)}
 
+	hostContractsCmd = &cobra.Command{
+		Use:   "contracts",
+		Short: "View host contracts",
+		Long:  "View the host's contract ledger, including collateral, expected and realized revenue, and missed proofs.",
+		Run:   wrap(hostcontractscmd),
+	}
+
 	hostStatusCmd = &cobra.Command{
 		Use:   "status",
 		Short: "View host settings",
@@ -90,13 +104,50 @@ func hoststatuscmd() {
 	// convert price to SC/GB/mo
 	price := new(big.Rat).SetInt(info.Price.Big())
 	price.Mul(price, big.NewRat(4320, 1e24/1e9))
+	accepting := "yes"
+	if !info.AcceptingContracts {
+		accepting = "no (existing contracts are still served)"
+	}
 	fmt.Printf(`Host settings:
+Accepting:    %v
 Storage:      %v (%v used)
 Price:        %v SC per GB per month
 Collateral:   %v
 Max Filesize: %v
 Max Duration: %v
 Contracts:    %v
-`, filesizeUnits(info.TotalStorage), filesizeUnits(info.TotalStorage-info.StorageRemaining),
-		price.FloatString(3), info.Collateral, info.MaxFilesize, info.MaxDuration, info.NumContracts)
+Profit:       %v
+Missed:       %v proofs
+Failing:      %v obligations
+Connections:  %v (max %v, %v per IP)
+`, accepting, filesizeUnits(info.TotalStorage), filesizeUnits(info.TotalStorage-info.StorageRemaining),
+		price.FloatString(3), info.Collateral, info.MaxFilesize, info.MaxDuration, info.NumContracts,
+		info.Profit, info.MissedProofs, len(info.FailingObligations),
+		info.ActiveConns, info.NetworkLimits.MaxConns, info.NetworkLimits.MaxConnsPerIP)
+}
+
+func hostcontractscmd() {
+	hc := new(api.HostContracts)
+	err := getAPI("/host/contracts", hc)
+	if err != nil {
+		fmt.Println("Could not fetch host contracts:", err)
+		return
+	}
+	if len(hc.Contracts) == 0 {
+		fmt.Println("No contracts have been formed.")
+		return
+	}
+	fmt.Println("Contracts:")
+	for _, c := range hc.Contracts {
+		fmt.Printf(`%v
+	Status:     %v
+	Size:       %v
+	Window:     %v - %v
+	Collateral: %v
+	Expected:   %v
+	Realized:   %v
+	Fees:       %v
+`, c.ID, c.Status, filesizeUnits(int64(c.FileSize)), c.WindowStart, c.WindowEnd,
+			c.LockedCollateral, c.ExpectedRevenue, c.RealizedRevenue, c.FeesPaid)
+	}
 }
@@ -6,15 +6,71 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/NebulousLabs/Sia/api"
+	"github.com/NebulousLabs/Sia/modules"
 )
 
 var (
 	hostdbCmd = &cobra.Command{
 		Use:   "hostdb",
 		Short: "List active hosts on the network",
-		Long:  "List active hosts on the network",
+		Long:  "List active hosts on the network, along with their uptime and latency.",
 		Run:   wrap(hostdbhostscmd),
 	}
+
+	hostdbScanCmd = &cobra.Command{
+		Use:   "scan",
+		Short: "View the host scanner",
+		Long:  "View the scan settings of the hostdb and the number of hosts waiting to be scanned.",
+		Run:   wrap(hostdbscancmd),
+	}
+
+	hostdbScanAllCmd = &cobra.Command{
+		Use:   "all",
+		Short: "Scan every host now",
+		Long:  "Scan every host known to the hostdb without waiting for the next round of scanning.",
+		Run:   wrap(hostdbscanallcmd),
+	}
+
+	hostdbScanHostCmd = &cobra.Command{
+		Use:   "host [address]",
+		Short: "Scan a host now",
+		Long:  "Scan a single host without waiting for the next round of scanning.",
+		Run:   wrap(hostdbscanhostcmd),
+	}
+
+	hostdbScanConfigCmd = &cobra.Command{
+		Use:   "config [setting] [value]",
+		Short: "Modify the scan settings",
+		Long: `Modify the scan settings of the hostdb.
+Available settings:
+	minscansleep (e.g. 30m)
+	maxscansleep (e.g. 2h)
+	scanthreads`,
+		Run: wrap(hostdbscanconfigcmd),
+	}
+
+	hostdbFilterCmd = &cobra.Command{
+		Use:   "filter",
+		Short: "View the host filter",
+		Long: `View the allow and deny lists of the hostdb. If the allow list is not empty,
+only hosts matching it are used. Hosts matching the deny list are never used.`,
+		Run: wrap(hostdbfiltercmd),
+	}
+
+	hostdbFilterAddCmd = &cobra.Command{
+		Use:   "add [allow|deny] [address|pubkey|subnet] [value]",
+		Short: "Add an entry to the host filter",
+		Long: `Add an entry to the allow or deny list of the hostdb.
+Public keys are given in hex, and subnets in CIDR notation, e.g. 10.0.0.0/8.`,
+		Run: wrap(hostdbfilteraddcmd),
+	}
+
+	hostdbFilterRemoveCmd = &cobra.Command{
+		Use:   "remove [allow|deny] [address|pubkey|subnet] [value]",
+		Short: "Remove an entry from the host filter",
+		Long:  "Remove an entry from the allow or deny list of the hostdb.",
+		Run:   wrap(hostdbfilterremovecmd),
+	}
 )
 
 func hostdbhostscmd() {
@@ -28,8 +84,108 @@ func hostdbhostscmd() {
 		fmt.Println("No known active hosts")
 		return
 	}
+	hs := new(api.HostDBStats)
+	err = getAPI("/hostdb/hosts/stats", hs)
+	if err != nil {
+		fmt.Println("Could not fetch host stats:", err)
+		return
+	}
+	stats := make(map[modules.NetAddress]modules.HostStats)
+	for _, s := range hs.Hosts {
+		stats[s.IPAddress] = s
+	}
 	fmt.Println("Active hosts:")
+	fmt.Println("\tAddress\tUptime\tLatency (p50/p90)\tScans")
 	for _, host := range info.Hosts {
-		fmt.Printf("\t%v\n", host.IPAddress)
+		s := stats[host.IPAddress]
+		fmt.Printf("\t%v\t%.1f%%\t%v/%v\t%v\n", host.IPAddress, s.Uptime*100, s.LatencyP50, s.LatencyP90, len(s.Scans))
+	}
+}
+
+func hostdbscancmd() {
+	status := new(modules.HostDBScanStatus)
+	err := getAPI("/hostdb/scan/status", status)
+	if err != nil {
+		fmt.Println("Could not fetch scan status:", err)
+		return
+	}
+	fmt.Printf(`Scan interval: %v - %v
+Scan threads:  %v
+Queued:        %v hosts
+Scanning:      %v hosts
+`, status.MinScanSleep, status.MaxScanSleep, status.ScanThreads, status.QueueDepth, status.ActiveScans)
+}
+
+func hostdbscanallcmd() {
+	err := post("/hostdb/scan/start", "")
+	if err != nil {
+		fmt.Println("Could not start scan:", err)
+		return
+	}
+	fmt.Println("All hosts queued for scanning.")
+}
+
+func hostdbscanhostcmd(addr string) {
+	err := post("/hostdb/scan/start", "address="+addr)
+	if err != nil {
+		fmt.Println("Could not start scan:", err)
+		return
+	}
+	fmt.Println("Host queued for scanning.")
+}
+
+func hostdbscanconfigcmd(param, value string) {
+	err := post("/hostdb/scan/configure", param+"="+value)
+	if err != nil {
+		fmt.Println("Could not update scan settings:", err)
+		return
+	}
+	fmt.Println("Scan settings updated.")
+}
+
+// printFilterList prints the entries of a host filter list.
+func printFilterList(name string, list modules.HostFilterList) {
+	if len(list.Addresses) == 0 && len(list.PublicKeys) == 0 && len(list.Subnets) == 0 {
+		fmt.Printf("%v: empty\n", name)
+		return
+	}
+	fmt.Printf("%v:\n", name)
+	for _, addr := range list.Addresses {
+		fmt.Printf("\taddress %v\n", addr)
+	}
+	for _, pk := range list.PublicKeys {
+		fmt.Printf("\tpubkey  %x\n", pk)
+	}
+	for _, subnet := range list.Subnets {
+		fmt.Printf("\tsubnet  %v\n", subnet)
+	}
+}
+
+func hostdbfiltercmd() {
+	filter := new(modules.HostFilter)
+	err := getAPI("/hostdb/filter", filter)
+	if err != nil {
+		fmt.Println("Could not fetch host filter:", err)
+		return
+	}
+	printFilterList("Allow", filter.Allow)
+	printFilterList("Deny", filter.Deny)
+}
+
+func hostdbfilteraddcmd(list, kind, value string) {
+	err := post("/hostdb/filter/add", "list="+list+"&"+kind+"="+value)
+	if err != nil {
+		fmt.Println("Could not add entry to host filter:", err)
+		return
+	}
+	fmt.Printf("Added %v %v to the %v list.\n", kind, value, list)
+}
+
+func hostdbfilterremovecmd(list, kind, value string) {
+	err := post("/hostdb/filter/remove", "list="+list+"&"+kind+"="+value)
+	if err != nil {
+		fmt.Println("Could not remove entry from host filter:", err)
+		return
 	}
+	fmt.Printf("Removed %v %v from the %v list.\n", kind, value, list)
 }
@@ -161,9 +161,12 @@ func main() {
 	root.PersistentFlags().BoolVarP(&force, "force", "f", false, "force certain commands")
 
 	root.AddCommand(hostCmd)
-	hostCmd.AddCommand(hostConfigCmd, hostAnnounceCmd, hostStatusCmd)
+	hostCmd.AddCommand(hostConfigCmd, hostAnnounceCmd, hostContractsCmd, hostStatusCmd)
 
 	root.AddCommand(hostdbCmd)
+	hostdbCmd.AddCommand(hostdbFilterCmd, hostdbScanCmd)
+	hostdbScanCmd.AddCommand(hostdbScanAllCmd, hostdbScanHostCmd, hostdbScanConfigCmd)
+	hostdbFilterCmd.AddCommand(hostdbFilterAddCmd, hostdbFilterRemoveCmd)
 	hostCmd.AddCommand(hostdbCmd)
 
 	root.AddCommand(minerCmd)
@@ -180,7 +183,7 @@ func main() {
 		renterFilesShareCmd, renterFilesShareASCIICmd, renterFilesUploadCmd)
 
 	root.AddCommand(gatewayCmd)
-	gatewayCmd.AddCommand(gatewayAddCmd, gatewayRemoveCmd, gatewayStatusCmd)
+	gatewayCmd.AddCommand(gatewayAddCmd, gatewayRemoveCmd, gatewayStatusCmd, gatewayStatsCmd, gatewayBansCmd, gatewayBanCmd, gatewayUnbanCmd)
 
 	root.AddCommand(updateCmd)
 	updateCmd.AddCommand(updateCheckCmd, updateApplyCmd)
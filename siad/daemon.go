@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/NebulousLabs/Sia/api"
 	"github.com/NebulousLabs/Sia/modules"
@@ -19,11 +22,50 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// bootstrapPeers returns the peers that siad connects to on startup. The
+// configured bootstrap peers replace the default peers, which are only used
+// on the main network. Peers from the peers file are always added.
+func bootstrapPeers() ([]modules.NetAddress, error) {
+	var peers []modules.NetAddress
+	if config.Siad.BootstrapPeers != "" {
+		for _, addr := range strings.Split(config.Siad.BootstrapPeers, ",") {
+			addr = strings.TrimSpace(addr)
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return nil, errors.New("invalid bootstrap peer: " + addr)
+			}
+			peers = append(peers, modules.NetAddress(addr))
+		}
+	} else if config.Siad.Network == "" {
+		peers = append(peers, modules.BootstrapPeers...)
+	}
+	if config.Siad.PeersFile != "" {
+		filePeers, err := modules.ReadPeersFile(config.Siad.PeersFile)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, filePeers...)
+	}
+	return peers, nil
+}
+
 // startDaemonCmd uses the config parameters to start siad.
 func startDaemon() error {
 	// Establish multithreading.
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	// Route outbound connections through the proxy, if one is set.
+	if err := modules.SetProxy(config.Siad.Proxy); err != nil {
+		return err
+	}
+
+	// Join a private network, if one is set. This must be done before the
+	// gateway is created.
+	modules.Network = config.Siad.Network
+	peers, err := bootstrapPeers()
+	if err != nil {
+		return err
+	}
+
 	// Create all of the modules.
 	gateway, err := gateway.New(config.Siad.RPCaddr, filepath.Join(config.Siad.SiaDir, modules.GatewayDir))
 	if err != nil {
@@ -45,14 +87,15 @@ func startDaemon() error {
 	if err != nil {
 		return err
 	}
-	hostdb, err := hostdb.New(state, gateway)
+	hostdb, err := hostdb.New(state, gateway, filepath.Join(config.Siad.SiaDir, modules.HostDBDir))
 	if err != nil {
 		return err
 	}
-	host, err := host.New(state, hostdb, tpool, wallet, config.Siad.HostAddr, filepath.Join(config.Siad.SiaDir, modules.HostDir))
+	host, err := host.New(state, gateway, hostdb, tpool, wallet, config.Siad.HostAddr, filepath.Join(config.Siad.SiaDir, modules.HostDir))
 	if err != nil {
 		return err
 	}
+	gateway.AddService(modules.ServiceHost)
 	renter, err := renter.New(state, hostdb, wallet, filepath.Join(config.Siad.SiaDir, modules.RenterDir))
 	if err != nil {
 		return err
@@ -64,8 +107,8 @@ func startDaemon() error {
 
 	// Bootstrap to the network.
 	if !config.Siad.NoBootstrap {
-		for i := range modules.BootstrapPeers {
-			go gateway.Connect(modules.BootstrapPeers[i])
+		for i := range peers {
+			go gateway.Connect(peers[i])
 		}
 	}
 
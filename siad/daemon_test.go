@@ -1,11 +1,15 @@
 package main
 
 import (
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
 )
 
 // TestStartDaemon probes the startDaemon function.
@@ -36,3 +40,56 @@ func TestStartDaemon(t *testing.T) {
 	}
 	resp.Body.Close()
 }
+
+// TestBootstrapPeers checks which peers are used for bootstrapping.
+func TestBootstrapPeers(t *testing.T) {
+	defer func() {
+		config.Siad.BootstrapPeers = ""
+		config.Siad.PeersFile = ""
+		config.Siad.Network = ""
+	}()
+
+	// By default, the main network's peers are used.
+	peers, err := bootstrapPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != len(modules.BootstrapPeers) {
+		t.Fatal("default peers were not used:", peers)
+	}
+
+	// Private networks do not use the main network's peers.
+	config.Siad.Network = "testnet"
+	peers, err = bootstrapPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Fatal("private network used default peers:", peers)
+	}
+
+	// Configured peers replace the defaults, and peers from the peers file
+	// are added.
+	testDir := build.TempDir("siad", "TestBootstrapPeers")
+	if err := os.MkdirAll(testDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	config.Siad.PeersFile = filepath.Join(testDir, "peers.txt")
+	if err := ioutil.WriteFile(config.Siad.PeersFile, []byte("10.0.0.3:9981\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Siad.Network = ""
+	config.Siad.BootstrapPeers = "10.0.0.1:9981, 10.0.0.2:9981"
+	peers, err = bootstrapPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 3 || peers[0] != "10.0.0.1:9981" || peers[1] != "10.0.0.2:9981" || peers[2] != "10.0.0.3:9981" {
+		t.Fatal("wrong bootstrap peers:", peers)
+	}
+
+	config.Siad.BootstrapPeers = "10.0.0.1"
+	if _, err := bootstrapPeers(); err == nil {
+		t.Fatal("expected error for peer without port")
+	}
+}
@@ -22,11 +22,15 @@ var (
 // compatible with gcfg.
 type Config struct {
 	Siad struct {
-		NoBootstrap bool
+		NoBootstrap    bool
+		BootstrapPeers string
+		PeersFile      string
+		Network        string
 
 		APIaddr  string
 		RPCaddr  string
 		HostAddr string
+		Proxy    string
 
 		SiaDir string
 	}
@@ -63,9 +67,13 @@ func main() {
 
 	// Set default values, which have the lowest priority.
 	root.PersistentFlags().BoolVarP(&config.Siad.NoBootstrap, "no-bootstrap", "n", false, "disable bootstrapping on this run")
+	root.PersistentFlags().StringVarP(&config.Siad.BootstrapPeers, "bootstrap-peers", "b", "", "comma-separated list of host:port peers to bootstrap from, instead of the default peers")
+	root.PersistentFlags().StringVarP(&config.Siad.PeersFile, "peers-file", "p", "", "file listing additional peers to bootstrap from, one host:port per line")
+	root.PersistentFlags().StringVarP(&config.Siad.Network, "network", "N", "", "name of a private network to join instead of the main network")
 	root.PersistentFlags().StringVarP(&config.Siad.APIaddr, "api-addr", "a", "localhost:9980", "which host:port the API server listens on")
 	root.PersistentFlags().StringVarP(&config.Siad.RPCaddr, "rpc-addr", "r", ":9981", "which port the gateway listens on")
 	root.PersistentFlags().StringVarP(&config.Siad.HostAddr, "host-addr", "H", ":9982", "which port the host listens on")
+	root.PersistentFlags().StringVarP(&config.Siad.Proxy, "proxy", "x", "", "route outbound connections through a SOCKS5 proxy, e.g. 127.0.0.1:9050 for Tor")
 	root.PersistentFlags().StringVarP(&config.Siad.SiaDir, "sia-directory", "d", "", "location of the sia directory")
 
 	// Parse cmdline flags, overwriting both the default values and the config
@@ -0,0 +1,181 @@
+// Package socks implements a SOCKS5 client (RFC 1928), which is used to route
+// outbound connections through a proxy such as Tor.
+package socks
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion = 5
+
+	// Authentication methods.
+	authNone         = 0
+	authPassword     = 2
+	authUnacceptable = 0xff
+
+	// cmdConnect requests a TCP connection to the target.
+	cmdConnect = 1
+
+	// Address types.
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+var (
+	errAuthRejected = errors.New("proxy rejected authentication")
+	errNoAuthMethod = errors.New("proxy does not support any offered authentication method")
+	errBadResponse  = errors.New("proxy sent malformed response")
+
+	// replyErrors are the errors reported by the proxy when it cannot connect
+	// to the target.
+	replyErrors = map[byte]string{
+		1: "general SOCKS server failure",
+		2: "connection not allowed by ruleset",
+		3: "network unreachable",
+		4: "host unreachable",
+		5: "connection refused",
+		6: "TTL expired",
+		7: "command not supported",
+		8: "address type not supported",
+	}
+)
+
+// A Dialer makes TCP connections through a SOCKS5 proxy. If Username is set,
+// the Dialer authenticates with the proxy using Username and Password. Tor
+// uses different circuits for different credentials, so the credentials can
+// also be used to isolate connections from each other.
+type Dialer struct {
+	ProxyAddr string
+	Username  string
+	Password  string
+}
+
+// DialTimeout connects to addr through the proxy. addr may contain a hostname,
+// which is resolved by the proxy, so that DNS requests are not made outside of
+// the proxy.
+func (d Dialer) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", d.ProxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := d.connect(conn, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect performs the SOCKS5 handshake over conn, asking the proxy to
+// connect to addr.
+func (d Dialer) connect(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.New("invalid port: " + portStr)
+	}
+
+	// Negotiate an authentication method.
+	method := byte(authNone)
+	if d.Username != "" {
+		method = authPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	} else if resp[0] != socksVersion {
+		return errBadResponse
+	} else if resp[1] == authUnacceptable || resp[1] != method {
+		return errNoAuthMethod
+	}
+	if method == authPassword {
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	}
+
+	// Request a connection to the target.
+	req := []byte{socksVersion, cmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("hostname too long: " + host)
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, atypIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, atypIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Read the reply. The bound address it contains is not needed, but must
+	// be read so that it is not mistaken for data from the target.
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	} else if reply[0] != socksVersion {
+		return errBadResponse
+	} else if reply[1] != 0 {
+		if msg, ok := replyErrors[reply[1]]; ok {
+			return errors.New("proxy could not connect to " + addr + ": " + msg)
+		}
+		return errors.New("proxy could not connect to " + addr)
+	}
+	var addrLen int
+	switch reply[3] {
+	case atypIPv4:
+		addrLen = net.IPv4len
+	case atypIPv6:
+		addrLen = net.IPv6len
+	case atypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return errBadResponse
+	}
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// authenticate performs username/password authentication (RFC 1929).
+func (d Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return errors.New("proxy username or password too long")
+	}
+	req := []byte{1, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	} else if resp[1] != 0 {
+		return errAuthRejected
+	}
+	return nil
+}
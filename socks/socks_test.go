@@ -0,0 +1,216 @@
+package socks
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeProxy is a SOCKS5 proxy on the loopback interface. If username is
+// set, clients must authenticate. Hostnames are resolved by the proxy, and
+// the hostnames requested are recorded.
+type fakeProxy struct {
+	l         net.Listener
+	username  string
+	password  string
+	hostnames []string
+	mu        sync.Mutex
+}
+
+// newFakeProxy starts a fake proxy.
+func newFakeProxy(t *testing.T, username, password string) *fakeProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProxy{l: l, username: username, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+// serve handles a single client.
+func (p *fakeProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Method negotiation.
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	want := byte(authNone)
+	if p.username != "" {
+		want = authPassword
+	}
+	if !strings.Contains(string(methods), string([]byte{want})) {
+		conn.Write([]byte{socksVersion, authUnacceptable})
+		return
+	}
+	conn.Write([]byte{socksVersion, want})
+	if want == authPassword {
+		b := make([]byte, 2)
+		io.ReadFull(conn, b)
+		user := make([]byte, b[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, b[:1])
+		pass := make([]byte, b[0])
+		io.ReadFull(conn, pass)
+		if string(user) != p.username || string(pass) != p.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	// Connect request.
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		io.ReadFull(conn, ip)
+		host = ip.String()
+	case atypDomain:
+		l := make([]byte, 1)
+		io.ReadFull(conn, l)
+		name := make([]byte, l[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+		p.mu.Lock()
+		p.hostnames = append(p.hostnames, host)
+		p.mu.Unlock()
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{socksVersion, 5, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	// Reply with a domain bound address, to check that the client reads it.
+	conn.Write([]byte{socksVersion, 0, 0, atypDomain, 3, 'f', 'o', 'o', 0, 1})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// newEchoServer starts a server that echoes everything sent to it.
+func newEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+// echo sends a message over conn and checks that it is echoed back.
+func echo(conn net.Conn, t *testing.T) {
+	msg := []byte("hello, proxy")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != string(msg) {
+		t.Fatal("wrong echo:", string(resp))
+	}
+}
+
+// TestDial checks that connections are made through the proxy, and that
+// hostnames are resolved by the proxy.
+func TestDial(t *testing.T) {
+	target := newEchoServer(t)
+	defer target.Close()
+	p := newFakeProxy(t, "", "")
+	defer p.l.Close()
+	d := Dialer{ProxyAddr: p.l.Addr().String()}
+
+	conn, err := d.DialTimeout(target.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(conn, t)
+	conn.Close()
+
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+	conn, err = d.DialTimeout(net.JoinHostPort("localhost", port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(conn, t)
+	conn.Close()
+	p.mu.Lock()
+	hostnames := p.hostnames
+	p.mu.Unlock()
+	if len(hostnames) != 1 || hostnames[0] != "localhost" {
+		t.Fatal("hostname was not sent to proxy:", hostnames)
+	}
+
+	// Errors from the proxy should be reported.
+	target.Close()
+	if _, err := d.DialTimeout(target.Addr().String(), time.Second); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatal("expected connection refused, got", err)
+	}
+}
+
+// TestDialAuth checks username/password authentication.
+func TestDialAuth(t *testing.T) {
+	target := newEchoServer(t)
+	defer target.Close()
+	p := newFakeProxy(t, "user", "pass")
+	defer p.l.Close()
+
+	d := Dialer{ProxyAddr: p.l.Addr().String(), Username: "user", Password: "pass"}
+	conn, err := d.DialTimeout(target.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(conn, t)
+	conn.Close()
+
+	d.Password = "wrong"
+	if _, err := d.DialTimeout(target.Addr().String(), time.Second); err != errAuthRejected {
+		t.Fatal("expected errAuthRejected, got", err)
+	}
+	d.Username = ""
+	if _, err := d.DialTimeout(target.Addr().String(), time.Second); err != errNoAuthMethod {
+		t.Fatal("expected errNoAuthMethod, got", err)
+	}
+}